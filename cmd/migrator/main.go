@@ -4,36 +4,71 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"strconv"
 
-	"github.com/golang-migrate/migrate/v4"
-
-	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/migrator"
 )
 
+const usage = `usage: migrator [flags] [command]
+
+commands:
+  up          apply all pending migrations (default)
+  down [N]    roll back N migrations (default 1)
+  version     print current migration version
+  force V     set migration version without running migrations
+  status      list migrations and whether they are applied
+
+flags:
+`
+
 func main() {
 	// migrate the database
 	var storagePath, migrationsPath, migrationsTable string
 
 	flag.StringVar(&storagePath, "storage-path", "", "path to storage")
-	flag.StringVar(&migrationsPath, "migrations-path", "", "path to migrations")
-	flag.StringVar(&migrationsTable, "migrations-table", "migrations", "name of migrations table")
+	flag.StringVar(&migrationsPath, "migrations-path", "", "path to migrations, embedded migrations are used if empty")
+	flag.StringVar(&migrationsTable, "migrations-table", migrator.DefaultTable, "name of migrations table")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	if storagePath == "" || migrationsPath == "" {
-		panic("storage-path and migrations-path flags required")
+	if storagePath == "" {
+		panic("storage-path flag required")
 	}
 
-	m, err := migrate.New(
-		"file://"+migrationsPath,
-		fmt.Sprintf("sqlite3://%s?x-migrations-table=%s", storagePath, migrationsTable),
-	)
+	m, err := migrator.New(storagePath, migrationsPath, migrationsTable)
 	if err != nil {
 		panic(err)
 	}
+	defer m.Close()
 
+	cmd := flag.Arg(0)
+	if cmd == "" {
+		cmd = "up"
+	}
+
+	switch cmd {
+	case "up":
+		up(m)
+	case "down":
+		down(m, flag.Arg(1))
+	case "version":
+		version(m)
+	case "force":
+		force(m, flag.Arg(1))
+	case "status":
+		status(m)
+	default:
+		flag.Usage()
+		panic("unknown command: " + cmd)
+	}
+}
+
+func up(m *migrator.Migrator) {
 	if err := m.Up(); err != nil {
-		if errors.Is(err, migrate.ErrNoChange) {
+		if errors.Is(err, migrator.ErrNoChange) {
 			fmt.Println("no migrations to run")
 			return
 		}
@@ -42,3 +77,61 @@ func main() {
 
 	fmt.Println("migrations run successfully")
 }
+
+func down(m *migrator.Migrator, arg string) {
+	steps := 1
+	if arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			panic("down expects positive number of steps, got: " + arg)
+		}
+		steps = n
+	}
+
+	if err := m.Down(steps); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("rolled back %d migration(s)\n", steps)
+}
+
+func version(m *migrator.Migrator) {
+	v, dirty, err := m.Version()
+	if err != nil {
+		if errors.Is(err, migrator.ErrNilVersion) {
+			fmt.Println("no migrations applied")
+			return
+		}
+		panic(err)
+	}
+
+	fmt.Printf("version: %d, dirty: %v\n", v, dirty)
+}
+
+func force(m *migrator.Migrator, arg string) {
+	v, err := strconv.Atoi(arg)
+	if err != nil {
+		panic("force expects version number, got: " + arg)
+	}
+
+	if err := m.Force(v); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("forced version %d\n", v)
+}
+
+func status(m *migrator.Migrator) {
+	list, err := m.Status()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, s := range list {
+		mark := " "
+		if s.Applied {
+			mark = "x"
+		}
+		fmt.Printf("[%s] %d %s\n", mark, s.Version, s.Identifier)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -8,7 +9,9 @@ import (
 
 	"github.com/Woland-prj/microtasks_sso/internal/app"
 	"github.com/Woland-prj/microtasks_sso/internal/config"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/handlers/slogctx"
 	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/handlers/slogpretty"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/sl"
 )
 
 const (
@@ -39,8 +42,13 @@ func main() {
 	stopSignal := <-chanStop
 	log.Info("Trying stop application", slog.String("signal", stopSignal.String()))
 
-	application.GRPCServ.Stop()
-	application.HTTPServ.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancel()
+
+	if err := application.Stop(ctx); err != nil {
+		log.Error("Application stopped with errors", sl.Err(err))
+		return
+	}
 	log.Info("Application stopped")
 }
 
@@ -51,13 +59,13 @@ func mustSetupLogger(env string) *slog.Logger {
 	case envLocal:
 		log = setupPrettySlog()
 	case envDev:
-		log = slog.New(
+		log = slog.New(slogctx.NewHandler(
 			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}),
-		)
+		))
 	case envProd:
-		log = slog.New(
+		log = slog.New(slogctx.NewHandler(
 			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
-		)
+		))
 	default:
 		panic("Unknown env: " + env)
 	}
@@ -74,5 +82,5 @@ func setupPrettySlog() *slog.Logger {
 
 	handler := opts.NewPrettyHandler(os.Stdout)
 
-	return slog.New(handler)
+	return slog.New(slogctx.NewHandler(handler))
 }
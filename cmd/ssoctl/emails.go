@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/Woland-prj/microtasks_sso/internal/lib/emailaddr"
+	"github.com/Woland-prj/microtasks_sso/internal/storage/sqlite"
+)
+
+type collision struct {
+	Key   string          `json:"key"`
+	Users []collisionUser `json:"users"`
+}
+
+type collisionUser struct {
+	UID   int64  `json:"uid"`
+	Email string `json:"email"`
+}
+
+// emailCollisions prints users whose emails are equal after normalization
+// as JSON to stdout and fails if there are any. Such users must be merged
+// or renamed before normalized emails migration is applied.
+func emailCollisions(ctx context.Context, storage *sqlite.Storage) error {
+	const op = "ssoctl.emailCollisions"
+
+	emails, err := storage.ListUserEmails(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	byKey := make(map[string][]collisionUser)
+	var invalid []collisionUser
+	for uid, email := range emails {
+		u := collisionUser{UID: uid, Email: email}
+
+		key, err := emailaddr.Key(email)
+		if err != nil {
+			invalid = append(invalid, u)
+			continue
+		}
+		byKey[key] = append(byKey[key], u)
+	}
+
+	collisions := make([]collision, 0)
+	for key, users := range byKey {
+		if len(users) < 2 {
+			continue
+		}
+		slices.SortFunc(users, func(a, b collisionUser) int { return int(a.UID - b.UID) })
+		collisions = append(collisions, collision{Key: key, Users: users})
+	}
+	slices.SortFunc(collisions, func(a, b collision) int { return int(a.Users[0].UID - b.Users[0].UID) })
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(collisions); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, u := range invalid {
+		fmt.Fprintf(os.Stderr, "user %d has invalid email %q\n", u.UID, u.Email)
+	}
+
+	if len(collisions) > 0 || len(invalid) > 0 {
+		return fmt.Errorf("%s: found %d collisions and %d invalid emails", op, len(collisions), len(invalid))
+	}
+
+	return nil
+}
+
+// normalizeEmails recomputes normalized emails of all users,
+// which is needed once after normalized emails migration
+// for addresses with non-ASCII characters.
+func normalizeEmails(ctx context.Context, storage *sqlite.Storage) error {
+	const op = "ssoctl.normalizeEmails"
+
+	updated, err := storage.NormalizeEmails(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	fmt.Printf("updated %d users\n", updated)
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/Woland-prj/microtasks_sso/internal/config"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/sl"
+	"github.com/Woland-prj/microtasks_sso/internal/services"
+	"github.com/Woland-prj/microtasks_sso/internal/storage/sqlite"
+)
+
+const usage = `usage: ssoctl --config <path> <command> [flags]
+
+commands:
+  seed              create apps and users from a YAML file
+  email-collisions  list users whose emails differ only in case or form
+  normalize-emails  recompute normalized emails of all users
+`
+
+func main() {
+	// Load config, --config flag is parsed here
+	conf := config.MustLoad()
+
+	log := slog.New(
+		slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}),
+	)
+
+	if flag.NArg() == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	storage, err := sqlite.New(conf.StoragePath)
+	if err != nil {
+		log.Error("failed to create storage", sl.Err(err))
+		os.Exit(1)
+	}
+	defer storage.Close()
+
+	// Seeded passwords are chosen by operator, so breach check is skipped
+	srvs, err := services.New(log, storage, conf, nil)
+	if err != nil {
+		log.Error("failed to create services", sl.Err(err))
+		storage.Close()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+
+	switch cmd, args := flag.Arg(0), flag.Args()[1:]; cmd {
+	case "seed":
+		err = seed(ctx, srvs, args)
+	case "email-collisions":
+		err = emailCollisions(ctx, storage)
+	case "normalize-emails":
+		err = normalizeEmails(ctx, storage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		err = fmt.Errorf("unknown command: %s", cmd)
+	}
+
+	if err != nil {
+		log.Error("command failed", sl.Err(err))
+		storage.Close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/services"
+	"github.com/go-playground/validator/v10"
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+type seedFile struct {
+	Apps  []seedApp  `yaml:"apps" validate:"dive"`
+	Users []seedUser `yaml:"users" validate:"dive"`
+}
+
+type seedApp struct {
+	Name          string `yaml:"name" validate:"required"`
+	AuthSecret    string `yaml:"auth_secret"`
+	RefreshSecret string `yaml:"refresh_secret"`
+	// OrgID scopes app to existing organization
+	OrgID int64 `yaml:"org_id"`
+	// Restricted app issues tokens only to users granted access
+	Restricted bool `yaml:"restricted"`
+	// Zero TTLs fall back to global ones, others are stored in whole seconds
+	AuthTokenTTL    time.Duration `yaml:"auth_token_ttl" validate:"omitempty,gte=1s"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" validate:"omitempty,gte=1s"`
+	RequireMFA      bool          `yaml:"require_mfa"`
+	// Empty allows all
+	GrantTypes []string `yaml:"grant_types" validate:"dive,oneof=password refresh magic_link webauthn federation"`
+}
+
+type seedUser struct {
+	Email    string `yaml:"email" validate:"required,email"`
+	Password string `yaml:"password"`
+	Admin    bool   `yaml:"admin"`
+}
+
+type seedResult struct {
+	Apps  []appResult  `json:"apps"`
+	Users []userResult `json:"users"`
+}
+
+type appResult struct {
+	Name          string `json:"name"`
+	ID            int64  `json:"id"`
+	Created       bool   `json:"created"`
+	AuthSecret    string `json:"auth_secret,omitempty"`
+	RefreshSecret string `json:"refresh_secret,omitempty"`
+}
+
+type userResult struct {
+	Email    string `json:"email"`
+	UID      int64  `json:"uid"`
+	Created  bool   `json:"created"`
+	Admin    bool   `json:"admin"`
+	Password string `json:"password,omitempty"`
+}
+
+// seed creates apps and users listed in seed file if they don't exist
+// and prints generated credentials of created entities as JSON to stdout.
+// Running seed repeatedly with the same file is safe.
+func seed(ctx context.Context, srvs *services.Services, args []string) error {
+	const op = "ssoctl.seed"
+
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	path := fs.String("file", "", "path to seed file in yaml format")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if *path == "" {
+		return fmt.Errorf("%s: -file flag required", op)
+	}
+
+	var sf seedFile
+	if err := cleanenv.ReadConfig(*path, &sf); err != nil {
+		return fmt.Errorf("%s: read seed file: %w", op, err)
+	}
+
+	if err := validator.New(validator.WithRequiredStructEnabled()).Struct(sf); err != nil {
+		return fmt.Errorf("%s: invalid seed file: %w", op, err)
+	}
+
+	res := seedResult{
+		Apps:  make([]appResult, 0, len(sf.Apps)),
+		Users: make([]userResult, 0, len(sf.Users)),
+	}
+
+	for _, a := range sf.Apps {
+		app, created, err := srvs.App.EnsureApp(ctx, dtos.CreateAppDto{
+			Name:            a.Name,
+			AuthSecret:      a.AuthSecret,
+			RefreshSecret:   a.RefreshSecret,
+			OrgID:           a.OrgID,
+			Restricted:      a.Restricted,
+			AuthTokenTTL:    a.AuthTokenTTL,
+			RefreshTokenTTL: a.RefreshTokenTTL,
+			RequireMFA:      a.RequireMFA,
+			GrantTypes:      a.GrantTypes,
+		})
+		if err != nil {
+			return fmt.Errorf("%s: app %s: %w", op, a.Name, err)
+		}
+
+		r := appResult{Name: app.Name, ID: app.ID, Created: created}
+		if created {
+			r.AuthSecret = app.AuthSecret
+			r.RefreshSecret = app.RefreshSecret
+		}
+		res.Apps = append(res.Apps, r)
+	}
+
+	for _, u := range sf.Users {
+		password, generated := u.Password, false
+		if password == "" {
+			var err error
+			if password, err = srvs.Auth.GeneratePassword(); err != nil {
+				return fmt.Errorf("%s: generate password: %w", op, err)
+			}
+			generated = true
+		}
+
+		uid, created, err := srvs.Auth.EnsureUser(ctx, dtos.RegisterDto{
+			Email:    u.Email,
+			Password: password,
+		}, u.Admin)
+		if err != nil {
+			return fmt.Errorf("%s: user %s: %w", op, u.Email, err)
+		}
+
+		r := userResult{Email: u.Email, UID: uid, Created: created, Admin: u.Admin}
+		if created && generated {
+			r.Password = password
+		}
+		res.Users = append(res.Users, r)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(res)
+}
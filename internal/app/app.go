@@ -1,53 +1,208 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"sync"
+	"time"
 
 	grpc_app "github.com/Woland-prj/microtasks_sso/internal/app/grpc"
 	http_app "github.com/Woland-prj/microtasks_sso/internal/app/http"
 	"github.com/Woland-prj/microtasks_sso/internal/config"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/breach"
 	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/sl"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/migrator"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/periodic"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/tracing"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/validation"
 	"github.com/Woland-prj/microtasks_sso/internal/services"
+	authservice "github.com/Woland-prj/microtasks_sso/internal/services/auth"
 	"github.com/Woland-prj/microtasks_sso/internal/storage/sqlite"
-	"github.com/go-playground/validator/v10"
 )
 
 type App struct {
-	log      *slog.Logger
-	srvs     *services.Services
-	GRPCServ *grpc_app.App
-	HTTPServ *http_app.App
+	log           *slog.Logger
+	srvs          *services.Services
+	GRPCServ      *grpc_app.App
+	HTTPServ      *http_app.App
+	shutdownDelay time.Duration
+	closers       []closer
+}
+
+// closer is a resource released after both transports are drained.
+type closer struct {
+	name  string
+	close func(ctx context.Context) error
 }
 
 func New(
 	log *slog.Logger,
 	cfg *config.Config,
 ) *App {
+	if cfg.Storage.AutoMigrate {
+		mustMigrate(log, cfg.StoragePath)
+	}
+
+	shutdownTracing := mustSetupTracing(log, cfg.Tracing)
+
 	storage := mustCreateSqliteStorage(log, cfg.StoragePath)
-	services := services.New(
-		log, 
-		storage, 
-		cfg.TokenTTL.Auth, 
-		cfg.TokenTTL.Refresh,
-	)
-	validate := validator.New(validator.WithRequiredStructEnabled())
+	breachChecker := mustCreateBreachChecker(log, cfg.BreachCheck)
+	services := mustCreateServices(log, storage, cfg, breachChecker)
+	validate := validation.New()
 	grpcapp := grpc_app.New(log, cfg.GRPC.Port, services, validate)
 	httpapp := http_app.New(
-		log, 
-		cfg.HTTP.Port, 
-		cfg.HTTP.Timeout, 
-		cfg.HTTP.IdleTimeout, 
-		cfg.HTTP.StopTimeout, 
-		services, 
+		log,
+		cfg.HTTP.Port,
+		cfg.HTTP.Timeout,
+		cfg.HTTP.IdleTimeout,
+		cfg.HTTP.StopTimeout,
+		cfg.Console.AppID,
+		cfg.Console.AdminAppID,
+		services,
 		validate,
 	)
 
 	return &App{
-		log:      log,
-		srvs:     services,
-		GRPCServ: grpcapp,
-		HTTPServ: httpapp,
+		log:           log,
+		srvs:          services,
+		GRPCServ:      grpcapp,
+		HTTPServ:      httpapp,
+		shutdownDelay: cfg.ShutdownDelay,
+		closers: []closer{
+			{name: "tracer provider", close: shutdownTracing},
+			{name: "storage", close: func(_ context.Context) error { return storage.Close() }},
+			{name: "purge worker", close: periodic.Start(
+				log,
+				"purge deleted accounts",
+				cfg.Account.PurgeInterval,
+				services.Account.PurgeDeleted,
+			)},
+		},
+	}
+}
+
+// Stop marks application as not ready, waits shutdown delay so
+// orchestrator stops routing requests, drains gRPC and HTTP servers
+// in parallel within ctx deadline, then releases storage and background
+// workers in reverse order of creation.
+// All errors are aggregated and logged.
+func (a *App) Stop(ctx context.Context) error {
+	const op = "app.Stop"
+
+	log := a.log.With(slog.String("op", op))
+
+	// Report not ready first, so no new requests are routed to us
+	a.srvs.Health.Shutdown()
+
+	if a.shutdownDelay > 0 {
+		log.Info("waiting before draining", slog.Duration("delay", a.shutdownDelay))
+		select {
+		case <-time.After(a.shutdownDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs[0] = a.GRPCServ.Stop(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		errs[1] = a.HTTPServ.Stop(ctx)
+	}()
+	wg.Wait()
+
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		c := a.closers[i]
+		log.Debug("closing " + c.name)
+		if err := c.close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: close %s: %w", op, c.name, err))
+		}
+	}
+
+	err := errors.Join(errs...)
+	if err != nil {
+		log.Error("application stopped with errors", sl.Err(err))
+	}
+
+	return err
+}
+
+// mustSetupTracing installs global tracer provider.
+// Returned func flushes pending spans.
+func mustSetupTracing(log *slog.Logger, cfg config.TracingConfig) func(context.Context) error {
+	shutdown, err := tracing.Setup(context.Background(), tracing.Options{
+		ServiceName: cfg.ServiceName,
+		Exporter:    cfg.Exporter,
+		Endpoint:    cfg.Endpoint,
+		Insecure:    cfg.Insecure,
+		SampleRatio: cfg.SampleRatio,
+	})
+	if err != nil {
+		log.Error("Error setting up tracing", sl.Err(err))
+		panic("Error setting up tracing, see logs")
+	}
+
+	return shutdown
+}
+
+// mustMigrate applies pending embedded migrations to storage.
+func mustMigrate(log *slog.Logger, storagePath string) {
+	m, err := migrator.New(storagePath, "", migrator.DefaultTable)
+	if err != nil {
+		log.Error("Error creating migrator", sl.Err(err))
+		panic("Error creating migrator, see logs")
 	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil {
+		if errors.Is(err, migrator.ErrNoChange) {
+			log.Info("Storage is up to date")
+			return
+		}
+		log.Error("Error applying migrations", sl.Err(err))
+		panic("Error applying migrations, see logs")
+	}
+
+	log.Info("Migrations applied")
+}
+
+func mustCreateServices(
+	log *slog.Logger,
+	storage *sqlite.Storage,
+	cfg *config.Config,
+	breachChecker authservice.BreachChecker,
+) *services.Services {
+	srvs, err := services.New(log, storage, cfg, breachChecker)
+	if err != nil {
+		log.Error("Error creating services", sl.Err(err))
+		panic("Error creating services, see logs")
+	}
+
+	return srvs
+}
+
+// mustCreateBreachChecker loads breached passwords corpus.
+// Returns nil if check is disabled.
+func mustCreateBreachChecker(log *slog.Logger, cfg config.BreachCheckConfig) authservice.BreachChecker {
+	if cfg.Path == "" {
+		log.Info("Breached passwords check is disabled")
+		return nil
+	}
+
+	checker, err := breach.New(cfg.Path, cfg.MinCount)
+	if err != nil {
+		log.Error("Error loading breached passwords", sl.Err(err))
+		panic("Error loading breached passwords, see logs")
+	}
+
+	return checker
 }
 
 func mustCreateSqliteStorage(log *slog.Logger, storagePath string) *sqlite.Storage {
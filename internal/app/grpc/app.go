@@ -1,11 +1,18 @@
 package grpc_app
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net"
 
 	authgrpc "github.com/Woland-prj/microtasks_sso/internal/grpc/auth"
+	healthgrpc "github.com/Woland-prj/microtasks_sso/internal/grpc/health"
+	mwLogger "github.com/Woland-prj/microtasks_sso/internal/grpc/interceptor/logger"
+	mwMetrics "github.com/Woland-prj/microtasks_sso/internal/grpc/interceptor/metrics"
+	mwRecovery "github.com/Woland-prj/microtasks_sso/internal/grpc/interceptor/recovery"
+	mwRequestID "github.com/Woland-prj/microtasks_sso/internal/grpc/interceptor/requestid"
+	mwTracing "github.com/Woland-prj/microtasks_sso/internal/grpc/interceptor/tracing"
 	"github.com/Woland-prj/microtasks_sso/internal/services"
 	"github.com/go-playground/validator/v10"
 
@@ -24,9 +31,18 @@ func New(
 	services *services.Services,
 	validate *validator.Validate,
 ) *App {
-	gRPCServer := grpc.NewServer()
+	gRPCServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			mwRequestID.New(),
+			mwTracing.New(),
+			mwLogger.New(log),
+			mwMetrics.New(),
+			mwRecovery.New(log),
+		),
+	)
 
 	authgrpc.Register(gRPCServer, services.Auth, validate)
+	healthgrpc.Register(gRPCServer, services.Health)
 
 	return &App{
 		log:        log,
@@ -65,11 +81,26 @@ func (a *App) run() error {
 	return nil
 }
 
-func (a *App) Stop() {
+// Stop gracefully stops gRPC server waiting for in-flight requests.
+// If ctx is done before requests are drained, server is stopped forcibly.
+func (a *App) Stop(ctx context.Context) error {
 	const op = "grpc_app.Stop"
 
 	a.log.With(slog.String("op", op)).
 		Info("Stopping gRPC server", slog.Int("port", a.port))
 
-	a.gRPCServer.GracefulStop()
+	stopped := make(chan struct{})
+	go func() {
+		a.gRPCServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		a.gRPCServer.Stop()
+		<-stopped
+		return fmt.Errorf("%s: %w", op, ctx.Err())
+	}
 }
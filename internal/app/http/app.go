@@ -7,19 +7,30 @@ import (
 	"net/http"
 	"time"
 
+	accounthttp "github.com/Woland-prj/microtasks_sso/internal/http/account"
+	adminhttp "github.com/Woland-prj/microtasks_sso/internal/http/admin"
 	authhttp "github.com/Woland-prj/microtasks_sso/internal/http/auth"
+	federationhttp "github.com/Woland-prj/microtasks_sso/internal/http/federation"
+	healthhttp "github.com/Woland-prj/microtasks_sso/internal/http/health"
+	magiclinkhttp "github.com/Woland-prj/microtasks_sso/internal/http/magiclink"
 	mvLogger "github.com/Woland-prj/microtasks_sso/internal/http/middleware/logger"
+	mvMetrics "github.com/Woland-prj/microtasks_sso/internal/http/middleware/metrics"
+	mvTracing "github.com/Woland-prj/microtasks_sso/internal/http/middleware/tracing"
+	organizationhttp "github.com/Woland-prj/microtasks_sso/internal/http/organization"
+	passwordresethttp "github.com/Woland-prj/microtasks_sso/internal/http/passwordreset"
+	webauthnhttp "github.com/Woland-prj/microtasks_sso/internal/http/webauthn"
 	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/sl"
 	"github.com/Woland-prj/microtasks_sso/internal/services"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-playground/validator/v10"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-type App struct{
-	log *slog.Logger
-	server *http.Server
-	port int
+type App struct {
+	log         *slog.Logger
+	server      *http.Server
+	port        int
 	stopTimeout time.Duration
 }
 
@@ -29,28 +40,52 @@ func New(
 	timeout time.Duration,
 	idleTimeout time.Duration,
 	stopTimeout time.Duration,
+	consoleAppID int64,
+	adminAppID int64,
 	services *services.Services,
 	validate *validator.Validate,
-) *App{
+) *App {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
-	r.Use(mvLogger.New(log))
-	r.Use(middleware.Recoverer)
-	r.Use(middleware.URLFormat)
 
-	authhttp.Register(r, services.Auth, validate)
+	// Probes and metrics are polled frequently, so they are not logged
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Recoverer)
+
+		healthhttp.Register(r, services.Health)
+		r.Handle("/metrics", promhttp.Handler())
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(mvTracing.New())
+		r.Use(mvLogger.New(log))
+		r.Use(mvMetrics.New())
+		r.Use(middleware.Recoverer)
+		r.Use(middleware.URLFormat)
+
+		authhttp.Register(r, services.Auth, consoleAppID, validate)
+		accounthttp.Register(r, services.Account, services.Auth, consoleAppID, validate)
+		magiclinkhttp.Register(r, services.MagicLink, validate)
+		passwordresethttp.Register(r, services.PasswordReset, validate)
+		webauthnhttp.Register(r, services.WebAuthn, services.Auth, consoleAppID, validate)
+		organizationhttp.Register(r, services.Organization, services.Auth, services.Auth, consoleAppID, validate)
+		adminhttp.Register(r, services.App, services.Auth, adminAppID, validate)
+		if services.Federation != nil {
+			federationhttp.Register(r, services.Federation, validate)
+		}
+	})
 
 	srv := &http.Server{
-		Addr: fmt.Sprintf(":%d", port),
-		Handler: r,
-		ReadTimeout: timeout,
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      r,
+		ReadTimeout:  timeout,
 		WriteTimeout: timeout,
-		IdleTimeout: idleTimeout,
+		IdleTimeout:  idleTimeout,
 	}
-	return &App{log: log, server: srv, port: port}
+	return &App{log: log, server: srv, port: port, stopTimeout: stopTimeout}
 }
 
-func (a *App) MustRun(){
+func (a *App) MustRun() {
 	if err := a.run(); err != nil {
 		panic(err)
 	}
@@ -58,28 +93,36 @@ func (a *App) MustRun(){
 
 func (a *App) run() error {
 	op := "http_app.Run"
-	a.log = a.log.With(slog.String("op", op))
-	a.log.Info("Starting HTTP server", slog.Int("port", a.port))
+	log := a.log.With(slog.String("op", op))
+	log.Info("Starting HTTP server", slog.Int("port", a.port))
+
+	log.Info("HTTP server is runing", slog.String("addr", a.server.Addr))
 
-	a.log.Info("HTTP server is runing", slog.String("addr", a.server.Addr))
-	
 	err := a.server.ListenAndServe()
-	if err != nil  && err != http.ErrServerClosed {
+	if err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 	return nil
 }
 
-func (a *App) Stop() {
-	const op = "grpc_app.Stop"
+// Stop gracefully shuts down HTTP server within stop timeout or ctx deadline,
+// whichever comes first. Remaining connections are closed forcibly.
+func (a *App) Stop(ctx context.Context) error {
+	const op = "http_app.Stop"
 
-	a.log.With(slog.String("op", op)).
-		Info("Stopping HTTP server", slog.Int("port", a.port))
+	log := a.log.With(slog.String("op", op))
+	log.Info("Stopping HTTP server", slog.Int("port", a.port))
 
-	ctx, cancel := context.WithTimeout(context.Background(), a.stopTimeout)
+	ctx, cancel := context.WithTimeout(ctx, a.stopTimeout)
 	defer cancel()
 
 	if err := a.server.Shutdown(ctx); err != nil {
-		a.log.Error("failed to shutdown HTTP server", sl.Err(err))
+		log.Error("failed to shutdown HTTP server", sl.Err(err))
+		if cErr := a.server.Close(); cErr != nil {
+			log.Error("failed to close HTTP server", sl.Err(cErr))
+		}
+		return fmt.Errorf("%s: %w", op, err)
 	}
-}
\ No newline at end of file
+
+	return nil
+}
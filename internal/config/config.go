@@ -9,11 +9,35 @@ import (
 )
 
 type Config struct {
-	Env         string         `yaml:"env" env-default:"local"`
-	StoragePath string         `yaml:"storage_path" env-required:"true"`
-	TokenTTL    TokenTTLConfig `yaml:"token_ttl" env-required:"true"`
-	GRPC        GRPCConfig     `yaml:"grpc" env-required:"true"`
-	HTTP        HTTPConfig     `yaml:"http" env-required:"true"`
+	Env             string                `yaml:"env" env-default:"local"`
+	StoragePath     string                `yaml:"storage_path" env-required:"true"`
+	Storage         StorageConfig         `yaml:"storage"`
+	TokenTTL        TokenTTLConfig        `yaml:"token_ttl" env-required:"true"`
+	TokenClaims     TokenClaimsConfig     `yaml:"token_claims"`
+	GRPC            GRPCConfig            `yaml:"grpc" env-required:"true"`
+	HTTP            HTTPConfig            `yaml:"http" env-required:"true"`
+	Tracing         TracingConfig         `yaml:"tracing"`
+	PasswordPolicy  PasswordPolicyConfig  `yaml:"password_policy"`
+	BreachCheck     BreachCheckConfig     `yaml:"breach_check"`
+	PasswordHashing PasswordHashingConfig `yaml:"password_hashing"`
+	Mailer          MailerConfig          `yaml:"mailer"`
+	Account         AccountConfig         `yaml:"account"`
+	MagicLink       MagicLinkConfig       `yaml:"magic_link"`
+	PasswordReset   PasswordResetConfig   `yaml:"password_reset"`
+	WebAuthn        WebAuthnConfig        `yaml:"webauthn"`
+	Organization    OrganizationConfig    `yaml:"organization"`
+	Federation      FederationConfig      `yaml:"federation"`
+	LDAP            LDAPConfig            `yaml:"ldap"`
+	Console         ConsoleConfig         `yaml:"console"`
+	ShutdownTimeout time.Duration         `yaml:"shutdown_timeout" env-default:"15s"`
+	// ShutdownDelay is how long readiness reports not serving
+	// before transports start draining, so orchestrators stop
+	// routing requests first. It counts towards ShutdownTimeout.
+	ShutdownDelay time.Duration `yaml:"shutdown_delay" env-default:"5s"`
+}
+
+type StorageConfig struct {
+	AutoMigrate bool `yaml:"auto_migrate" env-default:"false"`
 }
 
 type GRPCConfig struct {
@@ -24,6 +48,14 @@ type GRPCConfig struct {
 type TokenTTLConfig struct {
 	Auth    time.Duration `yaml:"auth" env-required:"true"`
 	Refresh time.Duration `yaml:"refresh" env-required:"true"`
+	// Reauth is how long after login, kept through refresh,
+	// sensitive operations are confirmed without password.
+	Reauth time.Duration `yaml:"reauth" env-default:"5m"`
+}
+
+// TokenClaimsConfig selects optional claims of auth tokens.
+type TokenClaimsConfig struct {
+	Profile bool `yaml:"profile" env-default:"false"`
 }
 
 type HTTPConfig struct {
@@ -33,6 +65,166 @@ type HTTPConfig struct {
 	StopTimeout time.Duration `yaml:"stop_timeout" env-default:"10s"`
 }
 
+type TracingConfig struct {
+	ServiceName string  `yaml:"service_name" env-default:"sso"`
+	Exporter    string  `yaml:"exporter" env-default:"none"` // none, stdout, otlp
+	Endpoint    string  `yaml:"endpoint" env-default:"localhost:4317"`
+	Insecure    bool    `yaml:"insecure" env-default:"true"`
+	SampleRatio float64 `yaml:"sample_ratio" env-default:"1"`
+}
+
+// PasswordPolicyConfig describes rules for new passwords.
+// Length is counted in characters, bytes limit of hash algorithm
+// is always enforced.
+type PasswordPolicyConfig struct {
+	MinLength     int  `yaml:"min_length" env-default:"8"`
+	MaxLength     int  `yaml:"max_length" env-default:"64"`
+	RequireUpper  bool `yaml:"require_upper" env-default:"false"`
+	RequireLower  bool `yaml:"require_lower" env-default:"false"`
+	RequireDigit  bool `yaml:"require_digit" env-default:"false"`
+	RequireSymbol bool `yaml:"require_symbol" env-default:"false"`
+	RejectEmail   bool `yaml:"reject_email" env-default:"true"`
+}
+
+// BreachCheckConfig points to offline corpus of breached passwords,
+// either a directory of SHA-1 prefix range files or a file of full hashes.
+// Empty path disables check.
+type BreachCheckConfig struct {
+	Path     string `yaml:"path"`
+	MinCount int    `yaml:"min_count" env-default:"1"`
+}
+
+// PasswordHashingConfig selects algorithm for new password hashes.
+// Stored hashes of other algorithm or with other parameters
+// are rehashed on login.
+// At most MaxConcurrency hashes are computed at once, 0 means half
+// of available CPUs. Requests waiting longer than QueueTimeout
+// are rejected as unavailable.
+type PasswordHashingConfig struct {
+	Algorithm      string         `yaml:"algorithm" env-default:"bcrypt"` // bcrypt, argon2id
+	BcryptCost     int            `yaml:"bcrypt_cost" env-default:"10"`
+	Argon2id       Argon2idConfig `yaml:"argon2id"`
+	MaxConcurrency int            `yaml:"max_concurrency" env-default:"0"`
+	QueueTimeout   time.Duration  `yaml:"queue_timeout" env-default:"5s"`
+}
+
+type Argon2idConfig struct {
+	Memory      uint32 `yaml:"memory" env-default:"65536"` // KiB
+	Iterations  uint32 `yaml:"iterations" env-default:"3"`
+	Parallelism uint8  `yaml:"parallelism" env-default:"2"`
+	SaltLength  uint32 `yaml:"salt_length" env-default:"16"`
+	KeyLength   uint32 `yaml:"key_length" env-default:"32"`
+}
+
+type MailerConfig struct {
+	Driver string     `yaml:"driver" env-default:"log"` // log, smtp
+	From   string     `yaml:"from" env-default:"sso@localhost"`
+	SMTP   SMTPConfig `yaml:"smtp"`
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host" env-default:"localhost"`
+	Port     int    `yaml:"port" env-default:"587"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password" env:"SMTP_PASSWORD"`
+}
+
+// AccountConfig configures self-service account operations.
+// EmailConfirmURL is a page of client app receiving "token" query
+// parameter and posting it to /email/confirm. It can't point at
+// /email/confirm itself, links in emails are opened with GET.
+// Deleted accounts are purged DeletionGracePeriod after deletion
+// by a worker running every PurgeInterval.
+type AccountConfig struct {
+	EmailChangeTTL      time.Duration `yaml:"email_change_ttl" env-default:"24h"`
+	EmailConfirmURL     string        `yaml:"email_confirm_url" env-default:"http://localhost:3000/email/confirm"`
+	DeletionGracePeriod time.Duration `yaml:"deletion_grace_period" env-default:"720h"`
+	PurgeInterval       time.Duration `yaml:"purge_interval" env-default:"1h"`
+}
+
+// MagicLinkConfig configures passwordless login by links sent by email.
+// URL is a page of client app receiving "token" query parameter
+// and posting it to /magic-link/consume. It can't point at
+// /magic-link/consume itself, links in emails are opened with GET.
+// User gets at most one link per RequestInterval.
+type MagicLinkConfig struct {
+	TTL             time.Duration `yaml:"ttl" env-default:"15m"`
+	URL             string        `yaml:"url" env-default:"http://localhost:3000/magic-link"`
+	RequestInterval time.Duration `yaml:"request_interval" env-default:"1m"`
+}
+
+// PasswordResetConfig configures reset of forgotten password by links
+// sent by email. URL is a page of client app receiving "token" query
+// parameter, asking for new password and posting both to /password/reset.
+// User gets at most one link per RequestInterval.
+type PasswordResetConfig struct {
+	TTL             time.Duration `yaml:"ttl" env-default:"1h"`
+	URL             string        `yaml:"url" env-default:"http://localhost:3000/password/reset"`
+	RequestInterval time.Duration `yaml:"request_interval" env-default:"1m"`
+}
+
+// OrganizationConfig configures invitations to organizations sent
+// by email. InvitationURL is a page of client app receiving "token"
+// query parameter and posting it to /organizations/invitations/accept
+// with auth token of invited user.
+type OrganizationConfig struct {
+	InvitationTTL time.Duration `yaml:"invitation_ttl" env-default:"72h"`
+	InvitationURL string        `yaml:"invitation_url" env-default:"http://localhost:3000/invitations/accept"`
+}
+
+// WebAuthnConfig configures passkey login. RPID is a domain shared by
+// client apps, passkeys work on it and its subdomains. RPOrigins are
+// origins of client apps allowed to run ceremonies.
+type WebAuthnConfig struct {
+	RPID          string        `yaml:"rp_id" env-default:"localhost"`
+	RPDisplayName string        `yaml:"rp_display_name" env-default:"Microtasks"`
+	RPOrigins     []string      `yaml:"rp_origins" env-default:"http://localhost:8080"`
+	SessionTTL    time.Duration `yaml:"session_ttl" env-default:"5m"`
+}
+
+// FederationConfig configures login by upstream OpenID Connect provider.
+// Empty Issuer disables it. RedirectURL is a page of client app
+// receiving "state" and "code" query parameters and posting them
+// to /federation/callback, it must be registered at provider and
+// is required with Issuer.
+// AutoProvision creates users for unknown verified emails.
+type FederationConfig struct {
+	Issuer        string        `yaml:"issuer"`
+	ClientID      string        `yaml:"client_id"`
+	ClientSecret  string        `yaml:"client_secret" env:"FEDERATION_CLIENT_SECRET"`
+	RedirectURL   string        `yaml:"redirect_url"`
+	Scopes        []string      `yaml:"scopes" env-default:"openid,email,profile"`
+	AutoProvision bool          `yaml:"auto_provision" env-default:"false"`
+	StateTTL      time.Duration `yaml:"state_ttl" env-default:"10m"`
+}
+
+// LDAPConfig configures password login against LDAP directory, asked when
+// password doesn't match a local user. Empty URL disables it.
+// UserFilter gets escaped login as %[1]s. Users are created on first
+// login with email and name taken from directory entry.
+type LDAPConfig struct {
+	URL            string        `yaml:"url"`
+	StartTLS       bool          `yaml:"start_tls" env-default:"false"`
+	BindDN         string        `yaml:"bind_dn"`
+	BindPassword   string        `yaml:"bind_password" env:"LDAP_BIND_PASSWORD"`
+	BaseDN         string        `yaml:"base_dn"`
+	UserFilter     string        `yaml:"user_filter" env-default:"(&(objectClass=person)(|(uid=%[1]s)(mail=%[1]s)))"`
+	EmailAttribute string        `yaml:"email_attribute" env-default:"mail"`
+	NameAttribute  string        `yaml:"name_attribute" env-default:"cn"`
+	Timeout        time.Duration `yaml:"timeout" env-default:"5s"`
+}
+
+// ConsoleConfig selects apps whose auth tokens are accepted by endpoints
+// of SSO itself. AppID is for self-service ones like password change,
+// profile and organizations, AdminAppID is for /admin endpoints.
+// Tokens of other apps are rejected, their secrets are held by client
+// apps verifying tokens offline. Secrets of console apps must not leave
+// the server. 0 rejects tokens of every app.
+type ConsoleConfig struct {
+	AppID      int64 `yaml:"app_id" env-default:"0"`
+	AdminAppID int64 `yaml:"admin_app_id" env-default:"0"`
+}
+
 // MustLoad trying to read config in yaml format.
 // Priority of loading: flag->env->default.
 // If not loaded panic.
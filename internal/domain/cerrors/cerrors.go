@@ -68,4 +68,48 @@ func (err InvalidTokenError) Subject() string {
 
 func NewInvalidTokenError(subject string) InvalidTokenError {
 	return InvalidTokenError{subject: subject}
-}
\ No newline at end of file
+}
+
+type UnavailableError struct {
+	Reason string
+}
+
+func (err UnavailableError) Error() string {
+	return fmt.Sprintf("Service unavailable: %s", err.Reason)
+}
+
+func NewUnavailableError(reason string) UnavailableError {
+	return UnavailableError{Reason: reason}
+}
+
+// PermissionDeniedError means user is known but not allowed
+// to do what is requested.
+type PermissionDeniedError struct {
+	Reason string
+}
+
+func (err PermissionDeniedError) Error() string {
+	return fmt.Sprintf("Permission denied: %s", err.Reason)
+}
+
+func NewPermissionDeniedError(reason string) PermissionDeniedError {
+	return PermissionDeniedError{Reason: reason}
+}
+
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+type ValidationError struct {
+	Message    string
+	Violations []FieldViolation
+}
+
+func (err ValidationError) Error() string {
+	return fmt.Sprintf("%s: %v", err.Message, err.Violations)
+}
+
+func NewValidationError(message string, violations ...FieldViolation) ValidationError {
+	return ValidationError{Message: message, Violations: violations}
+}
@@ -1,17 +1,201 @@
 package dtos
 
+import (
+	"encoding/json"
+	"time"
+)
+
+// LoginDto identifies user by Login which is either email or username.
 type LoginDto struct {
-	Email    string `json:"email" validate:"required,email"`
+	Login    string `json:"login" validate:"required,email|username"`
 	Password string `json:"password" validate:"required"`
 	AppId    int64  `json:"app_id" validate:"required"`
 }
 
 type RegisterDto struct {
-	Email    string `json:"email" validate:"required,email"`
+	Email       string `json:"email" validate:"required,email"`
+	Username    string `json:"username" validate:"omitempty,username"`
+	Password    string `json:"password" validate:"required"`
+	DisplayName string `json:"display_name" validate:"max=64"`
+	AvatarURL   string `json:"avatar_url" validate:"eq=|http_url"`
+	Locale      string `json:"locale" validate:"eq=|bcp47_language_tag"`
+	Timezone    string `json:"timezone" validate:"eq=|timezone"`
+}
+
+type ChangePasswordDto struct {
+	UID         int64  `json:"uid" validate:"required"`
+	OldPassword string `json:"old_password" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+
+// UpdateProfileDto changes only non-nil fields,
+// empty string clears field.
+type UpdateProfileDto struct {
+	UID         int64   `json:"uid" validate:"required"`
+	DisplayName *string `json:"display_name" validate:"omitnil,max=64"`
+	AvatarURL   *string `json:"avatar_url" validate:"omitnil,eq=|http_url"`
+	Locale      *string `json:"locale" validate:"omitnil,eq=|bcp47_language_tag"`
+	Timezone    *string `json:"timezone" validate:"omitnil,eq=|timezone"`
+}
+
+// ChangeEmailDto confirms user either by Password or by AuthTime
+// of current token being recent.
+type ChangeEmailDto struct {
+	UID      int64     `json:"uid" validate:"required"`
+	Password string    `json:"password"`
+	AuthTime time.Time `json:"auth_time"`
+	NewEmail string    `json:"new_email" validate:"required,email"`
+}
+
+// DeleteAccountDto confirms user either by Password or by AuthTime
+// of current token being recent.
+type DeleteAccountDto struct {
+	UID      int64     `json:"uid" validate:"required"`
+	Password string    `json:"password"`
+	AuthTime time.Time `json:"auth_time"`
+}
+
+type RequestMagicLinkDto struct {
+	Email string `json:"email" validate:"required,email"`
+	AppId int64  `json:"app_id" validate:"required"`
+}
+
+type ConsumeMagicLinkDto struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type RequestPasswordResetDto struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordDto struct {
+	Token    string `json:"token" validate:"required"`
 	Password string `json:"password" validate:"required"`
 }
 
+// BeginWebAuthnRegistrationDto confirms user adding passkey either
+// by Password or by AuthTime of current token being recent.
+type BeginWebAuthnRegistrationDto struct {
+	UID      int64     `json:"uid" validate:"required"`
+	Password string    `json:"password"`
+	AuthTime time.Time `json:"auth_time"`
+}
+
+// FinishWebAuthnRegistrationDto carries attestation of new credential
+// as created by navigator.credentials.create.
+type FinishWebAuthnRegistrationDto struct {
+	UID        int64           `json:"uid" validate:"required"`
+	SessionID  string          `json:"session_id" validate:"required"`
+	Name       string          `json:"name" validate:"max=64"`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
+
+// FinishWebAuthnLoginDto carries assertion
+// as created by navigator.credentials.get.
+type FinishWebAuthnLoginDto struct {
+	SessionID  string          `json:"session_id" validate:"required"`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
+
+// FinishFederatedLoginDto carries parameters upstream identity provider
+// redirected back with and binding returned by BeginLogin, which
+// browser keeps in cookie.
+type FinishFederatedLoginDto struct {
+	State   string `json:"state" validate:"required"`
+	Code    string `json:"code" validate:"required"`
+	Binding string `json:"-"`
+}
+
+// SwitchOrganizationDto reissues tokens of user for app
+// with another active organization. MFA and AuthTime are taken
+// from current token.
+type SwitchOrganizationDto struct {
+	UID      int64     `json:"uid" validate:"required"`
+	AppId    int64     `json:"app_id" validate:"required"`
+	OrgID    int64     `json:"org_id" validate:"required"`
+	MFA      bool      `json:"mfa"`
+	AuthTime time.Time `json:"auth_time"`
+}
+
+type CreateOrganizationDto struct {
+	UID  int64  `json:"uid" validate:"required"`
+	Name string `json:"name" validate:"required,max=64"`
+}
+
+// InviteMemberDto invites user with Email to organization on behalf of UID.
+type InviteMemberDto struct {
+	UID   int64  `json:"uid" validate:"required"`
+	OrgID int64  `json:"org_id" validate:"required"`
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"omitempty,oneof=owner member"`
+}
+
+// AcceptInvitationDto accepts invitation by Token from invitation link
+// on behalf of invited UID.
+type AcceptInvitationDto struct {
+	UID   int64  `json:"uid" validate:"required"`
+	Token string `json:"token" validate:"required"`
+}
+
+// RemoveMemberDto removes MemberID from organization on behalf of UID.
+type RemoveMemberDto struct {
+	UID      int64 `json:"uid" validate:"required"`
+	OrgID    int64 `json:"org_id" validate:"required"`
+	MemberID int64 `json:"member_id" validate:"required"`
+}
+
 type RefreshDto struct {
 	RefreshToken string `json:"refresh_token" validate:"required,jwt"`
 	AppId        int64  `json:"app_id" validate:"required"`
-}
\ No newline at end of file
+}
+
+type CreateAppDto struct {
+	Name          string `json:"name" validate:"required"`
+	AuthSecret    string `json:"auth_secret"`
+	RefreshSecret string `json:"refresh_secret"`
+	OrgID         int64  `json:"org_id"`
+	Restricted    bool   `json:"restricted"`
+	// Policy of new app, see SetAppPolicyDto
+	AuthTokenTTL    time.Duration `json:"auth_token_ttl"`
+	RefreshTokenTTL time.Duration `json:"refresh_token_ttl"`
+	RequireMFA      bool          `json:"require_mfa"`
+	GrantTypes      []string      `json:"grant_types" validate:"dive,oneof=password refresh magic_link webauthn federation"`
+}
+
+// SetAppRestrictedDto turns restriction of app on or off
+// on behalf of admin UID.
+type SetAppRestrictedDto struct {
+	UID        int64 `json:"uid" validate:"required"`
+	AppID      int64 `json:"app_id" validate:"required"`
+	Restricted bool  `json:"restricted"`
+}
+
+// SetAppPolicyDto replaces token TTLs, MFA requirement and allowed
+// grant types of app on behalf of admin UID. Zero TTLs fall back
+// to global ones, others must be whole seconds. Empty GrantTypes
+// allows all.
+type SetAppPolicyDto struct {
+	UID             int64         `json:"uid" validate:"required"`
+	AppID           int64         `json:"app_id" validate:"required"`
+	AuthTokenTTL    time.Duration `json:"auth_token_ttl"`
+	RefreshTokenTTL time.Duration `json:"refresh_token_ttl"`
+	RequireMFA      bool          `json:"require_mfa"`
+	GrantTypes      []string      `json:"grant_types" validate:"dive,oneof=password refresh magic_link webauthn federation"`
+}
+
+// GrantAppAccessDto grants access to app either to user with Email
+// or to members of OrgID on behalf of admin UID.
+type GrantAppAccessDto struct {
+	UID   int64  `json:"uid" validate:"required"`
+	AppID int64  `json:"app_id" validate:"required"`
+	Email string `json:"email" validate:"required_without=OrgID,excluded_with=OrgID,omitempty,email"`
+	OrgID int64  `json:"org_id"`
+}
+
+// RevokeAppAccessDto revokes grant of access to app
+// on behalf of admin UID.
+type RevokeAppAccessDto struct {
+	UID     int64 `json:"uid" validate:"required"`
+	AppID   int64 `json:"app_id" validate:"required"`
+	GrantID int64 `json:"grant_id" validate:"required"`
+}
@@ -1,9 +1,140 @@
 package entities
 
+import "time"
+
 type User struct {
 	UID      uint64
 	Email    string
+	Username string // optional, empty if not set
 	PassHash string
+	IsAdmin  bool
+	Profile
+}
+
+// Profile is a user information shown by client apps.
+// Empty fields are not set.
+type Profile struct {
+	DisplayName string
+	AvatarURL   string
+	Locale      string
+	Timezone    string
+}
+
+// EmailChange is a pending change of user email
+// waiting for confirmation from new address.
+type EmailChange struct {
+	TokenHash string
+	UserID    int64
+	NewEmail  string
+	ExpiresAt time.Time
+}
+
+// MagicLink is a single-use passwordless login into app.
+type MagicLink struct {
+	TokenHash string
+	UserID    int64
+	AppID     int64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// PasswordReset is a pending reset of forgotten password
+// waiting for user to follow the link sent by email.
+type PasswordReset struct {
+	TokenHash string
+	UserID    int64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// WebAuthnCredential is a passkey registered by user.
+// Data is a credential state kept by WebAuthn implementation.
+type WebAuthnCredential struct {
+	ID         []byte
+	UserID     int64
+	Name       string
+	Data       []byte
+	CreatedAt  time.Time
+	LastUsedAt time.Time // zero if never used
+}
+
+// WebAuthnSession is a pending WebAuthn ceremony.
+// Registration sessions have UserID, login sessions have AppID.
+// Data is a ceremony state kept by WebAuthn implementation.
+type WebAuthnSession struct {
+	TokenHash string
+	UserID    int64
+	AppID     int64
+	Data      []byte
+	ExpiresAt time.Time
+}
+
+// FederatedIdentity links account of upstream identity provider
+// to local user.
+type FederatedIdentity struct {
+	Issuer    string
+	Subject   string
+	UserID    int64
+	CreatedAt time.Time
+}
+
+// FederationState is a pending authorization request to upstream
+// identity provider. Nonce and CodeVerifier are checked when
+// provider redirects back with authorization code. BindingHash ties
+// request to browser which started it.
+type FederationState struct {
+	TokenHash    string
+	AppID        int64
+	Nonce        string
+	CodeVerifier string
+	BindingHash  string
+	ExpiresAt    time.Time
+}
+
+// Roles of organization members. Owners manage members.
+const (
+	RoleOwner  = "owner"
+	RoleMember = "member"
+)
+
+// Organization is a team workspace users belong to.
+type Organization struct {
+	ID        int64
+	Name      string
+	CreatedAt time.Time
+}
+
+// Membership of user in organization. OrgName and Email
+// are filled by listings for display.
+type Membership struct {
+	OrgID     int64
+	OrgName   string
+	UserID    int64
+	Email     string
+	Role      string
+	CreatedAt time.Time
+}
+
+// Invitation to organization waiting for invited user
+// to accept it by the link sent by email.
+type Invitation struct {
+	TokenHash string
+	OrgID     int64
+	UserID    int64
+	Role      string
+	InvitedBy int64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// AccountData is everything stored about user, returned by data export.
+type AccountData struct {
+	User                User
+	EmailChanges        []EmailChange
+	WebAuthnCredentials []WebAuthnCredential
+	FederatedIdentities []FederatedIdentity
+	Memberships         []Membership
+	AppGrants           []AppGrant
 }
 
 type App struct {
@@ -11,9 +142,76 @@ type App struct {
 	Name          string
 	AuthSecret    string
 	RefreshSecret string
+	OrgID         int64 // 0 if app is open to users of any organization
+	// Restricted app issues tokens only to users granted access
+	Restricted bool
+	// Zero TTLs fall back to global ones
+	AuthTokenTTL    time.Duration
+	RefreshTokenTTL time.Duration
+	// RequireMFA issues tokens only to logins verifying more than one factor
+	RequireMFA bool
+	// GrantTypes allowed for app, empty allows all
+	GrantTypes []GrantType
+}
+
+// AllowsGrant reports whether app issues tokens for grant of type t.
+func (a *App) AllowsGrant(t GrantType) bool {
+	if len(a.GrantTypes) == 0 {
+		return true
+	}
+	for _, allowed := range a.GrantTypes {
+		if allowed == t {
+			return true
+		}
+	}
+	return false
+}
+
+// GrantType is a way user gets tokens.
+type GrantType string
+
+const (
+	GrantPassword   GrantType = "password"
+	GrantRefresh    GrantType = "refresh"
+	GrantMagicLink  GrantType = "magic_link"
+	GrantWebAuthn   GrantType = "webauthn"
+	GrantFederation GrantType = "federation"
+)
+
+// Grant is how user proved identity to get tokens.
+type Grant struct {
+	Type GrantType
+	// MFA is set if login verified more than one factor,
+	// refresh keeps it from the original login
+	MFA bool
+	// AuthTime is when user proved identity, refresh keeps it
+	// from the original login. IssueTokens uses now if zero.
+	AuthTime time.Time
+}
+
+// AppGrant gives access to restricted app either to user
+// or to every member of organization, the other ID is 0.
+type AppGrant struct {
+	ID        int64
+	AppID     int64
+	UserID    int64
+	OrgID     int64
+	CreatedAt time.Time
 }
 
 type JwtTokenPair struct {
 	AuthToken    string
 	RefreshToken string
 }
+
+// TokenClaims are claims of a validated auth token.
+type TokenClaims struct {
+	UID   int64
+	Email string
+	AppId int64
+	OrgID int64 // active organization, 0 if none
+	MFA   bool  // login verified more than one factor
+	// AuthTime is when user logged in, zero for tokens issued
+	// before it was tracked
+	AuthTime time.Time
+}
@@ -2,16 +2,14 @@ package auth
 
 import (
 	"context"
-	"errors"
 
 	ssov1 "github.com/Woland-prj/microtasks_protos/gen/go/sso"
-	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
 	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
 	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/validation"
 	"github.com/go-playground/validator/v10"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 type AuthService interface {
@@ -46,23 +44,28 @@ func (s *serverAPI) Login(
 	ctx context.Context,
 	r *ssov1.LoginRequest,
 ) (*ssov1.LoginRespones, error) {
+	// Protocol has no dedicated field, so email field carries
+	// either email or username
 	dto := dtos.LoginDto{
-		Email:    r.GetEmail(),
+		Login:    r.GetEmail(),
 		Password: r.GetPassword(),
 		AppId:    r.GetAppId(),
 	}
 
 	if err := s.validate.Struct(dto); err != nil {
-		return nil, status.Error(codes.InvalidArgument, "Invalid credentials")
+		vErr := validation.Error("Invalid credentials", err)
+		for i := range vErr.Violations {
+			if vErr.Violations[i].Field == "login" {
+				vErr.Violations[i].Field = "email"
+			}
+		}
+		return nil, apierror.GRPC(ctx, vErr)
 	}
 
 	tokens, err := s.authService.Login(ctx, dto)
 
 	if err != nil {
-		if errors.Is(err, &cerrors.InvalidCredentialsError{}) {
-			return nil, status.Error(codes.InvalidArgument, "Invalid credentials")
-		}
-		return nil, status.Error(codes.Internal, "Internal error")
+		return nil, apierror.GRPC(ctx, err)
 	}
 
 	return &ssov1.LoginRespones{
@@ -81,17 +84,13 @@ func (s *serverAPI) Register(
 	}
 
 	if err := s.validate.Struct(dto); err != nil {
-		return nil, status.Error(codes.InvalidArgument, "Invalid credentials")
+		return nil, apierror.GRPC(ctx, validation.Error("Invalid credentials", err))
 	}
 
 	uid, err := s.authService.Register(ctx, dto)
 
 	if err != nil {
-		var cErr cerrors.AlreadyExistsError
-		if errors.As(err, &cErr) {
-			return nil, status.Error(codes.AlreadyExists, "User already exists")
-		}
-		return nil, status.Error(codes.Internal, "Internal error")
+		return nil, apierror.GRPC(ctx, err)
 	}
 
 	return &ssov1.RegisterRespones{
@@ -110,22 +109,13 @@ func (s *serverAPI) Refresh(
 	}
 
 	if err := s.validate.Struct(dto); err != nil {
-		return nil, status.Error(codes.Unauthenticated, "Bad format")
+		return nil, apierror.GRPC(ctx, validation.Error("Bad format", err))
 	}
 
 	tokens, err := s.authService.Refresh(ctx, dto)
 
 	if err != nil {
-		var cErr cerrors.InvalidTokenError
-		if errors.As(err, &cErr) {
-			switch(cErr.Subject()) {
-				case cerrors.TokenExpired:
-					return nil, status.Error(codes.Unauthenticated, "Token expired")
-				case cerrors.TokenBadFormat:
-					return nil, status.Error(codes.Unauthenticated, "Fake token")
-			}
-		}
-		return nil, status.Error(codes.Internal, "Internal error")
+		return nil, apierror.GRPC(ctx, err)
 	}
 
 	return &ssov1.LoginRespones{
@@ -0,0 +1,203 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	ssov1 "github.com/Woland-prj/microtasks_protos/gen/go/sso"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	authgrpc "github.com/Woland-prj/microtasks_sso/internal/grpc/auth"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const validJwt = "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJpZCI6MX0.dGVzdA"
+
+type fakeAuthService struct {
+	err error
+}
+
+func (f *fakeAuthService) Login(context.Context, dtos.LoginDto) (*entities.JwtTokenPair, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &entities.JwtTokenPair{AuthToken: "auth", RefreshToken: "refresh"}, nil
+}
+
+func (f *fakeAuthService) Register(context.Context, dtos.RegisterDto) (int64, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	return 1, nil
+}
+
+func (f *fakeAuthService) Refresh(context.Context, dtos.RefreshDto) (*entities.JwtTokenPair, error) {
+	return f.Login(context.Background(), dtos.LoginDto{})
+}
+
+func newClient(t *testing.T, service authgrpc.AuthService) ssov1.AuthClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	authgrpc.Register(srv, service, validation.New())
+
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	cc, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { cc.Close() })
+
+	return ssov1.NewAuthClient(cc)
+}
+
+func TestHandlers_Codes(t *testing.T) {
+	validLogin := &ssov1.LoginRequest{Email: "a@b.c", Password: "p", AppId: 1}
+	validRegister := &ssov1.RegisterRequest{Email: "a@b.c", Password: "p"}
+	validRefresh := &ssov1.RefreshRequest{RefreshToken: validJwt, AppId: 1}
+
+	cases := []struct {
+		name   string
+		call   func(ssov1.AuthClient) error
+		err    error
+		code   codes.Code
+		reason string
+		fields []string
+	}{
+		{
+			name: "login ok",
+			call: func(c ssov1.AuthClient) error {
+				_, err := c.Login(context.Background(), validLogin)
+				return err
+			},
+			code: codes.OK,
+		},
+		{
+			name: "login neither email nor username",
+			call: func(c ssov1.AuthClient) error {
+				_, err := c.Login(context.Background(), &ssov1.LoginRequest{Email: "a b.c", Password: "p"})
+				return err
+			},
+			code:   codes.InvalidArgument,
+			reason: apierror.CodeValidationFailed,
+			fields: []string{"email", "app_id"},
+		},
+		{
+			name: "login bad credentials",
+			call: func(c ssov1.AuthClient) error {
+				_, err := c.Login(context.Background(), validLogin)
+				return err
+			},
+			err:    cerrors.NewInvalidCredentialsError(),
+			code:   codes.Unauthenticated,
+			reason: apierror.CodeInvalidCredentials,
+		},
+		{
+			name: "login unknown app",
+			call: func(c ssov1.AuthClient) error {
+				_, err := c.Login(context.Background(), validLogin)
+				return err
+			},
+			err:    cerrors.NewNotFoundError("app 1"),
+			code:   codes.NotFound,
+			reason: apierror.CodeNotFound,
+		},
+		{
+			name: "register exists",
+			call: func(c ssov1.AuthClient) error {
+				_, err := c.Register(context.Background(), validRegister)
+				return err
+			},
+			err:    cerrors.NewAlreadyExistsError("user 0"),
+			code:   codes.AlreadyExists,
+			reason: apierror.CodeAlreadyExists,
+		},
+		{
+			name: "register internal",
+			call: func(c ssov1.AuthClient) error {
+				_, err := c.Register(context.Background(), validRegister)
+				return err
+			},
+			err:    cerrors.NewCriticalInternalError("stmt.ExecContext", errors.New("boom")),
+			code:   codes.Internal,
+			reason: apierror.CodeInternal,
+		},
+		{
+			name: "refresh bad format",
+			call: func(c ssov1.AuthClient) error {
+				_, err := c.Refresh(context.Background(), &ssov1.RefreshRequest{RefreshToken: "nope", AppId: 1})
+				return err
+			},
+			code:   codes.InvalidArgument,
+			reason: apierror.CodeValidationFailed,
+			fields: []string{"refresh_token"},
+		},
+		{
+			name: "refresh expired",
+			call: func(c ssov1.AuthClient) error {
+				_, err := c.Refresh(context.Background(), validRefresh)
+				return err
+			},
+			err:    cerrors.NewInvalidTokenError(cerrors.TokenExpired),
+			code:   codes.Unauthenticated,
+			reason: apierror.CodeTokenExpired,
+		},
+		{
+			name: "refresh fake",
+			call: func(c ssov1.AuthClient) error {
+				_, err := c.Refresh(context.Background(), validRefresh)
+				return err
+			},
+			err:    cerrors.NewInvalidTokenError(cerrors.TokenBadFormat),
+			code:   codes.Unauthenticated,
+			reason: apierror.CodeTokenInvalid,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newClient(t, &fakeAuthService{err: tc.err})
+
+			st := status.Convert(tc.call(client))
+			assert.Equal(t, tc.code, st.Code())
+
+			if tc.reason == "" {
+				return
+			}
+
+			require.NotEmpty(t, st.Details())
+			info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+			require.True(t, ok)
+			assert.Equal(t, tc.reason, info.GetReason())
+
+			var fields []string
+			for _, d := range st.Details() {
+				if br, ok := d.(*errdetails.BadRequest); ok {
+					for _, v := range br.GetFieldViolations() {
+						fields = append(fields, v.GetField())
+					}
+				}
+			}
+			assert.ElementsMatch(t, tc.fields, fields)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package health
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// LivenessService is a service name to check liveness of the process.
+	LivenessService = "liveness"
+	// ReadinessService is a service name to check readiness.
+	// Empty service name is treated as readiness as well.
+	ReadinessService = "readiness"
+
+	watchInterval = time.Second
+)
+
+type HealthService interface {
+	Live(ctx context.Context) error
+	Ready(ctx context.Context) error
+}
+
+type serverAPI struct {
+	healthpb.UnimplementedHealthServer
+	healthService HealthService
+}
+
+func Register(gRPC *grpc.Server, service HealthService) {
+	healthpb.RegisterHealthServer(gRPC, &serverAPI{
+		healthService: service,
+	})
+}
+
+func (s *serverAPI) Check(
+	ctx context.Context,
+	r *healthpb.HealthCheckRequest,
+) (*healthpb.HealthCheckResponse, error) {
+	st, err := s.status(ctx, r.GetService())
+	if err != nil {
+		return nil, err
+	}
+
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch sends serving status of the service and then every status change
+// until client cancels the stream.
+func (s *serverAPI) Watch(
+	r *healthpb.HealthCheckRequest,
+	stream healthpb.Health_WatchServer,
+) error {
+	ctx := stream.Context()
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		st, err := s.status(ctx, r.GetService())
+		if err != nil {
+			st = healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+
+		if st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *serverAPI) status(
+	ctx context.Context,
+	service string,
+) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	var check func(context.Context) error
+
+	switch service {
+	case LivenessService:
+		check = s.healthService.Live
+	case "", ReadinessService:
+		check = s.healthService.Ready
+	default:
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN,
+			status.Error(codes.NotFound, "Unknown service")
+	}
+
+	if err := check(ctx); err != nil {
+		return healthpb.HealthCheckResponse_NOT_SERVING, nil
+	}
+
+	return healthpb.HealthCheckResponse_SERVING, nil
+}
@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// New returns interceptor writing access log record for every unary request.
+func New(log *slog.Logger) grpc.UnaryServerInterceptor {
+	log = log.With(
+		slog.String("component", "interceptor/logger"),
+	)
+
+	log.Info("logger interceptor enabled")
+
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		entry := log.With(
+			slog.String("method", info.FullMethod),
+		)
+		if p, ok := peer.FromContext(ctx); ok {
+			entry = entry.With(slog.String("peer", p.Addr.String()))
+		}
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if ua := md.Get("user-agent"); len(ua) > 0 {
+				entry = entry.With(slog.String("user_agent", ua[0]))
+			}
+		}
+
+		t1 := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		entry.InfoContext(ctx, "request completed",
+			slog.String("code", status.Code(err).String()),
+			slog.String("duration", time.Since(t1).String()),
+		)
+
+		return resp, err
+	}
+}
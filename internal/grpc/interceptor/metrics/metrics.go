@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/lib/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// New returns interceptor recording duration of unary requests
+// labeled by method and response code.
+func New() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		t1 := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		metrics.GRPCRequestDuration.
+			WithLabelValues(info.FullMethod, status.Code(err).String()).
+			Observe(time.Since(t1).Seconds())
+
+		return resp, err
+	}
+}
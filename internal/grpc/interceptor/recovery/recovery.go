@@ -0,0 +1,41 @@
+package recovery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// New returns interceptor recovering from panics in handlers.
+// Panic is logged with stack trace and turned into codes.Internal,
+// so a single bad request doesn't kill the process.
+func New(log *slog.Logger) grpc.UnaryServerInterceptor {
+	log = log.With(
+		slog.String("component", "interceptor/recovery"),
+	)
+
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (resp any, err error) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				log.ErrorContext(ctx, "panic recovered",
+					slog.String("method", info.FullMethod),
+					slog.String("panic", fmt.Sprint(rec)),
+					slog.String("stack", string(debug.Stack())),
+				)
+				err = status.Error(codes.Internal, "Internal error")
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
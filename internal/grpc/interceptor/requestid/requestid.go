@@ -0,0 +1,48 @@
+package requestid
+
+import (
+	"context"
+
+	"github.com/Woland-prj/microtasks_sso/internal/lib/random"
+	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// MetadataKey is a metadata key carrying request id,
+// the same as header used by HTTP server.
+const MetadataKey = "x-request-id"
+
+const idLen = 8
+
+// New returns interceptor taking request id from incoming metadata
+// or generating a new one. Id is stored in context under the same key
+// chi uses, so middleware.GetReqID works for both transports,
+// and is sent back to the client in response header.
+func New() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		var id string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if vals := md.Get(MetadataKey); len(vals) > 0 {
+				id = vals[0]
+			}
+		}
+
+		if id == "" {
+			var err error
+			if id, err = random.Hex(idLen); err != nil {
+				return nil, err
+			}
+		}
+
+		ctx = context.WithValue(ctx, middleware.RequestIDKey, id)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(MetadataKey, id))
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/Woland-prj/microtasks_sso/internal/lib/tracing"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// New returns interceptor starting server span for every unary request.
+// Trace context of the caller is extracted from incoming metadata.
+func New() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+
+		ctx, span := tracing.Tracer().Start(ctx, info.FullMethod,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.RPCSystemGRPC,
+				attribute.String("rpc.method", info.FullMethod),
+				attribute.String("request_id", middleware.GetReqID(ctx)),
+			),
+		)
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+
+		st, _ := status.FromError(err)
+		span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(st.Code())))
+		if err != nil {
+			span.SetStatus(otelcodes.Error, st.Message())
+		}
+
+		return resp, err
+	}
+}
+
+// metadataCarrier adapts gRPC metadata to propagation.TextMapCarrier.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	vals := metadata.MD(c).Get(key)
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
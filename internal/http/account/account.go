@@ -0,0 +1,380 @@
+package account
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/http/middleware/authn"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/validation"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+type AccountService interface {
+	GetProfile(
+		ctx context.Context,
+		uid int64,
+	) (*entities.User, error)
+	UpdateProfile(
+		ctx context.Context,
+		dto dtos.UpdateProfileDto,
+	) (*entities.User, error)
+	RequestEmailChange(
+		ctx context.Context,
+		dto dtos.ChangeEmailDto,
+	) error
+	ConfirmEmailChange(
+		ctx context.Context,
+		token string,
+	) error
+	DeleteAccount(
+		ctx context.Context,
+		dto dtos.DeleteAccountDto,
+	) (time.Time, error)
+	ExportData(
+		ctx context.Context,
+		uid int64,
+	) (*entities.AccountData, error)
+}
+
+type serverAPI struct {
+	accountService AccountService
+	validate       *validator.Validate
+}
+
+func Register(
+	router chi.Router,
+	service AccountService,
+	authenticator authn.Authenticator,
+	consoleAppID int64,
+	validate *validator.Validate,
+) {
+	api := serverAPI{accountService: service, validate: validate}
+
+	// Confirmation token is the only credential here, user may
+	// follow the link from email on another device
+	router.Post("/email/confirm", api.ConfirmEmailChange())
+
+	router.Group(func(r chi.Router) {
+		r.Use(authn.New(authenticator, consoleAppID))
+		r.Get("/profile", api.GetProfile())
+		r.Patch("/profile", api.UpdateProfile())
+		r.Post("/email/change", api.RequestEmailChange())
+		r.Post("/account/delete", api.DeleteAccount())
+		r.Get("/account/export", api.ExportData())
+	})
+}
+
+type ProfileResponse struct {
+	Uid         int64  `json:"uid"`
+	Email       string `json:"email"`
+	Username    string `json:"username,omitempty"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
+	Locale      string `json:"locale"`
+	Timezone    string `json:"timezone"`
+}
+
+func newProfileResponse(usr *entities.User) ProfileResponse {
+	return ProfileResponse{
+		Uid:         int64(usr.UID),
+		Email:       usr.Email,
+		Username:    usr.Username,
+		DisplayName: usr.DisplayName,
+		AvatarURL:   usr.AvatarURL,
+		Locale:      usr.Locale,
+		Timezone:    usr.Timezone,
+	}
+}
+
+func (api *serverAPI) GetProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		usr, err := api.accountService.GetProfile(r.Context(), claims.UID)
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, newProfileResponse(usr))
+	}
+}
+
+// UpdateProfileRequest changes only fields present in request,
+// empty string clears field.
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name" validate:"omitnil,max=64"`
+	AvatarURL   *string `json:"avatar_url" validate:"omitnil,eq=|http_url"`
+	Locale      *string `json:"locale" validate:"omitnil,eq=|bcp47_language_tag"`
+	Timezone    *string `json:"timezone" validate:"omitnil,eq=|timezone"`
+}
+
+func (api *serverAPI) UpdateProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		var req UpdateProfileRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Invalid profile", err))
+			return
+		}
+
+		usr, err := api.accountService.UpdateProfile(r.Context(), dtos.UpdateProfileDto{
+			UID:         claims.UID,
+			DisplayName: req.DisplayName,
+			AvatarURL:   req.AvatarURL,
+			Locale:      req.Locale,
+			Timezone:    req.Timezone,
+		})
+
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, newProfileResponse(usr))
+	}
+}
+
+// ChangeEmailRequest confirms change by password. It may be omitted
+// within reauth period after login.
+type ChangeEmailRequest struct {
+	Password string `json:"password"`
+	NewEmail string `json:"new_email" validate:"required,email"`
+}
+
+func (api *serverAPI) RequestEmailChange() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		var req ChangeEmailRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		err = api.accountService.RequestEmailChange(r.Context(), dtos.ChangeEmailDto{
+			UID:      claims.UID,
+			Password: req.Password,
+			AuthTime: claims.AuthTime,
+			NewEmail: req.NewEmail,
+		})
+
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+type ConfirmEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (api *serverAPI) ConfirmEmailChange() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ConfirmEmailRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		err = api.accountService.ConfirmEmailChange(r.Context(), req.Token)
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DeleteAccountRequest confirms deletion by password. It may be omitted,
+// along with body, within reauth period after login.
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+type DeleteAccountResponse struct {
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+func (api *serverAPI) DeleteAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		var req DeleteAccountRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		purgeAt, err := api.accountService.DeleteAccount(r.Context(), dtos.DeleteAccountDto{
+			UID:      claims.UID,
+			Password: req.Password,
+			AuthTime: claims.AuthTime,
+		})
+
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, DeleteAccountResponse{PurgeAt: purgeAt.UTC()})
+	}
+}
+
+type ExportResponse struct {
+	Profile        ProfileResponse         `json:"profile"`
+	IsAdmin        bool                    `json:"is_admin"`
+	EmailChanges   []EmailChangeResponse   `json:"pending_email_changes"`
+	Passkeys       []PasskeyResponse       `json:"passkeys"`
+	LinkedAccounts []LinkedAccountResponse `json:"linked_accounts"`
+	Organizations  []MembershipResponse    `json:"organizations"`
+	AppGrants      []AppGrantResponse      `json:"app_grants"`
+}
+
+type EmailChangeResponse struct {
+	NewEmail  string    `json:"new_email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// PasskeyResponse leaves out public key and other credential state,
+// it identifies user's authenticator only to this service.
+type PasskeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// LinkedAccountResponse is account of upstream identity provider
+// user logs in with.
+type LinkedAccountResponse struct {
+	Issuer   string    `json:"issuer"`
+	Subject  string    `json:"subject"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+type MembershipResponse struct {
+	OrgID    int64     `json:"org_id"`
+	Name     string    `json:"name"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// AppGrantResponse is access to restricted app granted to user directly.
+type AppGrantResponse struct {
+	AppID     int64     `json:"app_id"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+// ExportData returns data stored about user as JSON attachment.
+// Password and token hashes are secrets, so they are left out.
+func (api *serverAPI) ExportData() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		data, err := api.accountService.ExportData(r.Context(), claims.UID)
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		resp := ExportResponse{
+			Profile:        newProfileResponse(&data.User),
+			IsAdmin:        data.User.IsAdmin,
+			EmailChanges:   make([]EmailChangeResponse, 0, len(data.EmailChanges)),
+			Passkeys:       make([]PasskeyResponse, 0, len(data.WebAuthnCredentials)),
+			LinkedAccounts: make([]LinkedAccountResponse, 0, len(data.FederatedIdentities)),
+			Organizations:  make([]MembershipResponse, 0, len(data.Memberships)),
+			AppGrants:      make([]AppGrantResponse, 0, len(data.AppGrants)),
+		}
+		for _, c := range data.EmailChanges {
+			resp.EmailChanges = append(resp.EmailChanges, EmailChangeResponse{
+				NewEmail:  c.NewEmail,
+				ExpiresAt: c.ExpiresAt.UTC(),
+			})
+		}
+		for _, c := range data.WebAuthnCredentials {
+			p := PasskeyResponse{
+				ID:        base64.RawURLEncoding.EncodeToString(c.ID),
+				Name:      c.Name,
+				CreatedAt: c.CreatedAt.UTC(),
+			}
+			if !c.LastUsedAt.IsZero() {
+				lastUsedAt := c.LastUsedAt.UTC()
+				p.LastUsedAt = &lastUsedAt
+			}
+			resp.Passkeys = append(resp.Passkeys, p)
+		}
+		for _, i := range data.FederatedIdentities {
+			resp.LinkedAccounts = append(resp.LinkedAccounts, LinkedAccountResponse{
+				Issuer:   i.Issuer,
+				Subject:  i.Subject,
+				LinkedAt: i.CreatedAt.UTC(),
+			})
+		}
+		for _, m := range data.Memberships {
+			resp.Organizations = append(resp.Organizations, MembershipResponse{
+				OrgID:    m.OrgID,
+				Name:     m.OrgName,
+				Role:     m.Role,
+				JoinedAt: m.CreatedAt.UTC(),
+			})
+		}
+		for _, g := range data.AppGrants {
+			resp.AppGrants = append(resp.AppGrants, AppGrantResponse{
+				AppID:     g.AppID,
+				GrantedAt: g.CreatedAt.UTC(),
+			})
+		}
+
+		w.Header().Set(
+			"Content-Disposition",
+			fmt.Sprintf(`attachment; filename="account-%d.json"`, claims.UID),
+		)
+		render.JSON(w, r, resp)
+	}
+}
@@ -0,0 +1,142 @@
+package account_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	accounthttp "github.com/Woland-prj/microtasks_sso/internal/http/account"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/validation"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const token = "token"
+
+type fakeAuthenticator struct{}
+
+func (fakeAuthenticator) Authenticate(_ context.Context, t string, appID int64) (*entities.TokenClaims, error) {
+	if t != token || appID != 1 {
+		return nil, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat)
+	}
+	return &entities.TokenClaims{UID: 1, Email: "a@b.c", AppId: 1}, nil
+}
+
+type fakeAccountService struct {
+	user *entities.User
+}
+
+func (f *fakeAccountService) GetProfile(_ context.Context, uid int64) (*entities.User, error) {
+	if uint64(uid) != f.user.UID {
+		return nil, cerrors.NewNotFoundError("user")
+	}
+	return f.user, nil
+}
+
+func (f *fakeAccountService) UpdateProfile(_ context.Context, dto dtos.UpdateProfileDto) (*entities.User, error) {
+	if dto.DisplayName != nil {
+		f.user.DisplayName = *dto.DisplayName
+	}
+	if dto.AvatarURL != nil {
+		f.user.AvatarURL = *dto.AvatarURL
+	}
+	if dto.Locale != nil {
+		f.user.Locale = *dto.Locale
+	}
+	if dto.Timezone != nil {
+		f.user.Timezone = *dto.Timezone
+	}
+	return f.user, nil
+}
+
+func (f *fakeAccountService) RequestEmailChange(context.Context, dtos.ChangeEmailDto) error {
+	return nil
+}
+
+func (f *fakeAccountService) ConfirmEmailChange(context.Context, string) error {
+	return nil
+}
+
+func (f *fakeAccountService) DeleteAccount(context.Context, dtos.DeleteAccountDto) (time.Time, error) {
+	return time.Now(), nil
+}
+
+func (f *fakeAccountService) ExportData(_ context.Context, uid int64) (*entities.AccountData, error) {
+	usr, err := f.GetProfile(context.Background(), uid)
+	if err != nil {
+		return nil, err
+	}
+	return &entities.AccountData{User: *usr}, nil
+}
+
+func newRouter(service accounthttp.AccountService) http.Handler {
+	router := chi.NewRouter()
+	accounthttp.Register(router, service, fakeAuthenticator{}, 1, validation.New())
+	return router
+}
+
+func serve(t *testing.T, h http.Handler, method string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, "/profile", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestProfile(t *testing.T) {
+	service := &fakeAccountService{user: &entities.User{
+		UID:   1,
+		Email: "a@b.c",
+		Profile: entities.Profile{
+			DisplayName: "Alice",
+			AvatarURL:   "https://example.com/a.png",
+			Locale:      "en-US",
+		},
+	}}
+	router := newRouter(service)
+
+	// Only present fields change, empty string clears field
+	w := serve(t, router, http.MethodPatch, `{"display_name":"Bob","avatar_url":"","timezone":"Europe/Moscow"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(t, router, http.MethodGet, "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var profile accounthttp.ProfileResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&profile))
+	assert.Equal(t, accounthttp.ProfileResponse{
+		Uid:         1,
+		Email:       "a@b.c",
+		DisplayName: "Bob",
+		Locale:      "en-US",
+		Timezone:    "Europe/Moscow",
+	}, profile)
+}
+
+func TestUpdateProfile_Invalid(t *testing.T) {
+	router := newRouter(&fakeAccountService{user: &entities.User{UID: 1}})
+
+	w := serve(t, router, http.MethodPatch, `{"avatar_url":"not a url","locale":"??","timezone":"Mars/Olympus"}`)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	var body apierror.ErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+
+	fields := make([]string, 0, len(body.Violations))
+	for _, v := range body.Violations {
+		fields = append(fields, v.Field)
+	}
+	assert.ElementsMatch(t, []string{"avatar_url", "locale", "timezone"}, fields)
+}
@@ -0,0 +1,312 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/http/middleware/authn"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/validation"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+// AppService manages access to apps. It checks that
+// caller is admin itself.
+type AppService interface {
+	SetRestricted(
+		ctx context.Context,
+		dto dtos.SetAppRestrictedDto,
+	) error
+	SetPolicy(
+		ctx context.Context,
+		dto dtos.SetAppPolicyDto,
+	) (*entities.App, error)
+	Grants(
+		ctx context.Context,
+		uid int64,
+		appID int64,
+	) ([]entities.AppGrant, error)
+	GrantAccess(
+		ctx context.Context,
+		dto dtos.GrantAppAccessDto,
+	) (*entities.AppGrant, error)
+	RevokeAccess(
+		ctx context.Context,
+		dto dtos.RevokeAppAccessDto,
+	) error
+}
+
+type serverAPI struct {
+	appService AppService
+	validate   *validator.Validate
+}
+
+// Register adds admin endpoints. They accept tokens of admin console
+// app adminAppID only, tokens of an ordinary app could be forged for
+// any admin by client holding its secret.
+func Register(
+	router chi.Router,
+	service AppService,
+	authenticator authn.Authenticator,
+	adminAppID int64,
+	validate *validator.Validate,
+) {
+	api := serverAPI{appService: service, validate: validate}
+
+	router.Group(func(r chi.Router) {
+		r.Use(authn.New(authenticator, adminAppID))
+		r.Put("/admin/apps/{id}/restricted", api.SetRestricted())
+		r.Put("/admin/apps/{id}/policy", api.SetPolicy())
+		r.Get("/admin/apps/{id}/grants", api.Grants())
+		r.Post("/admin/apps/{id}/grants", api.GrantAccess())
+		r.Delete("/admin/apps/{id}/grants/{grant_id}", api.RevokeAccess())
+	})
+}
+
+type SetRestrictedRequest struct {
+	Restricted *bool `json:"restricted" validate:"required"`
+}
+
+func (api *serverAPI) SetRestricted() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		appID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			apierror.WriteHTTP(w, r, cerrors.NewNotFoundError("app"))
+			return
+		}
+
+		var req SetRestrictedRequest
+		err = render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		err = api.appService.SetRestricted(r.Context(), dtos.SetAppRestrictedDto{
+			UID:        claims.UID,
+			AppID:      appID,
+			Restricted: *req.Restricted,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// PolicyRequest replaces whole policy of app. Empty TTLs fall back
+// to global ones, empty grant_types allows all.
+type PolicyRequest struct {
+	AuthTokenTTL    string   `json:"auth_token_ttl" validate:"omitempty,duration"`
+	RefreshTokenTTL string   `json:"refresh_token_ttl" validate:"omitempty,duration"`
+	RequireMFA      bool     `json:"require_mfa"`
+	GrantTypes      []string `json:"grant_types" validate:"dive,oneof=password refresh magic_link webauthn federation"`
+}
+
+type PolicyResponse struct {
+	AuthTokenTTL    string   `json:"auth_token_ttl,omitempty"`
+	RefreshTokenTTL string   `json:"refresh_token_ttl,omitempty"`
+	RequireMFA      bool     `json:"require_mfa"`
+	GrantTypes      []string `json:"grant_types"`
+}
+
+func (api *serverAPI) SetPolicy() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		appID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			apierror.WriteHTTP(w, r, cerrors.NewNotFoundError("app"))
+			return
+		}
+
+		var req PolicyRequest
+		err = render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		// Validated above
+		authTTL, _ := parseTTL(req.AuthTokenTTL)
+		refreshTTL, _ := parseTTL(req.RefreshTokenTTL)
+
+		app, err := api.appService.SetPolicy(r.Context(), dtos.SetAppPolicyDto{
+			UID:             claims.UID,
+			AppID:           appID,
+			AuthTokenTTL:    authTTL,
+			RefreshTokenTTL: refreshTTL,
+			RequireMFA:      req.RequireMFA,
+			GrantTypes:      req.GrantTypes,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		resp := PolicyResponse{
+			RequireMFA: app.RequireMFA,
+			GrantTypes: make([]string, 0, len(app.GrantTypes)),
+		}
+		if app.AuthTokenTTL > 0 {
+			resp.AuthTokenTTL = app.AuthTokenTTL.String()
+		}
+		if app.RefreshTokenTTL > 0 {
+			resp.RefreshTokenTTL = app.RefreshTokenTTL.String()
+		}
+		for _, t := range app.GrantTypes {
+			resp.GrantTypes = append(resp.GrantTypes, string(t))
+		}
+
+		render.JSON(w, r, resp)
+	}
+}
+
+// parseTTL returns 0 for empty ttl.
+func parseTTL(ttl string) (time.Duration, error) {
+	if ttl == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(ttl)
+}
+
+// GrantResponse has either uid or org_id of grantee.
+type GrantResponse struct {
+	ID        int64     `json:"id"`
+	UID       int64     `json:"uid,omitempty"`
+	OrgID     int64     `json:"org_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newGrantResponse(g *entities.AppGrant) GrantResponse {
+	return GrantResponse{
+		ID:        g.ID,
+		UID:       g.UserID,
+		OrgID:     g.OrgID,
+		CreatedAt: g.CreatedAt.UTC(),
+	}
+}
+
+func (api *serverAPI) Grants() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		appID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			apierror.WriteHTTP(w, r, cerrors.NewNotFoundError("app"))
+			return
+		}
+
+		grants, err := api.appService.Grants(r.Context(), claims.UID, appID)
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		resp := make([]GrantResponse, 0, len(grants))
+		for i := range grants {
+			resp = append(resp, newGrantResponse(&grants[i]))
+		}
+
+		render.JSON(w, r, resp)
+	}
+}
+
+// GrantAccessRequest grants access either to user by email
+// or to every member of organization.
+type GrantAccessRequest struct {
+	Email string `json:"email" validate:"required_without=OrgID,excluded_with=OrgID,omitempty,email"`
+	OrgID int64  `json:"org_id"`
+}
+
+func (api *serverAPI) GrantAccess() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		appID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			apierror.WriteHTTP(w, r, cerrors.NewNotFoundError("app"))
+			return
+		}
+
+		var req GrantAccessRequest
+		err = render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		grant, err := api.appService.GrantAccess(r.Context(), dtos.GrantAppAccessDto{
+			UID:   claims.UID,
+			AppID: appID,
+			Email: req.Email,
+			OrgID: req.OrgID,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, newGrantResponse(grant))
+	}
+}
+
+func (api *serverAPI) RevokeAccess() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		appID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			apierror.WriteHTTP(w, r, cerrors.NewNotFoundError("app"))
+			return
+		}
+
+		grantID, err := strconv.ParseInt(chi.URLParam(r, "grant_id"), 10, 64)
+		if err != nil {
+			apierror.WriteHTTP(w, r, cerrors.NewNotFoundError("grant"))
+			return
+		}
+
+		err = api.appService.RevokeAccess(r.Context(), dtos.RevokeAppAccessDto{
+			UID:     claims.UID,
+			AppID:   appID,
+			GrantID: grantID,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,142 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	adminhttp "github.com/Woland-prj/microtasks_sso/internal/http/admin"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/validation"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const adminAppID = 3
+
+// tokenApps maps tokens of admin to apps they were issued for.
+var tokenApps = map[string]int64{
+	"admin-console": adminAppID,
+	"ordinary":      1,
+}
+
+// fakeAuthenticator accepts tokens of requested app only,
+// like authservice.Authenticate does.
+type fakeAuthenticator struct{}
+
+func (fakeAuthenticator) Authenticate(_ context.Context, token string, appID int64) (*entities.TokenClaims, error) {
+	tokenAppID, ok := tokenApps[token]
+	if !ok {
+		return nil, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat)
+	}
+	if tokenAppID != appID {
+		return nil, cerrors.NewPermissionDeniedError("token of this app is not accepted")
+	}
+	return &entities.TokenClaims{UID: 1, Email: "ann@example.com", AppId: tokenAppID}, nil
+}
+
+// fakeAppService records calls reaching it.
+type fakeAppService struct {
+	calls int
+}
+
+func (f *fakeAppService) SetRestricted(context.Context, dtos.SetAppRestrictedDto) error {
+	f.calls++
+	return nil
+}
+
+func (f *fakeAppService) SetPolicy(_ context.Context, dto dtos.SetAppPolicyDto) (*entities.App, error) {
+	f.calls++
+	return &entities.App{ID: dto.AppID}, nil
+}
+
+func (f *fakeAppService) Grants(context.Context, int64, int64) ([]entities.AppGrant, error) {
+	f.calls++
+	return nil, nil
+}
+
+func (f *fakeAppService) GrantAccess(_ context.Context, dto dtos.GrantAppAccessDto) (*entities.AppGrant, error) {
+	f.calls++
+	return &entities.AppGrant{ID: 1, AppID: dto.AppID, OrgID: dto.OrgID}, nil
+}
+
+func (f *fakeAppService) RevokeAccess(context.Context, dtos.RevokeAppAccessDto) error {
+	f.calls++
+	return nil
+}
+
+func TestAdmin_AdminAppTokenOnly(t *testing.T) {
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPut, "/admin/apps/1/restricted", `{"restricted":true}`},
+		{http.MethodPut, "/admin/apps/1/policy", `{"require_mfa":true}`},
+		{http.MethodGet, "/admin/apps/1/grants", ""},
+		{http.MethodPost, "/admin/apps/1/grants", `{"org_id":1}`},
+		{http.MethodDelete, "/admin/apps/1/grants/1", ""},
+	}
+
+	cases := []struct {
+		name   string
+		token  string
+		status int
+		code   string
+	}{
+		{
+			name:  "admin console app",
+			token: "admin-console",
+		},
+		{
+			name:   "ordinary app",
+			token:  "ordinary",
+			status: http.StatusForbidden,
+			code:   apierror.CodePermissionDenied,
+		},
+		{
+			name:   "unknown token",
+			token:  "forged",
+			status: http.StatusUnauthorized,
+			code:   apierror.CodeTokenInvalid,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := &fakeAppService{}
+			router := chi.NewRouter()
+			adminhttp.Register(router, service, fakeAuthenticator{}, adminAppID, validation.New())
+
+			for _, req := range requests {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(req.method, req.path, strings.NewReader(req.body))
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+				router.ServeHTTP(w, r)
+
+				if tc.code == "" {
+					assert.Less(t, w.Code, 300, "%s %s", req.method, req.path)
+					continue
+				}
+
+				require.Equal(t, tc.status, w.Code, "%s %s", req.method, req.path)
+				var body apierror.ErrorResponse
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+				assert.Equal(t, tc.code, body.Code)
+			}
+
+			if tc.code == "" {
+				assert.Equal(t, len(requests), service.calls)
+			} else {
+				assert.Zero(t, service.calls)
+			}
+		})
+	}
+}
@@ -2,12 +2,13 @@ package auth
 
 import (
 	"context"
-	"errors"
 	"net/http"
 
-	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
 	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
 	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/http/middleware/authn"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/validation"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 	"github.com/go-playground/validator/v10"
@@ -26,6 +27,15 @@ type AuthService interface {
 		ctx context.Context,
 		dto dtos.RefreshDto,
 	) (*entities.JwtTokenPair, error)
+	ChangePassword(
+		ctx context.Context,
+		dto dtos.ChangePasswordDto,
+	) error
+	Authenticate(
+		ctx context.Context,
+		token string,
+		appID int64,
+	) (*entities.TokenClaims, error)
 }
 
 type serverAPI struct {
@@ -34,26 +44,33 @@ type serverAPI struct {
 }
 
 func Register(
-	router *chi.Mux, 
-	service AuthService, 
+	router chi.Router,
+	service AuthService,
+	consoleAppID int64,
 	validate *validator.Validate,
 ) {
 	api := serverAPI{authService: service, validate: validate}
 	router.Post("/login", api.Login())
 	router.Post("/register", api.Register())
 	router.Get("/refresh", api.Refresh())
+
+	router.Group(func(r chi.Router) {
+		r.Use(authn.New(service, consoleAppID))
+		r.Post("/password/change", api.ChangePassword())
+	})
 }
 
+// LoginRequest identifies user by Login which is either email or username.
 type LoginRequest struct {
-	Email    string `json:"email" validate:"required,email"`
+	Login    string `json:"login" validate:"required,email|username"`
+	Email    string `json:"email" validate:"-"` // Deprecated: use Login
 	Password string `json:"password" validate:"required"`
 	AppId    int64  `json:"app_id" validate:"required"`
 }
 
 type LoginResponse struct {
-	AuthToken    string `json:"auth_token,omitempty"`
-	RefreshToken string `json:"refresh_token,omitempty"`
-	Error        string `json:"error,omitempty"`
+	AuthToken    string `json:"auth_token"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 func (api *serverAPI) Login() http.HandlerFunc {
@@ -61,28 +78,28 @@ func (api *serverAPI) Login() http.HandlerFunc {
 		var req LoginRequest
 		err := render.DecodeJSON(r.Body, &req)
 		if err != nil {
-			render.JSON(w, r, LoginResponse{Error: "Invalid request"})
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
 			return
 		}
 
+		if req.Login == "" {
+			req.Login = req.Email
+		}
+
 		err = api.validate.Struct(req)
 		if err != nil {
-			render.JSON(w, r, LoginResponse{Error: "Invalid credentials"})
+			apierror.WriteHTTP(w, r, validation.Error("Invalid credentials", err))
 			return
 		}
 
 		tokens, err := api.authService.Login(r.Context(), dtos.LoginDto{
-			Email:    req.Email,
+			Login:    req.Login,
 			Password: req.Password,
 			AppId:    req.AppId,
 		})
 
 		if err != nil {
-			if errors.Is(err, &cerrors.InvalidCredentialsError{}) {
-				render.JSON(w, r, LoginResponse{Error: "Invalid credentials"})
-				return
-			}
-			render.JSON(w, r, LoginResponse{Error: "Internal error"})
+			apierror.WriteHTTP(w, r, err)
 			return
 		}
 
@@ -94,13 +111,17 @@ func (api *serverAPI) Login() http.HandlerFunc {
 }
 
 type RegisterRequest struct {
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required"`
+	Email       string `json:"email" validate:"required,email"`
+	Username    string `json:"username" validate:"omitempty,username"`
+	Password    string `json:"password" validate:"required"`
+	DisplayName string `json:"display_name" validate:"max=64"`
+	AvatarURL   string `json:"avatar_url" validate:"eq=|http_url"`
+	Locale      string `json:"locale" validate:"eq=|bcp47_language_tag"`
+	Timezone    string `json:"timezone" validate:"eq=|timezone"`
 }
 
 type RegisterResponse struct {
-	Uid int64 `json:"uid,omitempty"`
-	Error string `json:"error,omitempty"`
+	Uid int64 `json:"uid"`
 }
 
 func (api *serverAPI) Register() http.HandlerFunc {
@@ -108,27 +129,28 @@ func (api *serverAPI) Register() http.HandlerFunc {
 		var req RegisterRequest
 		err := render.DecodeJSON(r.Body, &req)
 		if err != nil {
-			render.JSON(w, r, RegisterResponse{Error: "Invalid request"})
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
 			return
 		}
 
 		err = api.validate.Struct(req)
 		if err != nil {
-			render.JSON(w, r, RegisterResponse{Error: "Invalid credentials"})
+			apierror.WriteHTTP(w, r, validation.Error("Invalid credentials", err))
 			return
 		}
 
 		uid, err := api.authService.Register(r.Context(), dtos.RegisterDto{
-			Email:    req.Email,
-			Password: req.Password,
+			Email:       req.Email,
+			Username:    req.Username,
+			Password:    req.Password,
+			DisplayName: req.DisplayName,
+			AvatarURL:   req.AvatarURL,
+			Locale:      req.Locale,
+			Timezone:    req.Timezone,
 		})
 
 		if err != nil {
-			if errors.Is(err, &cerrors.AlreadyExistsError{}) {
-				render.JSON(w, r, RegisterResponse{Error: "User already exists"})
-				return
-			}
-			render.JSON(w, r, RegisterResponse{Error: "Internal error"})
+			apierror.WriteHTTP(w, r, err)
 			return
 		}
 
@@ -146,13 +168,13 @@ func (api *serverAPI) Refresh() http.HandlerFunc {
 		var req RefreshRequest
 		err := render.DecodeJSON(r.Body, &req)
 		if err != nil {
-			render.JSON(w, r, LoginResponse{Error: "Invalid request"})
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
 			return
 		}
 
 		err = api.validate.Struct(req)
 		if err != nil {
-			render.JSON(w, r, LoginResponse{Error: "Bad format"})
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
 			return
 		}
 
@@ -162,18 +184,7 @@ func (api *serverAPI) Refresh() http.HandlerFunc {
 		})
 
 		if err != nil {
-			var cErr cerrors.InvalidTokenError
-			if errors.As(err, &cErr) {
-				switch(cErr.Subject()) {
-					case cerrors.TokenExpired:
-						render.JSON(w, r, LoginResponse{Error: "Token expired"})
-						return
-					case cerrors.TokenBadFormat:
-						render.JSON(w, r, LoginResponse{Error: "Fake token"})
-						return
-				}
-			}
-			render.JSON(w, r, LoginResponse{Error: "Internal error"})
+			apierror.WriteHTTP(w, r, err)
 			return
 		}
 
@@ -182,4 +193,41 @@ func (api *serverAPI) Refresh() http.HandlerFunc {
 			RefreshToken: tokens.RefreshToken,
 		})
 	}
-}
\ No newline at end of file
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+
+func (api *serverAPI) ChangePassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		var req ChangePasswordRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		err = api.authService.ChangePassword(r.Context(), dtos.ChangePasswordDto{
+			UID:         claims.UID,
+			OldPassword: req.OldPassword,
+			NewPassword: req.NewPassword,
+		})
+
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
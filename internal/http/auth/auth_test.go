@@ -0,0 +1,251 @@
+package auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	authhttp "github.com/Woland-prj/microtasks_sso/internal/http/auth"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/validation"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const validJwt = "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJpZCI6MX0.dGVzdA"
+
+type fakeAuthService struct {
+	err error
+}
+
+func (f *fakeAuthService) Login(context.Context, dtos.LoginDto) (*entities.JwtTokenPair, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &entities.JwtTokenPair{AuthToken: "auth", RefreshToken: "refresh"}, nil
+}
+
+func (f *fakeAuthService) Register(context.Context, dtos.RegisterDto) (int64, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	return 1, nil
+}
+
+func (f *fakeAuthService) Refresh(context.Context, dtos.RefreshDto) (*entities.JwtTokenPair, error) {
+	return f.Login(context.Background(), dtos.LoginDto{})
+}
+
+func (f *fakeAuthService) ChangePassword(context.Context, dtos.ChangePasswordDto) error {
+	return f.err
+}
+
+func (f *fakeAuthService) Authenticate(_ context.Context, token string, appID int64) (*entities.TokenClaims, error) {
+	if token != validJwt || appID != 1 {
+		return nil, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat)
+	}
+	return &entities.TokenClaims{UID: 1, Email: "a@b.c", AppId: 1}, nil
+}
+
+func TestHandlers_Status(t *testing.T) {
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		err    error
+		status int
+		code   string
+		fields []string
+	}{
+		{
+			name:   "login ok",
+			method: http.MethodPost,
+			path:   "/login",
+			body:   `{"email":"a@b.c","password":"p","app_id":1}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "login malformed body",
+			method: http.MethodPost,
+			path:   "/login",
+			body:   `{`,
+			status: http.StatusBadRequest,
+			code:   apierror.CodeInvalidRequest,
+		},
+		{
+			name:   "login neither email nor username",
+			method: http.MethodPost,
+			path:   "/login",
+			body:   `{"login":"a b.c","password":"p"}`,
+			status: http.StatusUnprocessableEntity,
+			code:   apierror.CodeValidationFailed,
+			fields: []string{"login", "app_id"},
+		},
+		{
+			name:   "login by username",
+			method: http.MethodPost,
+			path:   "/login",
+			body:   `{"login":"bob.smith","password":"p","app_id":1}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "register invalid username",
+			method: http.MethodPost,
+			path:   "/register",
+			body:   `{"email":"a@b.c","username":"1bob","password":"p"}`,
+			status: http.StatusUnprocessableEntity,
+			code:   apierror.CodeValidationFailed,
+			fields: []string{"username"},
+		},
+		{
+			name:   "login bad credentials",
+			method: http.MethodPost,
+			path:   "/login",
+			body:   `{"email":"a@b.c","password":"p","app_id":1}`,
+			err:    cerrors.NewInvalidCredentialsError(),
+			status: http.StatusUnauthorized,
+			code:   apierror.CodeInvalidCredentials,
+		},
+		{
+			name:   "login unknown app",
+			method: http.MethodPost,
+			path:   "/login",
+			body:   `{"email":"a@b.c","password":"p","app_id":7}`,
+			err:    cerrors.NewNotFoundError("app 7"),
+			status: http.StatusNotFound,
+			code:   apierror.CodeNotFound,
+		},
+		{
+			name:   "register ok",
+			method: http.MethodPost,
+			path:   "/register",
+			body:   `{"email":"a@b.c","password":"p"}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "register exists",
+			method: http.MethodPost,
+			path:   "/register",
+			body:   `{"email":"a@b.c","password":"p"}`,
+			err:    cerrors.NewAlreadyExistsError("user 0"),
+			status: http.StatusConflict,
+			code:   apierror.CodeAlreadyExists,
+		},
+		{
+			name:   "register internal",
+			method: http.MethodPost,
+			path:   "/register",
+			body:   `{"email":"a@b.c","password":"p"}`,
+			err:    cerrors.NewCriticalInternalError("stmt.ExecContext", errors.New("boom")),
+			status: http.StatusInternalServerError,
+			code:   apierror.CodeInternal,
+		},
+		{
+			name:   "refresh bad format",
+			method: http.MethodGet,
+			path:   "/refresh",
+			body:   `{"refresh_token":"nope","app_id":1}`,
+			status: http.StatusUnprocessableEntity,
+			code:   apierror.CodeValidationFailed,
+			fields: []string{"refresh_token"},
+		},
+		{
+			name:   "refresh expired",
+			method: http.MethodGet,
+			path:   "/refresh",
+			body:   `{"refresh_token":"` + validJwt + `","app_id":1}`,
+			err:    cerrors.NewInvalidTokenError(cerrors.TokenExpired),
+			status: http.StatusUnauthorized,
+			code:   apierror.CodeTokenExpired,
+		},
+		{
+			name:   "refresh fake",
+			method: http.MethodGet,
+			path:   "/refresh",
+			body:   `{"refresh_token":"` + validJwt + `","app_id":1}`,
+			err:    cerrors.NewInvalidTokenError(cerrors.TokenBadFormat),
+			status: http.StatusUnauthorized,
+			code:   apierror.CodeTokenInvalid,
+		},
+		{
+			name:   "change password ok",
+			method: http.MethodPost,
+			path:   "/password/change",
+			token:  validJwt,
+			body:   `{"old_password":"old","new_password":"Correct1Horse"}`,
+			status: http.StatusNoContent,
+		},
+		{
+			name:   "change password without token",
+			method: http.MethodPost,
+			path:   "/password/change",
+			body:   `{"old_password":"old","new_password":"Correct1Horse"}`,
+			status: http.StatusUnauthorized,
+			code:   apierror.CodeTokenInvalid,
+		},
+		{
+			name:   "change password fake token",
+			method: http.MethodPost,
+			path:   "/password/change",
+			token:  "nope",
+			body:   `{"old_password":"old","new_password":"Correct1Horse"}`,
+			status: http.StatusUnauthorized,
+			code:   apierror.CodeTokenInvalid,
+		},
+		{
+			name:   "change password weak",
+			method: http.MethodPost,
+			path:   "/password/change",
+			token:  validJwt,
+			body:   `{"old_password":"old","new_password":"short"}`,
+			err: cerrors.NewValidationError("Weak password", cerrors.FieldViolation{
+				Field:       "password",
+				Description: "must be at least 8 characters long",
+			}),
+			status: http.StatusUnprocessableEntity,
+			code:   apierror.CodeValidationFailed,
+			fields: []string{"password"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := chi.NewRouter()
+			authhttp.Register(router, &fakeAuthService{err: tc.err}, 1, validation.New())
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			router.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.status, w.Code)
+
+			if tc.code == "" {
+				return
+			}
+
+			var body apierror.ErrorResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+			assert.Equal(t, tc.code, body.Code)
+			assert.NotEmpty(t, body.Error)
+
+			fields := make([]string, 0, len(body.Violations))
+			for _, v := range body.Violations {
+				fields = append(fields, v.Field)
+			}
+			assert.ElementsMatch(t, tc.fields, fields)
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package federation
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/validation"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+type FederationService interface {
+	BeginLogin(
+		ctx context.Context,
+		appID int64,
+	) (authURL string, binding string, err error)
+	FinishLogin(
+		ctx context.Context,
+		dto dtos.FinishFederatedLoginDto,
+	) (*entities.JwtTokenPair, error)
+}
+
+// bindingCookie keeps secret binding login to browser which began it.
+// Client page must call both endpoints from that browser with
+// credentials included, so cookie is sent back with callback.
+const bindingCookie = "federation_binding"
+
+type serverAPI struct {
+	federationService FederationService
+	validate          *validator.Validate
+}
+
+func Register(
+	router chi.Router,
+	service FederationService,
+	validate *validator.Validate,
+) {
+	api := serverAPI{federationService: service, validate: validate}
+
+	router.Post("/federation/login", api.BeginLogin())
+	router.Post("/federation/callback", api.FinishLogin())
+}
+
+type BeginLoginRequest struct {
+	AppId int64 `json:"app_id" validate:"required"`
+}
+
+// BeginLoginResponse carries URL of identity provider
+// client app sends user to.
+type BeginLoginResponse struct {
+	AuthURL string `json:"auth_url"`
+}
+
+func (api *serverAPI) BeginLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req BeginLoginRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		authURL, binding, err := api.federationService.BeginLogin(r.Context(), req.AppId)
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		http.SetCookie(w, newBindingCookie(r, binding, 0))
+		render.JSON(w, r, BeginLoginResponse{AuthURL: authURL})
+	}
+}
+
+type FinishLoginRequest struct {
+	State string `json:"state" validate:"required"`
+	Code  string `json:"code" validate:"required"`
+}
+
+type LoginResponse struct {
+	AuthToken    string `json:"auth_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (api *serverAPI) FinishLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req FinishLoginRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		// Missing cookie leaves binding empty, which matches no state
+		var binding string
+		if cookie, err := r.Cookie(bindingCookie); err == nil {
+			binding = cookie.Value
+		}
+
+		// State is consumed whatever the result, so is the cookie
+		http.SetCookie(w, newBindingCookie(r, "", -1))
+
+		tokens, err := api.federationService.FinishLogin(r.Context(), dtos.FinishFederatedLoginDto{
+			State:   req.State,
+			Code:    req.Code,
+			Binding: binding,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, LoginResponse{
+			AuthToken:    tokens.AuthToken,
+			RefreshToken: tokens.RefreshToken,
+		})
+	}
+}
+
+// newBindingCookie returns cookie with binding scoped to federation
+// endpoints. Negative maxAge deletes it.
+func newBindingCookie(r *http.Request, binding string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     bindingCookie,
+		Value:    binding,
+		Path:     "/federation",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
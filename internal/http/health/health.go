@@ -0,0 +1,48 @@
+package health
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+)
+
+type HealthService interface {
+	Live(ctx context.Context) error
+	Ready(ctx context.Context) error
+}
+
+type serverAPI struct {
+	healthService HealthService
+}
+
+func Register(
+	router chi.Router,
+	service HealthService,
+) {
+	api := serverAPI{healthService: service}
+	router.Get("/healthz", api.probe(service.Live))
+	router.Get("/readyz", api.probe(service.Ready))
+}
+
+type ProbeResponse struct {
+	Status string `json:"status"`
+}
+
+func (api *serverAPI) probe(check func(context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := check(r.Context()); err != nil {
+			render.Status(r, http.StatusServiceUnavailable)
+			render.JSON(w, r, ProbeResponse{Status: statusUnavailable})
+			return
+		}
+
+		render.JSON(w, r, ProbeResponse{Status: statusOK})
+	}
+}
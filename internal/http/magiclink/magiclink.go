@@ -0,0 +1,115 @@
+package magiclink
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/validation"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+type MagicLinkService interface {
+	RequestMagicLink(
+		ctx context.Context,
+		dto dtos.RequestMagicLinkDto,
+	) error
+	ConsumeMagicLink(
+		ctx context.Context,
+		dto dtos.ConsumeMagicLinkDto,
+	) (*entities.JwtTokenPair, error)
+}
+
+type serverAPI struct {
+	magicLinkService MagicLinkService
+	validate         *validator.Validate
+}
+
+func Register(
+	router chi.Router,
+	service MagicLinkService,
+	validate *validator.Validate,
+) {
+	api := serverAPI{magicLinkService: service, validate: validate}
+
+	router.Post("/magic-link", api.RequestMagicLink())
+	// POST keeps mail scanners prefetching links from consuming them
+	router.Post("/magic-link/consume", api.ConsumeMagicLink())
+}
+
+type RequestMagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	AppId int64  `json:"app_id" validate:"required"`
+}
+
+// RequestMagicLink responds 202 whether email is registered or not.
+func (api *serverAPI) RequestMagicLink() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RequestMagicLinkRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		err = api.magicLinkService.RequestMagicLink(r.Context(), dtos.RequestMagicLinkDto{
+			Email: req.Email,
+			AppId: req.AppId,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+type ConsumeMagicLinkRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type LoginResponse struct {
+	AuthToken    string `json:"auth_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (api *serverAPI) ConsumeMagicLink() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ConsumeMagicLinkRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		tokens, err := api.magicLinkService.ConsumeMagicLink(r.Context(), dtos.ConsumeMagicLinkDto{
+			Token: req.Token,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, LoginResponse{
+			AuthToken:    tokens.AuthToken,
+			RefreshToken: tokens.RefreshToken,
+		})
+	}
+}
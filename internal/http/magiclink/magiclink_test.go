@@ -0,0 +1,103 @@
+package magiclink_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	magiclinkhttp "github.com/Woland-prj/microtasks_sso/internal/http/magiclink"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/validation"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeMagicLinkService struct {
+	err error
+}
+
+func (f *fakeMagicLinkService) RequestMagicLink(context.Context, dtos.RequestMagicLinkDto) error {
+	return f.err
+}
+
+func (f *fakeMagicLinkService) ConsumeMagicLink(context.Context, dtos.ConsumeMagicLinkDto) (*entities.JwtTokenPair, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &entities.JwtTokenPair{AuthToken: "auth", RefreshToken: "refresh"}, nil
+}
+
+func TestHandlers_Status(t *testing.T) {
+	cases := []struct {
+		name   string
+		path   string
+		body   string
+		err    error
+		status int
+		code   string
+	}{
+		{
+			name:   "request ok",
+			path:   "/magic-link",
+			body:   `{"email":"a@b.c","app_id":1}`,
+			status: http.StatusAccepted,
+		},
+		{
+			name:   "request without app",
+			path:   "/magic-link",
+			body:   `{"email":"a@b.c"}`,
+			status: http.StatusUnprocessableEntity,
+			code:   apierror.CodeValidationFailed,
+		},
+		{
+			name:   "request unknown app",
+			path:   "/magic-link",
+			body:   `{"email":"a@b.c","app_id":7}`,
+			err:    cerrors.NewNotFoundError("app 7"),
+			status: http.StatusNotFound,
+			code:   apierror.CodeNotFound,
+		},
+		{
+			name:   "consume ok",
+			path:   "/magic-link/consume",
+			body:   `{"token":"t"}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "consume used link",
+			path:   "/magic-link/consume",
+			body:   `{"token":"t"}`,
+			err:    cerrors.NewInvalidTokenError(cerrors.TokenBadFormat),
+			status: http.StatusUnauthorized,
+			code:   apierror.CodeTokenInvalid,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := chi.NewRouter()
+			magiclinkhttp.Register(router, &fakeMagicLinkService{err: tc.err}, validation.New())
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+			router.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.status, w.Code)
+
+			if tc.code == "" {
+				return
+			}
+
+			var body apierror.ErrorResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+			assert.Equal(t, tc.code, body.Code)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package authn
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+)
+
+type Authenticator interface {
+	Authenticate(
+		ctx context.Context,
+		token string,
+		appID int64,
+	) (*entities.TokenClaims, error)
+}
+
+type ctxKey struct{}
+
+// New returns middleware rejecting requests without valid
+// "Authorization: Bearer <auth token>" header with token of app appID.
+// Claims of accepted token are available to handlers via Claims.
+func New(authenticator Authenticator, appID int64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") || token == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				apierror.WriteHTTP(w, r, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat))
+				return
+			}
+
+			claims, err := authenticator.Authenticate(r.Context(), token, appID)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				apierror.WriteHTTP(w, r, err)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), ctxKey{}, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// Claims returns claims of token request was authenticated with.
+func Claims(ctx context.Context) (*entities.TokenClaims, bool) {
+	claims, ok := ctx.Value(ctxKey{}).(*entities.TokenClaims)
+	return claims, ok
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/lib/metrics"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// New returns middleware recording duration of requests
+// labeled by method, route pattern and status.
+func New() func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			t1 := time.Now()
+			defer func() {
+				// Route pattern is known only after routing, use it
+				// instead of path to keep labels cardinality bounded
+				route := chi.RouteContext(r.Context()).RoutePattern()
+				if route == "" {
+					route = "unmatched"
+				}
+
+				metrics.HTTPRequestDuration.
+					WithLabelValues(r.Method, route, strconv.Itoa(ww.Status())).
+					Observe(time.Since(t1).Seconds())
+			}()
+
+			next.ServeHTTP(ww, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,369 @@
+package organization
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/http/middleware/authn"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/validation"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+type OrganizationService interface {
+	CreateOrganization(
+		ctx context.Context,
+		dto dtos.CreateOrganizationDto,
+	) (*entities.Organization, error)
+	Organizations(
+		ctx context.Context,
+		uid int64,
+	) ([]entities.Membership, error)
+	Members(
+		ctx context.Context,
+		uid int64,
+		orgID int64,
+	) ([]entities.Membership, error)
+	InviteMember(
+		ctx context.Context,
+		dto dtos.InviteMemberDto,
+	) error
+	AcceptInvitation(
+		ctx context.Context,
+		dto dtos.AcceptInvitationDto,
+	) (*entities.Membership, error)
+	RemoveMember(
+		ctx context.Context,
+		dto dtos.RemoveMemberDto,
+	) error
+}
+
+type OrganizationSwitcher interface {
+	SwitchOrganization(
+		ctx context.Context,
+		dto dtos.SwitchOrganizationDto,
+	) (*entities.JwtTokenPair, error)
+}
+
+type serverAPI struct {
+	organizationService OrganizationService
+	switcher            OrganizationSwitcher
+	validate            *validator.Validate
+}
+
+func Register(
+	router chi.Router,
+	service OrganizationService,
+	switcher OrganizationSwitcher,
+	authenticator authn.Authenticator,
+	consoleAppID int64,
+	validate *validator.Validate,
+) {
+	api := serverAPI{organizationService: service, switcher: switcher, validate: validate}
+
+	router.Group(func(r chi.Router) {
+		r.Use(authn.New(authenticator, consoleAppID))
+		r.Post("/organizations", api.CreateOrganization())
+		r.Get("/organizations", api.Organizations())
+		r.Post("/organizations/switch", api.SwitchOrganization())
+		r.Get("/organizations/{id}/members", api.Members())
+		r.Post("/organizations/{id}/members", api.InviteMember())
+		r.Post("/organizations/invitations/accept", api.AcceptInvitation())
+		r.Delete("/organizations/{id}/members/{uid}", api.RemoveMember())
+	})
+}
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name" validate:"required,max=64"`
+}
+
+type OrganizationResponse struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (api *serverAPI) CreateOrganization() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		var req CreateOrganizationRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		org, err := api.organizationService.CreateOrganization(r.Context(), dtos.CreateOrganizationDto{
+			UID:  claims.UID,
+			Name: req.Name,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, OrganizationResponse{
+			ID:        org.ID,
+			Name:      org.Name,
+			CreatedAt: org.CreatedAt.UTC(),
+		})
+	}
+}
+
+// MembershipResponse is organization as seen by its member.
+type MembershipResponse struct {
+	OrgID    int64     `json:"org_id"`
+	Name     string    `json:"name"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+	Active   bool      `json:"active"`
+}
+
+// Organizations lists organizations of user, marking the one
+// active in token.
+func (api *serverAPI) Organizations() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		memberships, err := api.organizationService.Organizations(r.Context(), claims.UID)
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		resp := make([]MembershipResponse, 0, len(memberships))
+		for _, m := range memberships {
+			resp = append(resp, MembershipResponse{
+				OrgID:    m.OrgID,
+				Name:     m.OrgName,
+				Role:     m.Role,
+				JoinedAt: m.CreatedAt.UTC(),
+				Active:   m.OrgID == claims.OrgID,
+			})
+		}
+
+		render.JSON(w, r, resp)
+	}
+}
+
+type SwitchOrganizationRequest struct {
+	OrgID int64 `json:"org_id" validate:"required"`
+}
+
+type LoginResponse struct {
+	AuthToken    string `json:"auth_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// SwitchOrganization reissues tokens for app of current token
+// with another active organization.
+func (api *serverAPI) SwitchOrganization() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		var req SwitchOrganizationRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		tokens, err := api.switcher.SwitchOrganization(r.Context(), dtos.SwitchOrganizationDto{
+			UID:      claims.UID,
+			AppId:    claims.AppId,
+			OrgID:    req.OrgID,
+			MFA:      claims.MFA,
+			AuthTime: claims.AuthTime,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, LoginResponse{
+			AuthToken:    tokens.AuthToken,
+			RefreshToken: tokens.RefreshToken,
+		})
+	}
+}
+
+type MemberResponse struct {
+	UID      int64     `json:"uid"`
+	Email    string    `json:"email"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+func newMemberResponse(m *entities.Membership) MemberResponse {
+	return MemberResponse{
+		UID:      m.UserID,
+		Email:    m.Email,
+		Role:     m.Role,
+		JoinedAt: m.CreatedAt.UTC(),
+	}
+}
+
+func (api *serverAPI) Members() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		orgID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			apierror.WriteHTTP(w, r, cerrors.NewNotFoundError("organization"))
+			return
+		}
+
+		members, err := api.organizationService.Members(r.Context(), claims.UID, orgID)
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		resp := make([]MemberResponse, 0, len(members))
+		for i := range members {
+			resp = append(resp, newMemberResponse(&members[i]))
+		}
+
+		render.JSON(w, r, resp)
+	}
+}
+
+// InviteMemberRequest invites registered user by email.
+// Empty role invites a plain member.
+type InviteMemberRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"omitempty,oneof=owner member"`
+}
+
+// InviteMember responds the same whether email is registered or not.
+func (api *serverAPI) InviteMember() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		orgID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			apierror.WriteHTTP(w, r, cerrors.NewNotFoundError("organization"))
+			return
+		}
+
+		var req InviteMemberRequest
+		err = render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		err = api.organizationService.InviteMember(r.Context(), dtos.InviteMemberDto{
+			UID:   claims.UID,
+			OrgID: orgID,
+			Email: req.Email,
+			Role:  req.Role,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+type AcceptInvitationRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// AcceptInvitation makes user of auth token member of organization
+// by token from invitation link.
+func (api *serverAPI) AcceptInvitation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		var req AcceptInvitationRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		m, err := api.organizationService.AcceptInvitation(r.Context(), dtos.AcceptInvitationDto{
+			UID:   claims.UID,
+			Token: req.Token,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, MembershipResponse{
+			OrgID:    m.OrgID,
+			Name:     m.OrgName,
+			Role:     m.Role,
+			JoinedAt: m.CreatedAt.UTC(),
+			Active:   m.OrgID == claims.OrgID,
+		})
+	}
+}
+
+func (api *serverAPI) RemoveMember() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		orgID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			apierror.WriteHTTP(w, r, cerrors.NewNotFoundError("organization"))
+			return
+		}
+
+		memberID, err := strconv.ParseInt(chi.URLParam(r, "uid"), 10, 64)
+		if err != nil {
+			apierror.WriteHTTP(w, r, cerrors.NewNotFoundError("member"))
+			return
+		}
+
+		err = api.organizationService.RemoveMember(r.Context(), dtos.RemoveMemberDto{
+			UID:      claims.UID,
+			OrgID:    orgID,
+			MemberID: memberID,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
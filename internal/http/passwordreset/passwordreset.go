@@ -0,0 +1,105 @@
+package passwordreset
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/validation"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+type PasswordResetService interface {
+	RequestPasswordReset(
+		ctx context.Context,
+		dto dtos.RequestPasswordResetDto,
+	) error
+	ResetPassword(
+		ctx context.Context,
+		dto dtos.ResetPasswordDto,
+	) error
+}
+
+type serverAPI struct {
+	passwordResetService PasswordResetService
+	validate             *validator.Validate
+}
+
+func Register(
+	router chi.Router,
+	service PasswordResetService,
+	validate *validator.Validate,
+) {
+	api := serverAPI{passwordResetService: service, validate: validate}
+
+	router.Post("/password/reset/request", api.RequestPasswordReset())
+	router.Post("/password/reset", api.ResetPassword())
+}
+
+type RequestPasswordResetRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// RequestPasswordReset responds 202 whether email is registered or not.
+func (api *serverAPI) RequestPasswordReset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RequestPasswordResetRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		err = api.passwordResetService.RequestPasswordReset(r.Context(), dtos.RequestPasswordResetDto{
+			Email: req.Email,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+func (api *serverAPI) ResetPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ResetPasswordRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		err = api.passwordResetService.ResetPassword(r.Context(), dtos.ResetPasswordDto{
+			Token:    req.Token,
+			Password: req.Password,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
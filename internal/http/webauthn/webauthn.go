@@ -0,0 +1,283 @@
+package webauthn
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/http/middleware/authn"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/validation"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+type WebAuthnService interface {
+	BeginRegistration(
+		ctx context.Context,
+		dto dtos.BeginWebAuthnRegistrationDto,
+	) (string, *protocol.CredentialCreation, error)
+	FinishRegistration(
+		ctx context.Context,
+		dto dtos.FinishWebAuthnRegistrationDto,
+	) (*entities.WebAuthnCredential, error)
+	BeginLogin(
+		ctx context.Context,
+		appID int64,
+	) (string, *protocol.CredentialAssertion, error)
+	FinishLogin(
+		ctx context.Context,
+		dto dtos.FinishWebAuthnLoginDto,
+	) (*entities.JwtTokenPair, error)
+	Credentials(
+		ctx context.Context,
+		uid int64,
+	) ([]entities.WebAuthnCredential, error)
+	DeleteCredential(
+		ctx context.Context,
+		uid int64,
+		id []byte,
+	) error
+}
+
+type serverAPI struct {
+	webAuthnService WebAuthnService
+	validate        *validator.Validate
+}
+
+func Register(
+	router chi.Router,
+	service WebAuthnService,
+	authenticator authn.Authenticator,
+	consoleAppID int64,
+	validate *validator.Validate,
+) {
+	api := serverAPI{webAuthnService: service, validate: validate}
+
+	router.Post("/webauthn/login/begin", api.BeginLogin())
+	router.Post("/webauthn/login/finish", api.FinishLogin())
+
+	router.Group(func(r chi.Router) {
+		r.Use(authn.New(authenticator, consoleAppID))
+		r.Post("/webauthn/register/begin", api.BeginRegistration())
+		r.Post("/webauthn/register/finish", api.FinishRegistration())
+		r.Get("/webauthn/credentials", api.Credentials())
+		r.Delete("/webauthn/credentials/{id}", api.DeleteCredential())
+	})
+}
+
+// BeginResponse carries options to pass to navigator.credentials
+// and session id to send back with its result.
+type BeginResponse struct {
+	SessionID string `json:"session_id"`
+	Options   any    `json:"options"`
+}
+
+// BeginRegistrationRequest confirms adding passkey by password.
+// It may be omitted, along with body, within reauth period after login.
+type BeginRegistrationRequest struct {
+	Password string `json:"password"`
+}
+
+func (api *serverAPI) BeginRegistration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		var req BeginRegistrationRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil && !errors.Is(err, io.EOF) {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		sessionID, options, err := api.webAuthnService.BeginRegistration(r.Context(), dtos.BeginWebAuthnRegistrationDto{
+			UID:      claims.UID,
+			Password: req.Password,
+			AuthTime: claims.AuthTime,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, BeginResponse{SessionID: sessionID, Options: options})
+	}
+}
+
+type FinishRegistrationRequest struct {
+	SessionID  string          `json:"session_id" validate:"required"`
+	Name       string          `json:"name" validate:"max=64"`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
+
+type CredentialResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func newCredentialResponse(cred *entities.WebAuthnCredential) CredentialResponse {
+	resp := CredentialResponse{
+		ID:        base64.RawURLEncoding.EncodeToString(cred.ID),
+		Name:      cred.Name,
+		CreatedAt: cred.CreatedAt.UTC(),
+	}
+	if !cred.LastUsedAt.IsZero() {
+		lastUsedAt := cred.LastUsedAt.UTC()
+		resp.LastUsedAt = &lastUsedAt
+	}
+
+	return resp
+}
+
+func (api *serverAPI) FinishRegistration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		var req FinishRegistrationRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		cred, err := api.webAuthnService.FinishRegistration(r.Context(), dtos.FinishWebAuthnRegistrationDto{
+			UID:        claims.UID,
+			SessionID:  req.SessionID,
+			Name:       req.Name,
+			Credential: req.Credential,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, newCredentialResponse(cred))
+	}
+}
+
+type BeginLoginRequest struct {
+	AppId int64 `json:"app_id" validate:"required"`
+}
+
+func (api *serverAPI) BeginLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req BeginLoginRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		sessionID, options, err := api.webAuthnService.BeginLogin(r.Context(), req.AppId)
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, BeginResponse{SessionID: sessionID, Options: options})
+	}
+}
+
+type FinishLoginRequest struct {
+	SessionID  string          `json:"session_id" validate:"required"`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
+
+type LoginResponse struct {
+	AuthToken    string `json:"auth_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (api *serverAPI) FinishLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req FinishLoginRequest
+		err := render.DecodeJSON(r.Body, &req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, apierror.InvalidRequest("Invalid request"))
+			return
+		}
+
+		err = api.validate.Struct(req)
+		if err != nil {
+			apierror.WriteHTTP(w, r, validation.Error("Bad format", err))
+			return
+		}
+
+		tokens, err := api.webAuthnService.FinishLogin(r.Context(), dtos.FinishWebAuthnLoginDto{
+			SessionID:  req.SessionID,
+			Credential: req.Credential,
+		})
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, LoginResponse{
+			AuthToken:    tokens.AuthToken,
+			RefreshToken: tokens.RefreshToken,
+		})
+	}
+}
+
+func (api *serverAPI) Credentials() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		creds, err := api.webAuthnService.Credentials(r.Context(), claims.UID)
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		resp := make([]CredentialResponse, 0, len(creds))
+		for i := range creds {
+			resp = append(resp, newCredentialResponse(&creds[i]))
+		}
+
+		render.JSON(w, r, resp)
+	}
+}
+
+func (api *serverAPI) DeleteCredential() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := authn.Claims(r.Context())
+
+		id, err := base64.RawURLEncoding.DecodeString(chi.URLParam(r, "id"))
+		if err != nil {
+			apierror.WriteHTTP(w, r, cerrors.NewNotFoundError("credential"))
+			return
+		}
+
+		err = api.webAuthnService.DeleteCredential(r.Context(), claims.UID, id)
+		if err != nil {
+			apierror.WriteHTTP(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,218 @@
+package apierror
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/render"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// Domain is reported in google.rpc.ErrorInfo of every gRPC error.
+const Domain = "sso.microtasks"
+
+// Machine-readable error codes shared by gRPC and HTTP transports.
+const (
+	CodeInvalidRequest     = "INVALID_REQUEST"
+	CodeValidationFailed   = "VALIDATION_FAILED"
+	CodeInvalidCredentials = "INVALID_CREDENTIALS"
+	CodeAlreadyExists      = "ALREADY_EXISTS"
+	CodeNotFound           = "NOT_FOUND"
+	CodePermissionDenied   = "PERMISSION_DENIED"
+	CodeTokenExpired       = "TOKEN_EXPIRED"
+	CodeTokenInvalid       = "TOKEN_INVALID"
+	CodeUnavailable        = "UNAVAILABLE"
+	CodeInternal           = "INTERNAL"
+)
+
+// Error is an error as it is presented to API clients.
+type Error struct {
+	Code       string
+	Message    string
+	HTTPStatus int
+	GRPCCode   codes.Code
+	Violations []cerrors.FieldViolation
+}
+
+func (err Error) Error() string {
+	return err.Message
+}
+
+// InvalidRequest returns error for requests which can't be decoded.
+func InvalidRequest(message string) Error {
+	return Error{
+		Code:       CodeInvalidRequest,
+		Message:    message,
+		HTTPStatus: http.StatusBadRequest,
+		GRPCCode:   codes.InvalidArgument,
+	}
+}
+
+// From translates error returned by services to API error.
+// Unknown errors are reported as internal without exposing details.
+func From(err error) Error {
+	var (
+		apiErr         Error
+		validationErr  cerrors.ValidationError
+		credErr        cerrors.InvalidCredentialsError
+		tokenErr       cerrors.InvalidTokenError
+		existsErr      cerrors.AlreadyExistsError
+		notFoundErr    cerrors.NotFoundError
+		unavailableErr cerrors.UnavailableError
+		deniedErr      cerrors.PermissionDeniedError
+	)
+
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.As(err, &validationErr):
+		return Error{
+			Code:       CodeValidationFailed,
+			Message:    validationErr.Message,
+			HTTPStatus: http.StatusUnprocessableEntity,
+			GRPCCode:   codes.InvalidArgument,
+			Violations: validationErr.Violations,
+		}
+	case errors.As(err, &credErr):
+		return Error{
+			Code:       CodeInvalidCredentials,
+			Message:    "Invalid credentials",
+			HTTPStatus: http.StatusUnauthorized,
+			GRPCCode:   codes.Unauthenticated,
+		}
+	case errors.As(err, &tokenErr):
+		if tokenErr.Subject() == cerrors.TokenExpired {
+			return Error{
+				Code:       CodeTokenExpired,
+				Message:    "Token expired",
+				HTTPStatus: http.StatusUnauthorized,
+				GRPCCode:   codes.Unauthenticated,
+			}
+		}
+		return Error{
+			Code:       CodeTokenInvalid,
+			Message:    "Fake token",
+			HTTPStatus: http.StatusUnauthorized,
+			GRPCCode:   codes.Unauthenticated,
+		}
+	case errors.As(err, &existsErr):
+		return Error{
+			Code:       CodeAlreadyExists,
+			Message:    entity(existsErr.Subject) + " already exists",
+			HTTPStatus: http.StatusConflict,
+			GRPCCode:   codes.AlreadyExists,
+		}
+	case errors.As(err, &notFoundErr):
+		return Error{
+			Code:       CodeNotFound,
+			Message:    entity(notFoundErr.Subject) + " not found",
+			HTTPStatus: http.StatusNotFound,
+			GRPCCode:   codes.NotFound,
+		}
+	case errors.As(err, &deniedErr):
+		return Error{
+			Code:       CodePermissionDenied,
+			Message:    "Permission denied",
+			HTTPStatus: http.StatusForbidden,
+			GRPCCode:   codes.PermissionDenied,
+		}
+	case errors.As(err, &unavailableErr):
+		return Error{
+			Code:       CodeUnavailable,
+			Message:    "Service unavailable",
+			HTTPStatus: http.StatusServiceUnavailable,
+			GRPCCode:   codes.Unavailable,
+		}
+	default:
+		return Error{
+			Code:       CodeInternal,
+			Message:    "Internal error",
+			HTTPStatus: http.StatusInternalServerError,
+			GRPCCode:   codes.Internal,
+		}
+	}
+}
+
+// entity extracts capitalized entity name from error subject.
+// Subjects are formatted as "<entity> <id>", e.g. "user 42".
+func entity(subject string) string {
+	name, _, _ := strings.Cut(subject, " ")
+	if name == "" {
+		return "Entity"
+	}
+
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// GRPC translates err to gRPC status error carrying google.rpc.ErrorInfo
+// with machine-readable code, google.rpc.BadRequest with field violations
+// and google.rpc.RequestInfo with request id.
+func GRPC(ctx context.Context, err error) error {
+	apiErr := From(err)
+
+	st := status.New(apiErr.GRPCCode, apiErr.Message)
+
+	details := []protoadapt.MessageV1{
+		&errdetails.ErrorInfo{Reason: apiErr.Code, Domain: Domain},
+	}
+	if len(apiErr.Violations) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, v := range apiErr.Violations {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Description,
+			})
+		}
+		details = append(details, br)
+	}
+	if id := middleware.GetReqID(ctx); id != "" {
+		details = append(details, &errdetails.RequestInfo{RequestId: id})
+	}
+
+	if withDetails, dErr := st.WithDetails(details...); dErr == nil {
+		st = withDetails
+	}
+
+	return st.Err()
+}
+
+// ErrorResponse is a body of HTTP error responses.
+// Error keeps human-readable message for backward compatibility.
+type ErrorResponse struct {
+	Code       string      `json:"code"`
+	Error      string      `json:"error"`
+	Violations []Violation `json:"violations,omitempty"`
+	RequestID  string      `json:"request_id,omitempty"`
+}
+
+type Violation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// WriteHTTP translates err and writes it as JSON with matching status code.
+func WriteHTTP(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := From(err)
+
+	resp := ErrorResponse{
+		Code:      apiErr.Code,
+		Error:     apiErr.Message,
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+	for _, v := range apiErr.Violations {
+		resp.Violations = append(resp.Violations, Violation{
+			Field:       v.Field,
+			Description: v.Description,
+		})
+	}
+
+	render.Status(r, apiErr.HTTPStatus)
+	render.JSON(w, r, resp)
+}
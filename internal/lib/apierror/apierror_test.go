@@ -0,0 +1,227 @@
+package apierror_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var cases = []struct {
+	name       string
+	err        error
+	code       string
+	message    string
+	httpStatus int
+	grpcCode   codes.Code
+}{
+	{
+		name:       "invalid request",
+		err:        apierror.InvalidRequest("Invalid request"),
+		code:       apierror.CodeInvalidRequest,
+		message:    "Invalid request",
+		httpStatus: http.StatusBadRequest,
+		grpcCode:   codes.InvalidArgument,
+	},
+	{
+		name: "validation",
+		err: cerrors.NewValidationError("Bad format", cerrors.FieldViolation{
+			Field:       "refresh_token",
+			Description: "must be a valid JWT",
+		}),
+		code:       apierror.CodeValidationFailed,
+		message:    "Bad format",
+		httpStatus: http.StatusUnprocessableEntity,
+		grpcCode:   codes.InvalidArgument,
+	},
+	{
+		name:       "invalid credentials",
+		err:        cerrors.NewInvalidCredentialsError(),
+		code:       apierror.CodeInvalidCredentials,
+		message:    "Invalid credentials",
+		httpStatus: http.StatusUnauthorized,
+		grpcCode:   codes.Unauthenticated,
+	},
+	{
+		name:       "token expired",
+		err:        cerrors.NewInvalidTokenError(cerrors.TokenExpired),
+		code:       apierror.CodeTokenExpired,
+		message:    "Token expired",
+		httpStatus: http.StatusUnauthorized,
+		grpcCode:   codes.Unauthenticated,
+	},
+	{
+		name:       "token bad format",
+		err:        cerrors.NewInvalidTokenError(cerrors.TokenBadFormat),
+		code:       apierror.CodeTokenInvalid,
+		message:    "Fake token",
+		httpStatus: http.StatusUnauthorized,
+		grpcCode:   codes.Unauthenticated,
+	},
+	{
+		name:       "already exists",
+		err:        cerrors.NewAlreadyExistsError("user 0"),
+		code:       apierror.CodeAlreadyExists,
+		message:    "User already exists",
+		httpStatus: http.StatusConflict,
+		grpcCode:   codes.AlreadyExists,
+	},
+	{
+		name:       "not found",
+		err:        cerrors.NewNotFoundError("app 2"),
+		code:       apierror.CodeNotFound,
+		message:    "App not found",
+		httpStatus: http.StatusNotFound,
+		grpcCode:   codes.NotFound,
+	},
+	{
+		name:       "permission denied",
+		err:        cerrors.NewPermissionDeniedError("not a member of organization 3"),
+		code:       apierror.CodePermissionDenied,
+		message:    "Permission denied",
+		httpStatus: http.StatusForbidden,
+		grpcCode:   codes.PermissionDenied,
+	},
+	{
+		name:       "unavailable",
+		err:        cerrors.NewUnavailableError("shutting down"),
+		code:       apierror.CodeUnavailable,
+		message:    "Service unavailable",
+		httpStatus: http.StatusServiceUnavailable,
+		grpcCode:   codes.Unavailable,
+	},
+	{
+		name:       "critical internal",
+		err:        cerrors.NewCriticalInternalError("stmt.ExecContext", errors.New("disk I/O error")),
+		code:       apierror.CodeInternal,
+		message:    "Internal error",
+		httpStatus: http.StatusInternalServerError,
+		grpcCode:   codes.Internal,
+	},
+	{
+		name:       "unknown",
+		err:        errors.New("boom"),
+		code:       apierror.CodeInternal,
+		message:    "Internal error",
+		httpStatus: http.StatusInternalServerError,
+		grpcCode:   codes.Internal,
+	},
+}
+
+func TestFrom(t *testing.T) {
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Services wrap errors with op, translation must see through it
+			wrapped := fmt.Errorf("authservice.Login: %w", tc.err)
+
+			apiErr := apierror.From(wrapped)
+
+			assert.Equal(t, tc.code, apiErr.Code)
+			assert.Equal(t, tc.message, apiErr.Message)
+			assert.Equal(t, tc.httpStatus, apiErr.HTTPStatus)
+			assert.Equal(t, tc.grpcCode, apiErr.GRPCCode)
+		})
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	ctx := context.WithValue(context.Background(), middleware.RequestIDKey, "req-1")
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			st, ok := status.FromError(apierror.GRPC(ctx, tc.err))
+			require.True(t, ok)
+
+			assert.Equal(t, tc.grpcCode, st.Code())
+			assert.Equal(t, tc.message, st.Message())
+
+			var info *errdetails.ErrorInfo
+			var reqInfo *errdetails.RequestInfo
+			for _, d := range st.Details() {
+				switch d := d.(type) {
+				case *errdetails.ErrorInfo:
+					info = d
+				case *errdetails.RequestInfo:
+					reqInfo = d
+				}
+			}
+
+			require.NotNil(t, info)
+			assert.Equal(t, tc.code, info.GetReason())
+			assert.Equal(t, apierror.Domain, info.GetDomain())
+
+			require.NotNil(t, reqInfo)
+			assert.Equal(t, "req-1", reqInfo.GetRequestId())
+		})
+	}
+}
+
+func TestWriteHTTP(t *testing.T) {
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/login", nil)
+
+			apierror.WriteHTTP(w, r, tc.err)
+
+			assert.Equal(t, tc.httpStatus, w.Code)
+
+			var body apierror.ErrorResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+			assert.Equal(t, tc.code, body.Code)
+			assert.Equal(t, tc.message, body.Error)
+		})
+	}
+}
+
+func TestFieldViolations(t *testing.T) {
+	err := cerrors.NewValidationError("Invalid credentials",
+		cerrors.FieldViolation{Field: "email", Description: "must be a valid email address"},
+		cerrors.FieldViolation{Field: "app_id", Description: "is required"},
+	)
+
+	t.Run("grpc", func(t *testing.T) {
+		st := status.Convert(apierror.GRPC(context.Background(), err))
+
+		var br *errdetails.BadRequest
+		for _, d := range st.Details() {
+			if d, ok := d.(*errdetails.BadRequest); ok {
+				br = d
+			}
+		}
+
+		require.NotNil(t, br)
+		require.Len(t, br.GetFieldViolations(), 2)
+		assert.Equal(t, "email", br.GetFieldViolations()[0].GetField())
+		assert.Equal(t, "must be a valid email address", br.GetFieldViolations()[0].GetDescription())
+		assert.Equal(t, "app_id", br.GetFieldViolations()[1].GetField())
+	})
+
+	t.Run("http", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+
+		apierror.WriteHTTP(w, r, err)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		var body apierror.ErrorResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		assert.Equal(t, []apierror.Violation{
+			{Field: "email", Description: "must be a valid email address"},
+			{Field: "app_id", Description: "is required"},
+		}, body.Violations)
+	})
+}
@@ -0,0 +1,163 @@
+package breach
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// prefixLen is a number of hex characters of SHA-1 hash
+// used as a file name in prefix directory.
+const prefixLen = 5
+
+// Checker looks passwords up in offline corpus of breached passwords
+// in Have I Been Pwned format, lines of upper-case hex SHA-1 hash
+// optionally followed by colon and number of occurrences.
+//
+// Corpus is either a directory of k-anonymity range files named
+// by 5 characters hash prefix (e.g. "21BD1.txt") holding hash suffixes,
+// which are read on demand, or a single file of full hashes
+// loaded into memory at start.
+type Checker struct {
+	dir      string
+	hashes   map[[sha1.Size]byte]int
+	minCount int
+}
+
+// New returns Checker reading corpus at path.
+// Passwords seen fewer than minCount times are not reported.
+func New(path string, minCount int) (*Checker, error) {
+	const op = "breach.New"
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	c := &Checker{minCount: minCount}
+
+	if info.IsDir() {
+		c.dir = path
+		return c, nil
+	}
+
+	c.hashes, err = loadHashes(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return c, nil
+}
+
+// IsBreached reports whether password is in corpus.
+func (c *Checker) IsBreached(ctx context.Context, password string) (bool, error) {
+	const op = "breach.IsBreached"
+
+	sum := sha1.Sum([]byte(password))
+
+	var (
+		count int
+		err   error
+	)
+	if c.dir != "" {
+		count, err = c.lookupRange(ctx, sum)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", op, err)
+		}
+	} else {
+		count = c.hashes[sum]
+	}
+
+	return count > 0 && count >= c.minCount, nil
+}
+
+// lookupRange scans range file of hash prefix for its suffix.
+// Missing range file means no password with such prefix was breached.
+func (c *Checker) lookupRange(ctx context.Context, sum [sha1.Size]byte) (int, error) {
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:prefixLen], hash[prefixLen:]
+
+	f, err := os.Open(filepath.Join(c.dir, prefix+".txt"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		line, count, err := parseLine(sc.Text())
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+		if strings.EqualFold(line, suffix) {
+			return count, nil
+		}
+	}
+
+	return 0, sc.Err()
+}
+
+func loadHashes(path string) (map[[sha1.Size]byte]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hashes := make(map[[sha1.Size]byte]int)
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, count, err := parseLine(sc.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if line == "" {
+			continue
+		}
+
+		var sum [sha1.Size]byte
+		if hex.DecodedLen(len(line)) != sha1.Size {
+			return nil, fmt.Errorf("line %d: not a SHA-1 hash", n)
+		}
+		if _, err := hex.Decode(sum[:], []byte(line)); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		hashes[sum] += count
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// parseLine splits "HASH[:COUNT]" line. Count defaults to 1.
+func parseLine(line string) (string, int, error) {
+	hash, rawCount, found := strings.Cut(strings.TrimSpace(line), ":")
+	if !found {
+		return hash, 1, nil
+	}
+
+	count, err := strconv.Atoi(rawCount)
+	if err != nil {
+		return "", 0, fmt.Errorf("bad count %q", rawCount)
+	}
+
+	return hash, count, nil
+}
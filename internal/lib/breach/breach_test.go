@@ -0,0 +1,71 @@
+package breach_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Woland-prj/microtasks_sso/internal/lib/breach"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8,
+// SHA-1 of "letmein" is B7A875FC1EA228B9061041B7CEC4BD3C52AB3CE3.
+
+func TestChecker_Dir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "5BAA6.txt"),
+		[]byte("003D68EB55068C33ACE09247EE4C639306B:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9659365\r\n"),
+		0o644,
+	))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "B7A87.txt"),
+		[]byte("5FC1EA228B9061041B7CEC4BD3C52AB3CE3:2\n"),
+		0o644,
+	))
+
+	c, err := breach.New(dir, 3)
+	require.NoError(t, err)
+
+	assertBreached(t, c, "password", true)
+	assertBreached(t, c, "letmein", false) // below min count
+	assertBreached(t, c, "Correct1Horse", false)
+}
+
+func TestChecker_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	require.NoError(t, os.WriteFile(
+		path,
+		[]byte("5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8\nB7A875FC1EA228B9061041B7CEC4BD3C52AB3CE3:12\n"),
+		0o644,
+	))
+
+	c, err := breach.New(path, 1)
+	require.NoError(t, err)
+
+	assertBreached(t, c, "password", true)
+	assertBreached(t, c, "letmein", true)
+	assertBreached(t, c, "Correct1Horse", false)
+}
+
+func TestChecker_BadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	require.NoError(t, os.WriteFile(path, []byte("not a hash\n"), 0o644))
+
+	_, err := breach.New(path, 1)
+	assert.Error(t, err)
+
+	_, err = breach.New(filepath.Join(t.TempDir(), "missing"), 1)
+	assert.Error(t, err)
+}
+
+func assertBreached(t *testing.T, c *breach.Checker, password string, want bool) {
+	t.Helper()
+
+	got, err := c.IsBreached(context.Background(), password)
+	require.NoError(t, err)
+	assert.Equal(t, want, got, password)
+}
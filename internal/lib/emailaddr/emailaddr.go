@@ -0,0 +1,52 @@
+package emailaddr
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+var ErrInvalid = errors.New("invalid email address")
+
+// Canonical returns address in the form it is stored and shown in:
+// surrounding spaces trimmed, Unicode in NFC and domain lowercased
+// and converted to its ASCII (punycode) form.
+// Local part keeps its case, since it is significant for some mail servers.
+func Canonical(addr string) (string, error) {
+	local, domain, err := split(addr)
+	if err != nil {
+		return "", err
+	}
+
+	return local + "@" + domain, nil
+}
+
+// Key returns identity of address: canonical address with lowercased
+// local part. Addresses with equal keys belong to the same user.
+func Key(addr string) (string, error) {
+	local, domain, err := split(addr)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(local) + "@" + domain, nil
+}
+
+func split(addr string) (string, string, error) {
+	addr = norm.NFC.String(strings.TrimSpace(addr))
+
+	i := strings.LastIndexByte(addr, '@')
+	if i <= 0 || i == len(addr)-1 {
+		return "", "", ErrInvalid
+	}
+
+	// Lookup profile also maps domain to lowercase
+	domain, err := idna.Lookup.ToASCII(strings.TrimSuffix(addr[i+1:], "."))
+	if err != nil || domain == "" {
+		return "", "", ErrInvalid
+	}
+
+	return addr[:i], domain, nil
+}
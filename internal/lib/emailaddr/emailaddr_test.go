@@ -0,0 +1,68 @@
+package emailaddr_test
+
+import (
+	"testing"
+
+	"github.com/Woland-prj/microtasks_sso/internal/lib/emailaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		name      string
+		addr      string
+		canonical string
+		key       string
+	}{
+		{
+			name:      "already canonical",
+			addr:      "bob@example.com",
+			canonical: "bob@example.com",
+			key:       "bob@example.com",
+		},
+		{
+			name:      "mixed case",
+			addr:      "  Bob@X.com ",
+			canonical: "Bob@x.com",
+			key:       "bob@x.com",
+		},
+		{
+			name:      "idn domain",
+			addr:      "Ärger@Bücher.DE",
+			canonical: "Ärger@xn--bcher-kva.de",
+			key:       "ärger@xn--bcher-kva.de",
+		},
+		{
+			name:      "decomposed unicode",
+			addr:      "A\u0308rger@example.com",
+			canonical: "Ärger@example.com",
+			key:       "ärger@example.com",
+		},
+		{
+			name:      "quoted local part with at",
+			addr:      `"a@b"@Example.com`,
+			canonical: `"a@b"@example.com`,
+			key:       `"a@b"@example.com`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			canonical, err := emailaddr.Canonical(tc.addr)
+			require.NoError(t, err)
+			assert.Equal(t, tc.canonical, canonical)
+
+			key, err := emailaddr.Key(tc.addr)
+			require.NoError(t, err)
+			assert.Equal(t, tc.key, key)
+		})
+	}
+}
+
+func TestNormalize_Invalid(t *testing.T) {
+	for _, addr := range []string{"", "bob", "@example.com", "bob@", " bob@ "} {
+		_, err := emailaddr.Key(addr)
+		assert.ErrorIs(t, err, emailaddr.ErrInvalid, addr)
+	}
+}
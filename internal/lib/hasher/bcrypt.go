@@ -0,0 +1,63 @@
+package hasher
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcryptMaxBytes is a number of password bytes bcrypt takes into account.
+const bcryptMaxBytes = 72
+
+// Bcrypt hashes passwords with bcrypt in its modular crypt format,
+// e.g. "$2a$10$<salt><hash>".
+type Bcrypt struct {
+	cost int
+}
+
+// NewBcrypt returns Bcrypt with given cost.
+// Cost out of bcrypt bounds is replaced with default one.
+func NewBcrypt(cost int) *Bcrypt {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+
+	return &Bcrypt{cost: cost}
+}
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func (b *Bcrypt) Verify(hash string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (b *Bcrypt) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.cost
+}
+
+func (b *Bcrypt) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$") ||
+		strings.HasPrefix(hash, "$2y$")
+}
+
+func (b *Bcrypt) MaxPasswordBytes() int {
+	return bcryptMaxBytes
+}
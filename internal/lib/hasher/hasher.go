@@ -0,0 +1,89 @@
+package hasher
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+var ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+
+// Algorithm hashes passwords with a single algorithm.
+// Hash strings are self-describing, so algorithm and its parameters
+// are always recovered from the hash itself.
+type Algorithm interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches hash.
+	Verify(hash string, password string) (bool, error)
+	// NeedsRehash reports whether hash was made with parameters
+	// other than configured ones.
+	NeedsRehash(hash string) bool
+	// Recognizes reports whether hash was made by this algorithm.
+	Recognizes(hash string) bool
+	// MaxPasswordBytes returns number of password bytes taken into
+	// account or 0 if there is no limit.
+	MaxPasswordBytes() int
+}
+
+// Hasher hashes new passwords with preferred algorithm
+// and verifies hashes of any supported one.
+type Hasher struct {
+	preferred Algorithm
+	known     []Algorithm
+}
+
+type Options struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2id   Argon2idParams
+}
+
+// New returns Hasher preferring algorithm set in opts.
+// Hashes of other algorithms are still verified and reported as
+// needing rehash, so users migrate transparently on next login.
+func New(opts Options) (*Hasher, error) {
+	const op = "hasher.New"
+
+	bcrypt := NewBcrypt(opts.BcryptCost)
+	argon2id := NewArgon2id(opts.Argon2id)
+
+	switch opts.Algorithm {
+	case AlgorithmBcrypt:
+		return &Hasher{preferred: bcrypt, known: []Algorithm{bcrypt, argon2id}}, nil
+	case AlgorithmArgon2id:
+		return &Hasher{preferred: argon2id, known: []Algorithm{argon2id, bcrypt}}, nil
+	default:
+		return nil, fmt.Errorf("%s: %w: %q", op, ErrUnknownAlgorithm, opts.Algorithm)
+	}
+}
+
+// Hash returns hash of password made by preferred algorithm.
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.preferred.Hash(password)
+}
+
+// Verify reports whether password matches hash made by any known algorithm.
+func (h *Hasher) Verify(hash string, password string) (bool, error) {
+	for _, alg := range h.known {
+		if alg.Recognizes(hash) {
+			return alg.Verify(hash, password)
+		}
+	}
+
+	return false, ErrUnknownAlgorithm
+}
+
+// NeedsRehash reports whether hash was made by algorithm other than
+// preferred one or with outdated parameters.
+func (h *Hasher) NeedsRehash(hash string) bool {
+	return !h.preferred.Recognizes(hash) || h.preferred.NeedsRehash(hash)
+}
+
+// MaxPasswordBytes returns password length limit of preferred algorithm.
+func (h *Hasher) MaxPasswordBytes() int {
+	return h.preferred.MaxPasswordBytes()
+}
@@ -0,0 +1,95 @@
+package hasher_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Woland-prj/microtasks_sso/internal/lib/hasher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Cheap parameters keep tests fast
+var argon2idParams = hasher.Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestHasher_HashVerify(t *testing.T) {
+	for _, alg := range []string{hasher.AlgorithmBcrypt, hasher.AlgorithmArgon2id} {
+		t.Run(alg, func(t *testing.T) {
+			h, err := hasher.New(hasher.Options{
+				Algorithm:  alg,
+				BcryptCost: 4,
+				Argon2id:   argon2idParams,
+			})
+			require.NoError(t, err)
+
+			hash, err := h.Hash("Correct1Horse")
+			require.NoError(t, err)
+			assert.False(t, h.NeedsRehash(hash))
+
+			ok, err := h.Verify(hash, "Correct1Horse")
+			require.NoError(t, err)
+			assert.True(t, ok)
+
+			ok, err = h.Verify(hash, "Wrong1Horse")
+			require.NoError(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestHasher_PHCFormat(t *testing.T) {
+	h, err := hasher.New(hasher.Options{Algorithm: hasher.AlgorithmArgon2id, Argon2id: argon2idParams})
+	require.NoError(t, err)
+
+	hash, err := h.Hash("Correct1Horse")
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"), hash)
+	assert.Len(t, strings.Split(hash, "$"), 6)
+}
+
+func TestHasher_NeedsRehash(t *testing.T) {
+	oldBcrypt, err := hasher.New(hasher.Options{Algorithm: hasher.AlgorithmBcrypt, BcryptCost: 4})
+	require.NoError(t, err)
+	bcryptHash, err := oldBcrypt.Hash("Correct1Horse")
+	require.NoError(t, err)
+
+	newBcrypt, err := hasher.New(hasher.Options{Algorithm: hasher.AlgorithmBcrypt, BcryptCost: 5})
+	require.NoError(t, err)
+	assert.True(t, newBcrypt.NeedsRehash(bcryptHash), "bcrypt cost changed")
+
+	argon, err := hasher.New(hasher.Options{Algorithm: hasher.AlgorithmArgon2id, Argon2id: argon2idParams})
+	require.NoError(t, err)
+	assert.True(t, argon.NeedsRehash(bcryptHash), "algorithm changed")
+
+	// Hashes of previous algorithm are still accepted
+	ok, err := argon.Verify(bcryptHash, "Correct1Horse")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	argonHash, err := argon.Hash("Correct1Horse")
+	require.NoError(t, err)
+
+	stronger := argon2idParams
+	stronger.Iterations = 2
+	argonStronger, err := hasher.New(hasher.Options{Algorithm: hasher.AlgorithmArgon2id, Argon2id: stronger})
+	require.NoError(t, err)
+	assert.True(t, argonStronger.NeedsRehash(argonHash), "argon2id params changed")
+}
+
+func TestHasher_Unknown(t *testing.T) {
+	_, err := hasher.New(hasher.Options{Algorithm: "md5"})
+	assert.ErrorIs(t, err, hasher.ErrUnknownAlgorithm)
+
+	h, err := hasher.New(hasher.Options{Algorithm: hasher.AlgorithmBcrypt})
+	require.NoError(t, err)
+
+	_, err = h.Verify("$1$salt$hash", "password")
+	assert.ErrorIs(t, err, hasher.ErrUnknownAlgorithm)
+}
@@ -0,0 +1,87 @@
+package hasher
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/lib/metrics"
+)
+
+const (
+	queueResultAcquired = "acquired"
+	queueResultTimeout  = "timeout"
+	queueResultCanceled = "canceled"
+)
+
+// ErrQueueTimeout is returned when no hashing slot was freed
+// within queue timeout.
+var ErrQueueTimeout = errors.New("password hashing queue timeout")
+
+// Limiter bounds number of concurrent password hash computations,
+// so bursts of logins can't saturate all CPUs and starve cheap requests.
+type Limiter struct {
+	sem          chan struct{}
+	queueTimeout time.Duration
+}
+
+// NewLimiter returns Limiter allowing concurrency hash computations
+// at once. Non-positive concurrency means half of available CPUs.
+// Zero queueTimeout means waiting is bounded only by request context.
+func NewLimiter(concurrency int, queueTimeout time.Duration) *Limiter {
+	if concurrency <= 0 {
+		concurrency = max(1, runtime.GOMAXPROCS(0)/2)
+	}
+
+	return &Limiter{
+		sem:          make(chan struct{}, concurrency),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// Acquire waits for a free slot until ctx is done or queue timeout
+// expires. Every successful Acquire must be followed by Release.
+func (l *Limiter) Acquire(ctx context.Context) error {
+	t1 := time.Now()
+
+	if err := ctx.Err(); err != nil {
+		metrics.ObserveDuration(metrics.PasswordHashQueueDuration.WithLabelValues(queueResultCanceled), t1)
+		return err
+	}
+
+	// Fast path, don't touch queue metrics when slot is free
+	select {
+	case l.sem <- struct{}{}:
+		metrics.ObserveDuration(metrics.PasswordHashQueueDuration.WithLabelValues(queueResultAcquired), t1)
+		return nil
+	default:
+	}
+
+	metrics.PasswordHashQueueLength.Inc()
+	defer metrics.PasswordHashQueueLength.Dec()
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case l.sem <- struct{}{}:
+		metrics.ObserveDuration(metrics.PasswordHashQueueDuration.WithLabelValues(queueResultAcquired), t1)
+		return nil
+	case <-timeout:
+		metrics.ObserveDuration(metrics.PasswordHashQueueDuration.WithLabelValues(queueResultTimeout), t1)
+		return ErrQueueTimeout
+	case <-ctx.Done():
+		metrics.ObserveDuration(metrics.PasswordHashQueueDuration.WithLabelValues(queueResultCanceled), t1)
+		return ctx.Err()
+	}
+}
+
+// Release frees slot taken by Acquire.
+func (l *Limiter) Release() {
+	<-l.sem
+}
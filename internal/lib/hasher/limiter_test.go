@@ -0,0 +1,32 @@
+package hasher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/lib/hasher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	l := hasher.NewLimiter(1, 20*time.Millisecond)
+
+	require.NoError(t, l.Acquire(context.Background()))
+
+	// Slot is busy, waiting ends with queue timeout
+	assert.ErrorIs(t, l.Acquire(context.Background()), hasher.ErrQueueTimeout)
+
+	// or with cancellation of caller context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, l.Acquire(ctx), context.Canceled)
+
+	// Released slot is handed to waiting caller
+	done := make(chan error)
+	go func() { done <- l.Acquire(context.Background()) }()
+	l.Release()
+	require.NoError(t, <-done)
+	l.Release()
+}
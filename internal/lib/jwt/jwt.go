@@ -1,6 +1,7 @@
 package jwt
 
 import (
+	"errors"
 	"time"
 
 	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
@@ -13,18 +14,92 @@ const (
 	_tokenTypeRefresh = "refresh"
 )
 
+type options struct {
+	profile  bool
+	orgID    int64
+	mfa      bool
+	authTime time.Time
+}
+
+// Option customizes tokens made by NewTokenPair.
+type Option func(o *options)
+
+// WithProfile adds non-empty profile fields of user to auth token
+// as OpenID Connect standard claims: name, picture, locale and zoneinfo.
+func WithProfile() Option {
+	return func(o *options) {
+		o.profile = true
+	}
+}
+
+// WithOrg adds active organization of user to both tokens
+// as org_id claim, so refresh keeps it. 0 adds nothing.
+func WithOrg(orgID int64) Option {
+	return func(o *options) {
+		o.orgID = orgID
+	}
+}
+
+// WithMFA marks both tokens with mfa claim, so refresh keeps it.
+func WithMFA() Option {
+	return func(o *options) {
+		o.mfa = true
+	}
+}
+
+// WithAuthTime adds time user last proved identity to both tokens
+// as auth_time claim, so refresh keeps it. Zero time adds nothing.
+func WithAuthTime(t time.Time) Option {
+	return func(o *options) {
+		o.authTime = t
+	}
+}
+
+// NewTokenPair issues auth and refresh tokens of user for app.
+// TTLs set for app override authDuration and refreshDuration.
 func NewTokenPair(
 	user *entities.User,
 	app *entities.App,
 	authDuration time.Duration,
 	refreshDuration time.Duration,
+	opts ...Option,
 ) (*entities.JwtTokenPair, error) {
-	authToken, err := newToken(user, app.ID, app.AuthSecret, authDuration)
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if app.AuthTokenTTL > 0 {
+		authDuration = app.AuthTokenTTL
+	}
+	if app.RefreshTokenTTL > 0 {
+		refreshDuration = app.RefreshTokenTTL
+	}
+
+	authClaims := jwt.MapClaims{}
+	refreshClaims := jwt.MapClaims{}
+	if o.profile {
+		addProfileClaims(authClaims, user.Profile)
+	}
+	if o.orgID != 0 {
+		authClaims["org_id"] = o.orgID
+		refreshClaims["org_id"] = o.orgID
+	}
+	if o.mfa {
+		authClaims["mfa"] = true
+		refreshClaims["mfa"] = true
+	}
+	if !o.authTime.IsZero() {
+		authClaims["auth_time"] = o.authTime.Unix()
+		refreshClaims["auth_time"] = o.authTime.Unix()
+	}
+
+	authToken, err := newToken(user, app.ID, app.AuthSecret, authDuration, authClaims)
 	if err != nil {
 		return nil, err
 	}
 
-	refreshToken, err := newToken(user, app.ID, app.RefreshSecret, refreshDuration)
+	refreshToken, err := newToken(user, app.ID, app.RefreshSecret, refreshDuration, refreshClaims)
 	if err != nil {
 		return nil, err
 	}
@@ -40,10 +115,14 @@ func newToken(
 	appId int64,
 	secret string,
 	ttl time.Duration,
+	extra jwt.MapClaims,
 ) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 
 	claims := token.Claims.(jwt.MapClaims)
+	for k, v := range extra {
+		claims[k] = v
+	}
 	claims["id"] = user.UID
 	claims["email"] = user.Email
 	claims["exp"] = time.Now().Add(ttl).Unix()
@@ -58,21 +137,95 @@ func newToken(
 	return tokenString, nil
 }
 
+func addProfileClaims(claims jwt.MapClaims, profile entities.Profile) {
+	for k, v := range map[string]string{
+		"name":     profile.DisplayName,
+		"picture":  profile.AvatarURL,
+		"locale":   profile.Locale,
+		"zoneinfo": profile.Timezone,
+	} {
+		if v != "" {
+			claims[k] = v
+		}
+	}
+}
+
 func ValidateToken(token string, secret string) (int64, error) {
+	claims, err := ParseClaims(token, secret)
+	if err != nil {
+		return 0, err
+	}
+
+	return claims.UID, nil
+}
+
+// ParseClaims validates token signed with secret and returns its claims.
+func ParseClaims(token string, secret string) (*entities.TokenClaims, error) {
 	parsedToken, err := parseToken(token, secret)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, cerrors.NewInvalidTokenError(cerrors.TokenExpired)
+		}
+		return nil, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat)
+	}
+
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok || !parsedToken.Valid {
+		return nil, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat)
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat)
+	}
+	if int64(exp) <= time.Now().Unix() {
+		return nil, cerrors.NewInvalidTokenError(cerrors.TokenExpired)
+	}
+
+	uid, ok := claims["id"].(float64)
+	if !ok {
+		return nil, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat)
+	}
+	appId, _ := claims["app_id"].(float64)
+	email, _ := claims["email"].(string)
+	orgID, _ := claims["org_id"].(float64)
+	mfa, _ := claims["mfa"].(bool)
+
+	var authTime time.Time
+	if t, ok := claims["auth_time"].(float64); ok {
+		authTime = time.Unix(int64(t), 0)
+	}
+
+	return &entities.TokenClaims{
+		UID:      int64(uid),
+		Email:    email,
+		AppId:    int64(appId),
+		OrgID:    int64(orgID),
+		MFA:      mfa,
+		AuthTime: authTime,
+	}, nil
+}
+
+// UnverifiedAppId returns app_id claim without checking signature,
+// so the secret to validate token with can be looked up.
+// Never trust other claims of token before ParseClaims.
+func UnverifiedAppId(token string) (int64, error) {
+	parsedToken, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
 	if err != nil {
 		return 0, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat)
 	}
 
-	if claims, ok := parsedToken.Claims.(jwt.MapClaims); ok && parsedToken.Valid {
-		if int64(claims["exp"].(float64)) <= time.Now().Unix() {
-			return 0, cerrors.NewInvalidTokenError(cerrors.TokenExpired)
-		}
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat)
+	}
 
-		return int64(claims["id"].(float64)), nil
+	appId, ok := claims["app_id"].(float64)
+	if !ok {
+		return 0, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat)
 	}
 
-	return 0, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat)
+	return int64(appId), nil
 }
 
 func parseToken(token string, secret string) (*jwt.Token, error) {
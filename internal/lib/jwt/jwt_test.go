@@ -0,0 +1,103 @@
+package jwt_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var usr = &entities.User{UID: 7, Email: "ann@example.com"}
+
+func expiresIn(t *testing.T, token string) time.Duration {
+	t.Helper()
+
+	claims := gojwt.MapClaims{}
+	_, _, err := gojwt.NewParser().ParseUnverified(token, claims)
+	require.NoError(t, err)
+
+	exp, err := claims.GetExpirationTime()
+	require.NoError(t, err)
+
+	return time.Until(exp.Time).Round(time.Minute)
+}
+
+func TestNewTokenPair_TTL(t *testing.T) {
+	cases := []struct {
+		name        string
+		app         entities.App
+		wantAuth    time.Duration
+		wantRefresh time.Duration
+	}{
+		{
+			name:        "global",
+			app:         entities.App{ID: 1, AuthSecret: "a", RefreshSecret: "r"},
+			wantAuth:    time.Hour,
+			wantRefresh: 24 * time.Hour,
+		},
+		{
+			name: "overridden by app",
+			app: entities.App{
+				ID:              1,
+				AuthSecret:      "a",
+				RefreshSecret:   "r",
+				AuthTokenTTL:    5 * time.Minute,
+				RefreshTokenTTL: 30 * 24 * time.Hour,
+			},
+			wantAuth:    5 * time.Minute,
+			wantRefresh: 30 * 24 * time.Hour,
+		},
+		{
+			name: "refresh only overridden",
+			app: entities.App{
+				ID:              1,
+				AuthSecret:      "a",
+				RefreshSecret:   "r",
+				RefreshTokenTTL: 15 * time.Minute,
+			},
+			wantAuth:    time.Hour,
+			wantRefresh: 15 * time.Minute,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens, err := jwt.NewTokenPair(usr, &tc.app, time.Hour, 24*time.Hour)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantAuth, expiresIn(t, tokens.AuthToken))
+			assert.Equal(t, tc.wantRefresh, expiresIn(t, tokens.RefreshToken))
+		})
+	}
+}
+
+func TestNewTokenPair_Claims(t *testing.T) {
+	app := &entities.App{ID: 3, AuthSecret: "a", RefreshSecret: "r"}
+
+	tokens, err := jwt.NewTokenPair(usr, app, time.Hour, time.Hour, jwt.WithOrg(5), jwt.WithMFA())
+	require.NoError(t, err)
+
+	for token, secret := range map[string]string{tokens.AuthToken: "a", tokens.RefreshToken: "r"} {
+		claims, err := jwt.ParseClaims(token, secret)
+		require.NoError(t, err)
+		assert.Equal(t, &entities.TokenClaims{
+			UID:   7,
+			Email: "ann@example.com",
+			AppId: 3,
+			OrgID: 5,
+			MFA:   true,
+		}, claims)
+	}
+
+	tokens, err = jwt.NewTokenPair(usr, app, time.Hour, time.Hour)
+	require.NoError(t, err)
+
+	claims, err := jwt.ParseClaims(tokens.AuthToken, "a")
+	require.NoError(t, err)
+	assert.Zero(t, claims.OrgID)
+	assert.False(t, claims.MFA)
+}
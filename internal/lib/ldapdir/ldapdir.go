@@ -0,0 +1,134 @@
+package ldapdir
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var ErrInvalidCredentials = errors.New("invalid directory credentials")
+
+// Options describe directory and how users are found in it.
+// UserFilter gets escaped login as its only argument, so it may use
+// it several times as %[1]s. Empty BindDN searches anonymously.
+type Options struct {
+	URL            string
+	StartTLS       bool
+	BindDN         string
+	BindPassword   string
+	BaseDN         string
+	UserFilter     string
+	EmailAttribute string
+	NameAttribute  string
+	Timeout        time.Duration
+}
+
+// Entry is a user found in directory.
+type Entry struct {
+	DN    string
+	Email string
+	Name  string
+}
+
+// Directory checks passwords by binding as user.
+type Directory struct {
+	opts Options
+}
+
+func New(opts Options) *Directory {
+	return &Directory{opts: opts}
+}
+
+// Authenticate finds user by login and binds as user with password.
+// Returns ErrInvalidCredentials if user is not found, found more than
+// once or password is wrong.
+func (d *Directory) Authenticate(ctx context.Context, login string, password string) (*Entry, error) {
+	const op = "ldapdir.Authenticate"
+
+	// Bind with empty password is unauthenticated and always succeeds
+	if login == "" || password == "" {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer conn.Close()
+
+	if d.opts.BindDN != "" {
+		if err := conn.Bind(d.opts.BindDN, d.opts.BindPassword); err != nil {
+			return nil, fmt.Errorf("%s: service bind: %w", op, err)
+		}
+	}
+
+	res, err := conn.Search(ldap.NewSearchRequest(
+		d.opts.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, // one more than expected to detect ambiguous login
+		int(d.opts.Timeout.Seconds()),
+		false,
+		fmt.Sprintf(d.opts.UserFilter, ldap.EscapeFilter(login)),
+		[]string{d.opts.EmailAttribute, d.opts.NameAttribute},
+		nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("%s: search: %w", op, err)
+	}
+	if res == nil || len(res.Entries) != 1 {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+	found := res.Entries[0]
+
+	if err := conn.Bind(found.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		}
+		return nil, fmt.Errorf("%s: user bind: %w", op, err)
+	}
+
+	entry := &Entry{
+		DN:    found.DN,
+		Email: found.GetAttributeValue(d.opts.EmailAttribute),
+		Name:  found.GetAttributeValue(d.opts.NameAttribute),
+	}
+	if entry.Email == "" {
+		return nil, fmt.Errorf("%s: entry %s has no %s attribute", op, entry.DN, d.opts.EmailAttribute)
+	}
+
+	return entry, nil
+}
+
+func (d *Directory) dial(ctx context.Context) (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: d.opts.Timeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+
+	conn, err := ldap.DialURL(d.opts.URL, ldap.DialWithDialer(dialer))
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	conn.SetTimeout(d.opts.Timeout)
+
+	if d.opts.StartTLS {
+		u, err := url.Parse(d.opts.URL)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("parse url: %w", err)
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("start tls: %w", err)
+		}
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,187 @@
+package ldapdir_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/lib/ldapdir"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	serviceDN       = "cn=sso,dc=example,dc=com"
+	servicePassword = "service secret"
+)
+
+type person struct {
+	dn       string
+	uid      string
+	mail     string
+	cn       string
+	password string
+}
+
+var people = []person{
+	{dn: "uid=bob,ou=people,dc=example,dc=com", uid: "bob", mail: "Bob@Example.com", cn: "Bob Smith", password: "bob secret"},
+	{dn: "uid=ann,ou=people,dc=example,dc=com", uid: "ann", mail: "ann@example.com", cn: "Ann", password: "ann secret"},
+	{dn: "uid=ann2,ou=people,dc=example,dc=com", uid: "ann2", mail: "ann@example.com", cn: "Ann", password: "ann secret"},
+}
+
+// serveLDAP answers simple binds and searches matching uid or mail
+// of people, just enough for Directory.
+func serveLDAP(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { lis.Close() })
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go handleLDAP(conn)
+		}
+	}()
+
+	return "ldap://" + lis.Addr().String()
+}
+
+func handleLDAP(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := int64(ldap.LDAPResultInvalidCredentials)
+			if dn == serviceDN && password == servicePassword {
+				code = ldap.LDAPResultSuccess
+			}
+			for _, p := range people {
+				if dn == p.dn && password == p.password {
+					code = ldap.LDAPResultSuccess
+				}
+			}
+			conn.Write(message(id, result(ldap.ApplicationBindResponse, code)).Bytes())
+		case ldap.ApplicationSearchRequest:
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				return
+			}
+			for _, p := range people {
+				if strings.Contains(filter, "(uid="+p.uid+")") || strings.Contains(filter, "(mail="+p.mail+")") {
+					conn.Write(message(id, entry(p)).Bytes())
+				}
+			}
+			conn.Write(message(id, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+func message(id int64, op *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	packet.AppendChild(op)
+	return packet
+}
+
+func result(tag ber.Tag, code int64) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return op
+}
+
+func entry(p person) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, p.dn, ""))
+
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for name, value := range map[string]string{"mail": p.mail, "cn": p.cn} {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+		attr.AppendChild(values)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+
+	return op
+}
+
+func newDirectory(t *testing.T, bindPassword string) *ldapdir.Directory {
+	return ldapdir.New(ldapdir.Options{
+		URL:            serveLDAP(t),
+		BindDN:         serviceDN,
+		BindPassword:   bindPassword,
+		BaseDN:         "ou=people,dc=example,dc=com",
+		UserFilter:     "(&(objectClass=person)(|(uid=%[1]s)(mail=%[1]s)))",
+		EmailAttribute: "mail",
+		NameAttribute:  "cn",
+		Timeout:        time.Second,
+	})
+}
+
+func TestDirectory_Authenticate(t *testing.T) {
+	dir := newDirectory(t, servicePassword)
+
+	for _, login := range []string{"bob", "Bob@Example.com"} {
+		entry, err := dir.Authenticate(context.Background(), login, "bob secret")
+		require.NoError(t, err, login)
+		assert.Equal(t, &ldapdir.Entry{
+			DN:    "uid=bob,ou=people,dc=example,dc=com",
+			Email: "Bob@Example.com",
+			Name:  "Bob Smith",
+		}, entry)
+	}
+}
+
+func TestDirectory_InvalidCredentials(t *testing.T) {
+	dir := newDirectory(t, servicePassword)
+
+	cases := []struct {
+		name     string
+		login    string
+		password string
+	}{
+		{name: "wrong password", login: "bob", password: "ann secret"},
+		{name: "empty password", login: "bob", password: ""},
+		{name: "unknown user", login: "eve", password: "bob secret"},
+		{name: "filter injection", login: "bob)(uid=*", password: "bob secret"},
+		{name: "ambiguous login", login: "ann@example.com", password: "ann secret"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := dir.Authenticate(context.Background(), tc.login, tc.password)
+			assert.ErrorIs(t, err, ldapdir.ErrInvalidCredentials)
+		})
+	}
+}
+
+func TestDirectory_ServiceBindFails(t *testing.T) {
+	dir := newDirectory(t, "wrong")
+
+	_, err := dir.Authenticate(context.Background(), "bob", "bob secret")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ldapdir.ErrInvalidCredentials)
+}
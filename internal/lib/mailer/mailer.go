@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DriverLog  = "log"
+	DriverSMTP = "smtp"
+)
+
+var ErrUnknownDriver = errors.New("unknown mailer driver")
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Options struct {
+	Driver   string
+	From     string
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// Mailer delivers plain text messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns mailer of driver set in opts.
+func New(log *slog.Logger, opts Options) (Mailer, error) {
+	const op = "mailer.New"
+
+	switch opts.Driver {
+	case DriverLog:
+		return NewLog(log), nil
+	case DriverSMTP:
+		return NewSMTP(opts.Host, opts.Port, opts.Username, opts.Password, opts.From), nil
+	default:
+		return nil, fmt.Errorf("%s: %w: %q", op, ErrUnknownDriver, opts.Driver)
+	}
+}
+
+// Log writes messages to log instead of sending them.
+// Meant for local development, message bodies hold secrets.
+type Log struct {
+	log *slog.Logger
+}
+
+func NewLog(log *slog.Logger) *Log {
+	return &Log{log: log}
+}
+
+func (m *Log) Send(ctx context.Context, msg Message) error {
+	m.log.InfoContext(
+		ctx,
+		"mail",
+		slog.String("to", msg.To),
+		slog.String("subject", msg.Subject),
+		slog.String("body", msg.Body),
+	)
+
+	return nil
+}
+
+// SMTP sends messages through SMTP server with PLAIN auth
+// when username is set.
+type SMTP struct {
+	addr string
+	host string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTP(host string, port int, username string, password string, from string) *SMTP {
+	m := &SMTP{
+		addr: net.JoinHostPort(host, strconv.Itoa(port)),
+		host: host,
+		from: from,
+	}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m
+}
+
+// Send delivers msg. net/smtp doesn't take context, so ctx
+// is only checked before sending.
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	const op = "mailer.SMTP.Send"
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, m.build(msg)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (m *SMTP) build(msg Message) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return []byte(b.String())
+}
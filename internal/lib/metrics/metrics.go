@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/lib/apierror"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "sso"
+
+// ResultOK is a "result" label value of successful operations.
+// Failed operations are labeled with lowercased API error code.
+const ResultOK = "ok"
+
+var (
+	AuthOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "operations_total",
+		Help:      "Number of auth operations by operation, app and result.",
+	}, []string{"operation", "app_id", "result"})
+
+	AuthOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of auth operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	PasswordHashDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "password_hash_duration_seconds",
+		Help:      "Duration of password hashing and comparison.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+
+	PasswordHashQueueDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "password_hash_queue_duration_seconds",
+		Help:      "Time spent waiting for a free password hashing slot by result.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"result"})
+
+	PasswordHashQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "password_hash_queue_length",
+		Help:      "Number of password hash computations waiting for a free slot.",
+	})
+
+	StorageQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of storage queries.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+	}, []string{"query"})
+
+	GRPCRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of handled unary gRPC requests by method and code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of handled HTTP requests by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// ObserveDuration records time passed since start.
+// It is designed to be deferred:
+//
+//	defer metrics.ObserveDuration(metrics.StorageQueryDuration.WithLabelValues("q"), time.Now())
+func ObserveDuration(o prometheus.Observer, start time.Time) {
+	o.Observe(time.Since(start).Seconds())
+}
+
+// ObserveAuth records outcome and duration of auth operation.
+// err points to named error result of the operation, so it is read after return.
+// appID must be of an app found in storage, 0 until then, app ids sent
+// by clients would make number of series unbounded:
+//
+//	var appID int64
+//	defer func(start time.Time) {
+//		metrics.ObserveAuth("login", appID, start, &err)
+//	}(time.Now())
+func ObserveAuth(operation string, appID int64, start time.Time, err *error) {
+	app := ""
+	if appID != 0 {
+		app = strconv.FormatInt(appID, 10)
+	}
+
+	AuthOperations.WithLabelValues(operation, app, Result(*err)).Inc()
+	ObserveDuration(AuthOperationDuration.WithLabelValues(operation), start)
+}
+
+// Result maps error to "result" label value.
+func Result(err error) string {
+	if err == nil {
+		return ResultOK
+	}
+
+	return strings.ToLower(apierror.From(err).Code)
+}
@@ -0,0 +1,131 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/Woland-prj/microtasks_sso/migrations"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+const DefaultTable = "migrations"
+
+var (
+	// ErrNoChange is returned when there are no migrations to apply.
+	ErrNoChange = migrate.ErrNoChange
+	// ErrNilVersion is returned when no migration has been applied yet.
+	ErrNilVersion = migrate.ErrNilVersion
+)
+
+type Migrator struct {
+	m   *migrate.Migrate
+	src source.Driver
+}
+
+// Status describes a single migration from the source.
+type Status struct {
+	Version    uint
+	Identifier string
+	Applied    bool
+}
+
+// New creates migrator for sqlite database located at storagePath.
+// If migrationsPath is empty, migrations embedded into binary are used.
+func New(storagePath, migrationsPath, migrationsTable string) (*Migrator, error) {
+	const op = "migrator.New"
+
+	var (
+		src source.Driver
+		err error
+	)
+	if migrationsPath == "" {
+		src, err = iofs.New(migrations.FS, ".")
+	} else {
+		src, err = source.Open("file://" + migrationsPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m, err := migrate.NewWithSourceInstance(
+		"migrations",
+		src,
+		fmt.Sprintf("sqlite3://%s?x-migrations-table=%s", storagePath, migrationsTable),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Migrator{m: m, src: src}, nil
+}
+
+// Up applies all pending migrations.
+// Returns ErrNoChange if database is up to date.
+func (m *Migrator) Up() error {
+	return m.m.Up()
+}
+
+// Down rolls back given number of applied migrations.
+func (m *Migrator) Down(steps int) error {
+	return m.m.Steps(-steps)
+}
+
+// Version returns currently applied migration version and dirty flag.
+// Returns ErrNilVersion if no migration has been applied.
+func (m *Migrator) Version() (uint, bool, error) {
+	return m.m.Version()
+}
+
+// Force sets migration version without running migrations and clears dirty flag.
+func (m *Migrator) Force(version int) error {
+	return m.m.Force(version)
+}
+
+// Status lists all migrations from the source and marks applied ones.
+func (m *Migrator) Status() ([]Status, error) {
+	const op = "migrator.Status"
+
+	current, _, err := m.m.Version()
+	if err != nil && !errors.Is(err, ErrNilVersion) {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	applied := err == nil
+
+	var res []Status
+
+	version, err := m.src.First()
+	for err == nil {
+		r, identifier, rErr := m.src.ReadUp(version)
+		if rErr != nil {
+			return nil, fmt.Errorf("%s: %w", op, rErr)
+		}
+		r.Close()
+
+		res = append(res, Status{
+			Version:    version,
+			Identifier: identifier,
+			Applied:    applied && version <= current,
+		})
+
+		version, err = m.src.Next(version)
+	}
+	if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return res, nil
+}
+
+// Close releases source and database connections.
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.m.Close()
+
+	return errors.Join(srcErr, dbErr)
+}
@@ -0,0 +1,45 @@
+package onetime
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+
+	"github.com/Woland-prj/microtasks_sso/internal/lib/random"
+)
+
+// tokenLen is a number of random bytes in tokens.
+const tokenLen = 32
+
+// New returns random single-use token to send to user
+// and its hash to store instead of token itself,
+// so leaked storage doesn't reveal usable tokens.
+func New() (token string, hash string, err error) {
+	token, err = random.URLSafe(tokenLen)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, Hash(token), nil
+}
+
+// Hash returns hex encoded SHA-256 of token.
+// Tokens have enough entropy, so no salt or slow hash is needed.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Link returns base URL with token added as "token" query parameter.
+func Link(base string, token string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base + "?token=" + url.QueryEscape(token)
+	}
+
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
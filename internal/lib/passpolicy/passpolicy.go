@@ -0,0 +1,184 @@
+package passpolicy
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+)
+
+// Field is a name of field reported in policy violations.
+const Field = "password"
+
+// Policy describes rules new passwords must satisfy.
+// Zero value of a rule disables it.
+//
+// MaxBytes is a limit of password hash algorithm, passwords exceeding
+// it are rejected instead of being silently truncated.
+type Policy struct {
+	MinLength     int
+	MaxLength     int
+	MaxBytes      int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	RejectEmail   bool
+}
+
+// Check returns ValidationError with a violation per broken rule
+// or nil if password satisfies policy.
+// Length is counted in characters, not bytes.
+func (p Policy) Check(password string, email string) error {
+	var violations []string
+
+	length := utf8.RuneCountInString(password)
+	if p.MinLength > 0 && length < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d characters long", p.MaxLength))
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes long, non-latin characters take several bytes", p.MaxBytes))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			symbol = true
+		}
+	}
+
+	if p.RequireUpper && !upper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if p.RejectEmail && email != "" && matchesEmail(password, email) {
+		violations = append(violations, "must not match email")
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	fvs := make([]cerrors.FieldViolation, 0, len(violations))
+	for _, v := range violations {
+		fvs = append(fvs, cerrors.FieldViolation{Field: Field, Description: v})
+	}
+
+	return cerrors.NewValidationError("Weak password", fvs...)
+}
+
+// generatedLen is a length of generated passwords unless policy
+// requires other one.
+const generatedLen = 24
+
+// Character classes of generated passwords, all of them are ASCII,
+// so length in bytes equals length in characters.
+const (
+	upperChars  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	lowerChars  = "abcdefghijklmnopqrstuvwxyz"
+	digitChars  = "0123456789"
+	symbolChars = "-_.!@#%^*+="
+)
+
+// Generate returns random password satisfying policy, with a character
+// of each required class. Policy without email rule is enough for it,
+// random password can't match email.
+func (p Policy) Generate() (string, error) {
+	length := max(generatedLen, p.MinLength)
+	if p.MaxLength > 0 {
+		length = min(length, p.MaxLength)
+	}
+	if p.MaxBytes > 0 {
+		length = min(length, p.MaxBytes)
+	}
+
+	var required []string
+	for _, class := range []struct {
+		required bool
+		chars    string
+	}{
+		{p.RequireUpper, upperChars},
+		{p.RequireLower, lowerChars},
+		{p.RequireDigit, digitChars},
+		{p.RequireSymbol, symbolChars},
+	} {
+		if class.required {
+			required = append(required, class.chars)
+		}
+	}
+
+	if length < p.MinLength || length < len(required) {
+		return "", errors.New("passpolicy: password can't satisfy length limits of policy")
+	}
+
+	password := make([]byte, 0, length)
+	for _, chars := range required {
+		c, err := randomChar(chars)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	for len(password) < length {
+		c, err := randomChar(upperChars + lowerChars + digitChars + symbolChars)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+
+	// Required characters are first, shuffle them into random positions
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+
+	return string(password), nil
+}
+
+func randomChar(chars string) (byte, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+	if err != nil {
+		return 0, err
+	}
+	return chars[i.Int64()], nil
+}
+
+// matchesEmail reports whether password is email or its local part,
+// ignoring case.
+func matchesEmail(password string, email string) bool {
+	if strings.EqualFold(password, email) {
+		return true
+	}
+
+	local, _, found := strings.Cut(email, "@")
+
+	return found && strings.EqualFold(password, local)
+}
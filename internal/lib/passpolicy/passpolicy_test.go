@@ -0,0 +1,153 @@
+package passpolicy_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/passpolicy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	policy := passpolicy.Policy{
+		MinLength:    8,
+		MaxLength:    64,
+		MaxBytes:     72,
+		RequireUpper: true,
+		RequireLower: true,
+		RequireDigit: true,
+		RejectEmail:  true,
+	}
+
+	cases := []struct {
+		name     string
+		password string
+		email    string
+		want     []string
+	}{
+		{
+			name:     "ok",
+			password: "Correct1Horse",
+			email:    "bob@example.com",
+		},
+		{
+			name:     "too short",
+			password: "Ab1",
+			want:     []string{"must be at least 8 characters long"},
+		},
+		{
+			name:     "too long",
+			password: "Ab1" + strings.Repeat("x", 62),
+			want: []string{
+				"must be at most 64 characters long",
+			},
+		},
+		{
+			name:     "exceeds bcrypt limit in bytes",
+			password: "Ab1" + strings.Repeat("ж", 35),
+			want: []string{
+				"must be at most 72 bytes long, non-latin characters take several bytes",
+			},
+		},
+		{
+			name:     "missing classes",
+			password: "lowercaseonly",
+			want: []string{
+				"must contain an uppercase letter",
+				"must contain a digit",
+			},
+		},
+		{
+			name:     "equals email",
+			password: "Bob1@Example.com",
+			email:    "bob1@example.com",
+			want:     []string{"must not match email"},
+		},
+		{
+			name:     "equals email local part",
+			password: "Bobby1234X",
+			email:    "bobby1234x@example.com",
+			want:     []string{"must not match email"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := policy.Check(tc.password, tc.email)
+			if tc.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			var vErr cerrors.ValidationError
+			require.True(t, errors.As(err, &vErr))
+
+			got := make([]string, 0, len(vErr.Violations))
+			for _, v := range vErr.Violations {
+				assert.Equal(t, passpolicy.Field, v.Field)
+				got = append(got, v.Description)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestPolicy_Generate(t *testing.T) {
+	cases := []struct {
+		name    string
+		policy  passpolicy.Policy
+		wantLen int
+	}{
+		{
+			name:    "no rules",
+			policy:  passpolicy.Policy{},
+			wantLen: 24,
+		},
+		{
+			name: "every class required",
+			policy: passpolicy.Policy{
+				MinLength:     8,
+				MaxLength:     64,
+				MaxBytes:      72,
+				RequireUpper:  true,
+				RequireLower:  true,
+				RequireDigit:  true,
+				RequireSymbol: true,
+			},
+			wantLen: 24,
+		},
+		{
+			name:    "long minimum",
+			policy:  passpolicy.Policy{MinLength: 40, MaxBytes: 72, RequireSymbol: true},
+			wantLen: 40,
+		},
+		{
+			name:    "short maximum",
+			policy:  passpolicy.Policy{MinLength: 4, MaxLength: 6, RequireDigit: true, RequireUpper: true},
+			wantLen: 6,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Classes are placed randomly, so check many passwords
+			for range 200 {
+				password, err := tc.policy.Generate()
+				require.NoError(t, err)
+				assert.Len(t, password, tc.wantLen)
+				require.NoError(t, tc.policy.Check(password, "bob@example.com"), password)
+			}
+		})
+	}
+}
+
+func TestPolicy_GenerateImpossible(t *testing.T) {
+	_, err := passpolicy.Policy{MinLength: 80, MaxBytes: 72}.Generate()
+	assert.Error(t, err)
+
+	_, err = passpolicy.Policy{MaxLength: 2, RequireUpper: true, RequireLower: true, RequireDigit: true}.Generate()
+	assert.Error(t, err)
+}
@@ -0,0 +1,56 @@
+package periodic
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/sl"
+)
+
+// Start runs fn in background at once and then every interval.
+// Failed run is logged and retried on next tick.
+//
+// Returned func cancels context of running fn and waits until it returns
+// or ctx is done.
+func Start(
+	log *slog.Logger,
+	name string,
+	interval time.Duration,
+	fn func(ctx context.Context) error,
+) func(ctx context.Context) error {
+	log = log.With(slog.String("worker", name))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := fn(ctx); err != nil && ctx.Err() == nil {
+				log.Error("worker run failed", sl.Err(err))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func(stopCtx context.Context) error {
+		cancel()
+
+		select {
+		case <-done:
+			return nil
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+	}
+}
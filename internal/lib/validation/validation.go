@@ -0,0 +1,117 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/go-playground/validator/v10"
+)
+
+// usernameRegexp allows short handles which never look like an email,
+// so login identifier is unambiguous.
+var usernameRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9._-]{2,31}$`)
+
+// New returns validator reporting fields by their json names,
+// so violations match fields clients actually send.
+// Besides builtin tags it validates "username" and "duration",
+// a duration of at least a second in time.ParseDuration format,
+// durations are stored in whole seconds.
+func New() *validator.Validate {
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	// Registration fails only on empty tag or nil func
+	_ = validate.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return usernameRegexp.MatchString(fl.Field().String())
+	})
+
+	_ = validate.RegisterValidation("duration", func(fl validator.FieldLevel) bool {
+		d, err := time.ParseDuration(fl.Field().String())
+		return err == nil && d >= time.Second
+	})
+
+	validate.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return f.Name
+		}
+		return name
+	})
+
+	return validate
+}
+
+// Error converts error returned by validator to ValidationError
+// with a violation per invalid field.
+func Error(message string, err error) cerrors.ValidationError {
+	var vErrs validator.ValidationErrors
+	if !errors.As(err, &vErrs) {
+		return cerrors.NewValidationError(message)
+	}
+
+	violations := make([]cerrors.FieldViolation, 0, len(vErrs))
+	for _, fe := range vErrs {
+		violations = append(violations, cerrors.FieldViolation{
+			Field:       fe.Field(),
+			Description: describe(fe),
+		})
+	}
+
+	return cerrors.NewValidationError(message, violations...)
+}
+
+func describe(fe validator.FieldError) string {
+	// Alternatives like "eq=|http_url" carry params inside tag
+	if strings.Contains(fe.Tag(), "|") {
+		alts := strings.Split(fe.Tag(), "|")
+		descs := make([]string, 0, len(alts))
+		for _, alt := range alts {
+			tag, param, _ := strings.Cut(alt, "=")
+			descs = append(descs, describeTag(tag, param))
+		}
+		return strings.Join(descs, " or ")
+	}
+
+	return describeTag(fe.Tag(), fe.Param())
+}
+
+func describeTag(tag string, param string) string {
+	switch tag {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "jwt":
+		return "must be a valid JWT"
+	case "url", "http_url":
+		return "must be a valid URL"
+	case "username":
+		return "must be 3 to 32 letters, digits, dots, underscores or hyphens starting with a letter"
+	case "duration":
+		return "must be a duration of at least 1s like 15m or 720h"
+	case "timezone":
+		return "must be a valid IANA time zone"
+	case "bcp47_language_tag":
+		return "must be a valid BCP 47 language tag"
+	case "eq":
+		if param == "" {
+			return "must be empty"
+		}
+		return fmt.Sprintf("must be equal to %s", param)
+	case "min":
+		return fmt.Sprintf("must be at least %s characters long", param)
+	case "max":
+		return fmt.Sprintf("must be at most %s characters long", param)
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", param)
+	default:
+		return fmt.Sprintf("failed on %q rule", tag)
+	}
+}
@@ -0,0 +1,541 @@
+package accountservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/emailaddr"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/sl"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/mailer"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/onetime"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/tracing"
+)
+
+type UserProvider interface {
+	GetUserById(
+		ctx context.Context,
+		uid int64,
+	) (*entities.User, error)
+	GetUserByEmail(
+		ctx context.Context,
+		email string,
+	) (*entities.User, error)
+}
+
+type ProfileUpdater interface {
+	UpdateProfile(
+		ctx context.Context,
+		uid int64,
+		profile entities.Profile,
+	) error
+}
+
+type EmailChanger interface {
+	SaveEmailChange(
+		ctx context.Context,
+		change *entities.EmailChange,
+	) error
+	ConfirmEmailChange(
+		ctx context.Context,
+		tokenHash string,
+		now time.Time,
+	) (string, *entities.EmailChange, error)
+	GetEmailChanges(
+		ctx context.Context,
+		uid int64,
+	) ([]entities.EmailChange, error)
+}
+
+type AccountDeleter interface {
+	SoftDeleteUser(
+		ctx context.Context,
+		uid int64,
+		at time.Time,
+	) error
+	PurgeDeletedUsers(
+		ctx context.Context,
+		before time.Time,
+	) (int64, error)
+}
+
+type CredentialProvider interface {
+	GetWebAuthnCredentials(
+		ctx context.Context,
+		uid int64,
+	) ([]entities.WebAuthnCredential, error)
+}
+
+type FederatedIdentityProvider interface {
+	GetFederatedIdentities(
+		ctx context.Context,
+		uid int64,
+	) ([]entities.FederatedIdentity, error)
+}
+
+type MembershipProvider interface {
+	GetMemberships(
+		ctx context.Context,
+		uid int64,
+	) ([]entities.Membership, error)
+	GetMembers(
+		ctx context.Context,
+		orgID int64,
+	) ([]entities.Membership, error)
+}
+
+type AppGrantProvider interface {
+	GetUserAppGrants(
+		ctx context.Context,
+		uid int64,
+	) ([]entities.AppGrant, error)
+}
+
+// Reauthenticator confirms that user behind token is present,
+// by password or recent login.
+type Reauthenticator interface {
+	Reauthenticate(
+		ctx context.Context,
+		uid int64,
+		authTime time.Time,
+		password string,
+	) (*entities.User, error)
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg mailer.Message) error
+}
+
+type AccountService struct {
+	log                *slog.Logger
+	userProvider       UserProvider
+	profileUpdater     ProfileUpdater
+	emailChanger       EmailChanger
+	accountDeleter     AccountDeleter
+	credentialProvider CredentialProvider
+	identityProvider   FederatedIdentityProvider
+	membershipProvider MembershipProvider
+	grantProvider      AppGrantProvider
+	reauthenticator    Reauthenticator
+	mailer             Mailer
+	emailChangeTTL     time.Duration
+	emailConfirmURL    string
+	gracePeriod        time.Duration
+}
+
+// New returns new AccountService instance.
+// Confirmation links sent by email point to emailConfirmURL.
+// Deleted accounts are kept for gracePeriod before purge.
+func New(
+	log *slog.Logger,
+	userProvider UserProvider,
+	profileUpdater ProfileUpdater,
+	emailChanger EmailChanger,
+	accountDeleter AccountDeleter,
+	credentialProvider CredentialProvider,
+	identityProvider FederatedIdentityProvider,
+	membershipProvider MembershipProvider,
+	grantProvider AppGrantProvider,
+	reauthenticator Reauthenticator,
+	mailer Mailer,
+	emailChangeTTL time.Duration,
+	emailConfirmURL string,
+	gracePeriod time.Duration,
+) *AccountService {
+	return &AccountService{
+		log:                log,
+		userProvider:       userProvider,
+		profileUpdater:     profileUpdater,
+		emailChanger:       emailChanger,
+		accountDeleter:     accountDeleter,
+		credentialProvider: credentialProvider,
+		identityProvider:   identityProvider,
+		membershipProvider: membershipProvider,
+		grantProvider:      grantProvider,
+		reauthenticator:    reauthenticator,
+		mailer:             mailer,
+		emailChangeTTL:     emailChangeTTL,
+		emailConfirmURL:    emailConfirmURL,
+		gracePeriod:        gracePeriod,
+	}
+}
+
+// GetProfile returns user with given uid.
+func (a *AccountService) GetProfile(
+	ctx context.Context,
+	uid int64,
+) (_ *entities.User, err error) {
+	const op = "accountservice.GetProfile"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", uid))
+
+	usr, err := a.userProvider.GetUserById(ctx, uid)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "user not found", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return usr, nil
+}
+
+// UpdateProfile changes profile fields set in dto and returns updated user.
+func (a *AccountService) UpdateProfile(
+	ctx context.Context,
+	dto dtos.UpdateProfileDto,
+) (_ *entities.User, err error) {
+	const op = "accountservice.UpdateProfile"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", dto.UID))
+	log.DebugContext(ctx, "updating profile")
+
+	usr, err := a.GetProfile(ctx, dto.UID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, f := range []struct {
+		value *string
+		field *string
+	}{
+		{dto.DisplayName, &usr.DisplayName},
+		{dto.AvatarURL, &usr.AvatarURL},
+		{dto.Locale, &usr.Locale},
+		{dto.Timezone, &usr.Timezone},
+	} {
+		if f.value != nil {
+			*f.field = *f.value
+		}
+	}
+
+	if err := a.profileUpdater.UpdateProfile(ctx, dto.UID, usr.Profile); err != nil {
+		log.ErrorContext(ctx, "failed to update profile", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.DebugContext(ctx, "profile updated")
+
+	return usr, nil
+}
+
+// RequestEmailChange sends confirmation link to new email after
+// confirming user by current password or recent login, users without
+// password have no other way.
+// Email is changed only when link is confirmed with ConfirmEmailChange,
+// new request replaces previous pending one.
+func (a *AccountService) RequestEmailChange(
+	ctx context.Context,
+	dto dtos.ChangeEmailDto,
+) (err error) {
+	const op = "accountservice.RequestEmailChange"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", dto.UID))
+	log.DebugContext(ctx, "requesting email change")
+
+	usr, err := a.reauthenticator.Reauthenticate(ctx, dto.UID, dto.AuthTime, dto.Password)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	newEmail, err := emailaddr.Canonical(dto.NewEmail)
+	if err != nil {
+		return cerrors.NewValidationError("Bad format", cerrors.FieldViolation{
+			Field:       "new_email",
+			Description: "must be a valid email address",
+		})
+	}
+	dto.NewEmail = newEmail
+
+	oldKey, _ := emailaddr.Key(usr.Email)
+	if newKey, _ := emailaddr.Key(newEmail); oldKey == newKey {
+		return cerrors.NewValidationError("Bad format", cerrors.FieldViolation{
+			Field:       "new_email",
+			Description: "must differ from current email",
+		})
+	}
+
+	// Checked again on confirmation, this one saves a useless email
+	_, err = a.userProvider.GetUserByEmail(ctx, dto.NewEmail)
+	if err == nil {
+		log.WarnContext(ctx, "new email is taken")
+		return fmt.Errorf("%s: %w", op, cerrors.NewAlreadyExistsError(fmt.Sprintf("user %s", dto.NewEmail)))
+	}
+	var nfErr cerrors.NotFoundError
+	if !errors.As(err, &nfErr) {
+		log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, tokenHash, err := onetime.New()
+	if err != nil {
+		log.ErrorContext(ctx, "failed to generate token", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, cerrors.NewCriticalInternalError("onetime.New", err))
+	}
+
+	err = a.emailChanger.SaveEmailChange(ctx, &entities.EmailChange{
+		TokenHash: tokenHash,
+		UserID:    dto.UID,
+		NewEmail:  dto.NewEmail,
+		ExpiresAt: time.Now().Add(a.emailChangeTTL),
+	})
+	if err != nil {
+		log.ErrorContext(ctx, "failed to save email change", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = a.mailer.Send(ctx, mailer.Message{
+		To:      dto.NewEmail,
+		Subject: "Confirm your new email",
+		Body: fmt.Sprintf(
+			"Follow the link to confirm your new email:\n\n%s\n\nThe link expires in %s. "+
+				"If you didn't request the change, ignore this message.\n",
+			onetime.Link(a.emailConfirmURL, token),
+			a.emailChangeTTL,
+		),
+	})
+	if err != nil {
+		log.ErrorContext(ctx, "failed to send confirmation", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, cerrors.NewCriticalInternalError("mailer.Send", err))
+	}
+
+	log.DebugContext(ctx, "email change requested")
+
+	return nil
+}
+
+// ConfirmEmailChange applies pending email change by token sent
+// to new email and notifies previous email about it.
+func (a *AccountService) ConfirmEmailChange(
+	ctx context.Context,
+	token string,
+) (err error) {
+	const op = "accountservice.ConfirmEmailChange"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op))
+
+	oldEmail, change, err := a.emailChanger.ConfirmEmailChange(ctx, onetime.Hash(token), time.Now())
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "email change not found or expired", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat))
+		}
+		var aeErr cerrors.AlreadyExistsError
+		if errors.As(err, &aeErr) {
+			log.WarnContext(ctx, "new email was taken", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to confirm email change", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", change.UserID))
+	log.DebugContext(ctx, "email changed")
+
+	// Email is already changed, failed notice must not fail request
+	err = a.mailer.Send(ctx, mailer.Message{
+		To:      oldEmail,
+		Subject: "Your email was changed",
+		Body: fmt.Sprintf(
+			"Email of your account was changed to %s.\n\n"+
+				"If you didn't do it, contact support immediately.\n",
+			change.NewEmail,
+		),
+	})
+	if err != nil {
+		log.ErrorContext(ctx, "failed to notify previous email", sl.Err(err))
+	}
+
+	return nil
+}
+
+// DeleteAccount soft deletes account after confirming user by password
+// or recent login, and returns time after which its data is purged.
+// Deleted user can neither login nor refresh tokens.
+// The last owner of organization with other members can't delete
+// account until adding another owner, like they can't leave it.
+func (a *AccountService) DeleteAccount(
+	ctx context.Context,
+	dto dtos.DeleteAccountDto,
+) (_ time.Time, err error) {
+	const op = "accountservice.DeleteAccount"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", dto.UID))
+	log.DebugContext(ctx, "deleting account")
+
+	usr, err := a.reauthenticator.Reauthenticate(ctx, dto.UID, dto.AuthTime, dto.Password)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkNotLastOwner(ctx, log, dto.UID); err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+	if err := a.accountDeleter.SoftDeleteUser(ctx, dto.UID, now); err != nil {
+		log.ErrorContext(ctx, "failed to delete user", sl.Err(err))
+		return time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	purgeAt := now.Add(a.gracePeriod)
+
+	log.InfoContext(ctx, "account deleted", slog.Time("purge_at", purgeAt))
+
+	err = a.mailer.Send(ctx, mailer.Message{
+		To:      usr.Email,
+		Subject: "Your account was deleted",
+		Body: fmt.Sprintf(
+			"Your account was deleted. All its data will be erased after %s.\n\n"+
+				"If you didn't do it, contact support before then.\n",
+			purgeAt.UTC().Format(time.RFC1123),
+		),
+	})
+	if err != nil {
+		log.ErrorContext(ctx, "failed to notify about deletion", sl.Err(err))
+	}
+
+	return purgeAt, nil
+}
+
+// checkNotLastOwner returns PermissionDeniedError if user is the only
+// owner of organization having other members. Organization user is
+// the only member of is left empty, nobody is locked out of it.
+func (a *AccountService) checkNotLastOwner(ctx context.Context, log *slog.Logger, uid int64) error {
+	memberships, err := a.membershipProvider.GetMemberships(ctx, uid)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get memberships", sl.Err(err))
+		return err
+	}
+
+	for _, m := range memberships {
+		if m.Role != entities.RoleOwner {
+			continue
+		}
+
+		members, err := a.membershipProvider.GetMembers(ctx, m.OrgID)
+		if err != nil {
+			log.ErrorContext(ctx, "failed to get members", sl.Err(err))
+			return err
+		}
+
+		owners := 0
+		for _, member := range members {
+			if member.Role == entities.RoleOwner {
+				owners++
+			}
+		}
+		if owners == 1 && len(members) > 1 {
+			log.WarnContext(ctx, "last owner can't delete account", slog.Int64("org_id", m.OrgID))
+			return cerrors.NewPermissionDeniedError(fmt.Sprintf(
+				"organization %s must keep an owner, add another owner first",
+				m.OrgName,
+			))
+		}
+	}
+
+	return nil
+}
+
+// ExportData returns all data stored about user.
+func (a *AccountService) ExportData(
+	ctx context.Context,
+	uid int64,
+) (_ *entities.AccountData, err error) {
+	const op = "accountservice.ExportData"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", uid))
+
+	usr, err := a.GetProfile(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	changes, err := a.emailChanger.GetEmailChanges(ctx, uid)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get email changes", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	creds, err := a.credentialProvider.GetWebAuthnCredentials(ctx, uid)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get passkeys", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	identities, err := a.identityProvider.GetFederatedIdentities(ctx, uid)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get federated identities", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	memberships, err := a.membershipProvider.GetMemberships(ctx, uid)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get memberships", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	grants, err := a.grantProvider.GetUserAppGrants(ctx, uid)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get app grants", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &entities.AccountData{
+		User:                *usr,
+		EmailChanges:        changes,
+		WebAuthnCredentials: creds,
+		FederatedIdentities: identities,
+		Memberships:         memberships,
+		AppGrants:           grants,
+	}, nil
+}
+
+// PurgeDeleted erases accounts deleted longer than grace period ago.
+func (a *AccountService) PurgeDeleted(ctx context.Context) (err error) {
+	const op = "accountservice.PurgeDeleted"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op))
+
+	purged, err := a.accountDeleter.PurgeDeletedUsers(ctx, time.Now().Add(-a.gracePeriod))
+	if err != nil {
+		log.ErrorContext(ctx, "failed to purge deleted users", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if purged > 0 {
+		log.InfoContext(ctx, "deleted users purged", slog.Int64("count", purged))
+	}
+
+	return nil
+}
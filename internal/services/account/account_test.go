@@ -0,0 +1,333 @@
+package accountservice_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/mailer"
+	accountservice "github.com/Woland-prj/microtasks_sso/internal/services/account"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	password    = "Correct1Horse"
+	gracePeriod = 30 * 24 * time.Hour
+)
+
+// fakeStorage keeps users, email changes, memberships
+// and deletions in memory.
+type fakeStorage struct {
+	users       map[int64]*entities.User
+	changes     []entities.EmailChange
+	memberships []entities.Membership
+	deleted     map[int64]time.Time
+}
+
+func (f *fakeStorage) GetUserById(_ context.Context, uid int64) (*entities.User, error) {
+	if usr, ok := f.users[uid]; ok {
+		if _, deleted := f.deleted[uid]; !deleted {
+			u := *usr
+			return &u, nil
+		}
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("user %d", uid))
+}
+
+func (f *fakeStorage) GetUserByEmail(_ context.Context, email string) (*entities.User, error) {
+	for uid, usr := range f.users {
+		if usr.Email == email {
+			return f.GetUserById(context.Background(), uid)
+		}
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("user %s", email))
+}
+
+func (f *fakeStorage) UpdateProfile(context.Context, int64, entities.Profile) error {
+	return nil
+}
+
+func (f *fakeStorage) SaveEmailChange(_ context.Context, change *entities.EmailChange) error {
+	f.changes = append(f.changes, *change)
+	return nil
+}
+
+func (f *fakeStorage) ConfirmEmailChange(context.Context, string, time.Time) (string, *entities.EmailChange, error) {
+	return "", nil, cerrors.NewNotFoundError("email change")
+}
+
+func (f *fakeStorage) GetEmailChanges(context.Context, int64) ([]entities.EmailChange, error) {
+	return f.changes, nil
+}
+
+func (f *fakeStorage) SoftDeleteUser(_ context.Context, uid int64, at time.Time) error {
+	f.deleted[uid] = at
+	return nil
+}
+
+func (f *fakeStorage) PurgeDeletedUsers(_ context.Context, before time.Time) (int64, error) {
+	var purged int64
+	for uid, at := range f.deleted {
+		if !at.After(before) {
+			delete(f.users, uid)
+			delete(f.deleted, uid)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (f *fakeStorage) GetWebAuthnCredentials(context.Context, int64) ([]entities.WebAuthnCredential, error) {
+	return nil, nil
+}
+
+func (f *fakeStorage) GetFederatedIdentities(context.Context, int64) ([]entities.FederatedIdentity, error) {
+	return nil, nil
+}
+
+func (f *fakeStorage) GetMemberships(_ context.Context, uid int64) ([]entities.Membership, error) {
+	var memberships []entities.Membership
+	for _, m := range f.memberships {
+		if m.UserID == uid {
+			memberships = append(memberships, m)
+		}
+	}
+	return memberships, nil
+}
+
+// GetMembers skips deleted users like sqlite storage does.
+func (f *fakeStorage) GetMembers(_ context.Context, orgID int64) ([]entities.Membership, error) {
+	var members []entities.Membership
+	for _, m := range f.memberships {
+		if _, deleted := f.deleted[m.UserID]; m.OrgID == orgID && !deleted {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+func (f *fakeStorage) GetUserAppGrants(context.Context, int64) ([]entities.AppGrant, error) {
+	return nil, nil
+}
+
+// Reauthenticate accepts password of users having one or login within
+// 5 minutes, like authservice.Reauthenticate does.
+func (f *fakeStorage) Reauthenticate(ctx context.Context, uid int64, authTime time.Time, pass string) (*entities.User, error) {
+	usr, err := f.GetUserById(ctx, uid)
+	if err != nil {
+		return nil, cerrors.NewInvalidCredentialsError()
+	}
+	if pass != "" {
+		if usr.PassHash == "" || pass != password {
+			return nil, cerrors.NewInvalidCredentialsError()
+		}
+		return usr, nil
+	}
+	if time.Since(authTime) > 5*time.Minute {
+		return nil, cerrors.NewValidationError("Reauthentication required", cerrors.FieldViolation{Field: "password"})
+	}
+	return usr, nil
+}
+
+type fakeMailer struct {
+	sent []mailer.Message
+}
+
+func (f *fakeMailer) Send(_ context.Context, msg mailer.Message) error {
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+// newService returns service with user 1 ann having password
+// and user 2 bob provisioned by identity provider without one.
+func newService() (*accountservice.AccountService, *fakeStorage, *fakeMailer) {
+	storage := &fakeStorage{
+		users: map[int64]*entities.User{
+			1: {UID: 1, Email: "ann@example.com", PassHash: "hash"},
+			2: {UID: 2, Email: "bob@example.com"},
+		},
+		deleted: make(map[int64]time.Time),
+	}
+	mail := &fakeMailer{}
+
+	service := accountservice.New(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		storage,
+		storage,
+		storage,
+		storage,
+		storage,
+		storage,
+		storage,
+		storage,
+		storage,
+		mail,
+		24*time.Hour,
+		"http://localhost:3000/email/confirm",
+		gracePeriod,
+	)
+
+	return service, storage, mail
+}
+
+func TestDeleteAccount_Reauth(t *testing.T) {
+	cases := []struct {
+		name string
+		dto  dtos.DeleteAccountDto
+		// err points to expected error type, nil if none
+		err any
+	}{
+		{
+			name: "password",
+			dto:  dtos.DeleteAccountDto{UID: 1, Password: password},
+		},
+		{
+			name: "wrong password",
+			dto:  dtos.DeleteAccountDto{UID: 1, Password: "wrong", AuthTime: time.Now()},
+			err:  &cerrors.InvalidCredentialsError{},
+		},
+		{
+			name: "no password, recent login",
+			dto:  dtos.DeleteAccountDto{UID: 2, AuthTime: time.Now().Add(-time.Minute)},
+		},
+		{
+			name: "no password, old login",
+			dto:  dtos.DeleteAccountDto{UID: 2, AuthTime: time.Now().Add(-time.Hour)},
+			err:  &cerrors.ValidationError{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			service, storage, mail := newService()
+
+			purgeAt, err := service.DeleteAccount(context.Background(), tc.dto)
+			if tc.err != nil {
+				assert.ErrorAs(t, err, tc.err)
+				assert.Empty(t, storage.deleted)
+				assert.Empty(t, mail.sent)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Contains(t, storage.deleted, tc.dto.UID)
+			assert.Equal(t, storage.deleted[tc.dto.UID].Add(gracePeriod), purgeAt)
+			require.Len(t, mail.sent, 1)
+			assert.Equal(t, storage.users[tc.dto.UID].Email, mail.sent[0].To)
+		})
+	}
+}
+
+func TestRequestEmailChange_Reauth(t *testing.T) {
+	service, storage, mail := newService()
+	ctx := context.Background()
+
+	var vErr cerrors.ValidationError
+	err := service.RequestEmailChange(ctx, dtos.ChangeEmailDto{
+		UID:      2,
+		AuthTime: time.Now().Add(-time.Hour),
+		NewEmail: "robert@example.com",
+	})
+	require.ErrorAs(t, err, &vErr)
+	assert.Empty(t, storage.changes)
+
+	// User without password confirms by logging in again
+	err = service.RequestEmailChange(ctx, dtos.ChangeEmailDto{
+		UID:      2,
+		AuthTime: time.Now(),
+		NewEmail: "robert@example.com",
+	})
+	require.NoError(t, err)
+	require.Len(t, storage.changes, 1)
+	assert.Equal(t, "robert@example.com", storage.changes[0].NewEmail)
+	require.Len(t, mail.sent, 1)
+	assert.Equal(t, "robert@example.com", mail.sent[0].To)
+}
+
+func TestDeleteAccount_LastOwner(t *testing.T) {
+	member := func(orgID int64, uid int64, role string) entities.Membership {
+		return entities.Membership{OrgID: orgID, OrgName: fmt.Sprintf("org %d", orgID), UserID: uid, Role: role}
+	}
+
+	cases := []struct {
+		name        string
+		memberships []entities.Membership
+		denied      bool
+	}{
+		{
+			name:        "only member",
+			memberships: []entities.Membership{member(1, 1, entities.RoleOwner)},
+		},
+		{
+			name: "another owner",
+			memberships: []entities.Membership{
+				member(1, 1, entities.RoleOwner),
+				member(1, 2, entities.RoleOwner),
+			},
+		},
+		{
+			name: "member of organization",
+			memberships: []entities.Membership{
+				member(1, 2, entities.RoleOwner),
+				member(1, 1, entities.RoleMember),
+			},
+		},
+		{
+			name: "last owner",
+			memberships: []entities.Membership{
+				member(1, 1, entities.RoleOwner),
+				member(1, 2, entities.RoleMember),
+			},
+			denied: true,
+		},
+		{
+			name: "last owner of one of organizations",
+			memberships: []entities.Membership{
+				member(1, 1, entities.RoleOwner),
+				member(1, 2, entities.RoleOwner),
+				member(2, 1, entities.RoleOwner),
+				member(2, 2, entities.RoleMember),
+			},
+			denied: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			service, storage, _ := newService()
+			storage.memberships = tc.memberships
+
+			_, err := service.DeleteAccount(context.Background(), dtos.DeleteAccountDto{UID: 1, Password: password})
+			if !tc.denied {
+				require.NoError(t, err)
+				assert.Contains(t, storage.deleted, int64(1))
+				return
+			}
+
+			var pdErr cerrors.PermissionDeniedError
+			assert.ErrorAs(t, err, &pdErr)
+			assert.Empty(t, storage.deleted)
+		})
+	}
+}
+
+func TestPurgeDeleted_GracePeriod(t *testing.T) {
+	service, storage, _ := newService()
+	ctx := context.Background()
+
+	storage.deleted[1] = time.Now().Add(-gracePeriod - time.Minute)
+	storage.deleted[2] = time.Now().Add(-gracePeriod + time.Minute)
+
+	require.NoError(t, service.PurgeDeleted(ctx))
+
+	assert.NotContains(t, storage.users, int64(1))
+	assert.Contains(t, storage.users, int64(2))
+	assert.Contains(t, storage.deleted, int64(2))
+}
@@ -0,0 +1,500 @@
+package appservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/emailaddr"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/sl"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/random"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/tracing"
+)
+
+// secretLen is a number of random bytes in generated app secrets.
+const secretLen = 32
+
+type AppSaver interface {
+	SaveApp(
+		ctx context.Context,
+		app *entities.App,
+	) (int64, error)
+	SetAppRestricted(
+		ctx context.Context,
+		appID int64,
+		restricted bool,
+	) error
+	UpdateAppPolicy(
+		ctx context.Context,
+		app *entities.App,
+	) error
+}
+
+type AppProvider interface {
+	GetApp(
+		ctx context.Context,
+		id int64,
+	) (*entities.App, error)
+	GetAppByName(
+		ctx context.Context,
+		name string,
+	) (*entities.App, error)
+}
+
+type UserProvider interface {
+	GetUserById(
+		ctx context.Context,
+		uid int64,
+	) (*entities.User, error)
+	GetUserByEmail(
+		ctx context.Context,
+		email string,
+	) (*entities.User, error)
+}
+
+type OrganizationProvider interface {
+	GetOrganization(
+		ctx context.Context,
+		id int64,
+	) (*entities.Organization, error)
+}
+
+type GrantStorage interface {
+	SaveAppGrant(
+		ctx context.Context,
+		grant *entities.AppGrant,
+	) (int64, error)
+	GetAppGrants(
+		ctx context.Context,
+		appID int64,
+	) ([]entities.AppGrant, error)
+	DeleteAppGrant(
+		ctx context.Context,
+		appID int64,
+		id int64,
+	) error
+}
+
+type AppService struct {
+	log          *slog.Logger
+	appSaver     AppSaver
+	appProvider  AppProvider
+	userProvider UserProvider
+	orgProvider  OrganizationProvider
+	grantStorage GrantStorage
+}
+
+// New returns new AppService instance
+func New(
+	log *slog.Logger,
+	appSaver AppSaver,
+	appProvider AppProvider,
+	userProvider UserProvider,
+	orgProvider OrganizationProvider,
+	grantStorage GrantStorage,
+) *AppService {
+	return &AppService{
+		log:          log,
+		appSaver:     appSaver,
+		appProvider:  appProvider,
+		userProvider: userProvider,
+		orgProvider:  orgProvider,
+		grantStorage: grantStorage,
+	}
+}
+
+// EnsureApp returns app with given name, creating it if it doesn't exist.
+//
+// Empty secrets of a new app are generated.
+// Secrets of an existing app are left untouched.
+// created reports whether app was created by this call.
+func (a *AppService) EnsureApp(
+	ctx context.Context,
+	dto dtos.CreateAppDto,
+) (app *entities.App, created bool, err error) {
+	const op = "appservice.EnsureApp"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.String("app", dto.Name))
+
+	app, err = a.appProvider.GetAppByName(ctx, dto.Name)
+	if err == nil {
+		log.DebugContext(ctx, "app already exists")
+		return app, false, nil
+	}
+
+	var nfErr cerrors.NotFoundError
+	if !errors.As(err, &nfErr) {
+		log.ErrorContext(ctx, "failed to get app from storage", sl.Err(err))
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := checkPolicy(dto.AuthTokenTTL, dto.RefreshTokenTTL, dto.GrantTypes); err != nil {
+		log.WarnContext(ctx, "invalid app policy", sl.Err(err))
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app = &entities.App{
+		Name:          dto.Name,
+		AuthSecret:    dto.AuthSecret,
+		RefreshSecret: dto.RefreshSecret,
+		OrgID:         dto.OrgID,
+		Restricted:    dto.Restricted,
+		// Policy is set on creation only, like secrets
+		AuthTokenTTL:    dto.AuthTokenTTL,
+		RefreshTokenTTL: dto.RefreshTokenTTL,
+		RequireMFA:      dto.RequireMFA,
+		GrantTypes:      grantTypes(dto.GrantTypes),
+	}
+
+	for _, secret := range []*string{&app.AuthSecret, &app.RefreshSecret} {
+		if *secret != "" {
+			continue
+		}
+		if *secret, err = random.Hex(secretLen); err != nil {
+			log.ErrorContext(ctx, "failed to generate secret", sl.Err(err))
+			return nil, false, fmt.Errorf(
+				"%s: %w",
+				op,
+				cerrors.NewCriticalInternalError("random.Hex", err),
+			)
+		}
+	}
+
+	app.ID, err = a.appSaver.SaveApp(ctx, app)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to save app", sl.Err(err))
+		return nil, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.DebugContext(ctx, "app created", slog.Int64("id", app.ID))
+
+	return app, true, nil
+}
+
+// SetRestricted turns restriction of app to granted users on or off.
+// Only admins manage access to apps.
+func (a *AppService) SetRestricted(
+	ctx context.Context,
+	dto dtos.SetAppRestrictedDto,
+) (err error) {
+	const op = "appservice.SetRestricted"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", dto.UID), slog.Int64("app_id", dto.AppID))
+
+	if err := a.requireAdmin(ctx, log, dto.UID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.appSaver.SetAppRestricted(ctx, dto.AppID, dto.Restricted); err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "app not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to update app", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "app restriction changed", slog.Bool("restricted", dto.Restricted))
+
+	return nil
+}
+
+// SetPolicy replaces token TTLs, MFA requirement and allowed grant
+// types of app. Tokens already issued keep their TTLs.
+func (a *AppService) SetPolicy(
+	ctx context.Context,
+	dto dtos.SetAppPolicyDto,
+) (_ *entities.App, err error) {
+	const op = "appservice.SetPolicy"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", dto.UID), slog.Int64("app_id", dto.AppID))
+
+	if err := a.requireAdmin(ctx, log, dto.UID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := checkPolicy(dto.AuthTokenTTL, dto.RefreshTokenTTL, dto.GrantTypes); err != nil {
+		log.WarnContext(ctx, "invalid app policy", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := a.getApp(ctx, log, dto.AppID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app.AuthTokenTTL = dto.AuthTokenTTL
+	app.RefreshTokenTTL = dto.RefreshTokenTTL
+	app.RequireMFA = dto.RequireMFA
+	app.GrantTypes = grantTypes(dto.GrantTypes)
+
+	if err := a.appSaver.UpdateAppPolicy(ctx, app); err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "app not found", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to update app", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(
+		ctx,
+		"app policy changed",
+		slog.Duration("auth_token_ttl", app.AuthTokenTTL),
+		slog.Duration("refresh_token_ttl", app.RefreshTokenTTL),
+		slog.Bool("require_mfa", app.RequireMFA),
+		slog.Any("grant_types", dto.GrantTypes),
+	)
+
+	return app, nil
+}
+
+// knownGrantTypes are grant types app policy may allow.
+var knownGrantTypes = map[entities.GrantType]bool{
+	entities.GrantPassword:   true,
+	entities.GrantRefresh:    true,
+	entities.GrantMagicLink:  true,
+	entities.GrantWebAuthn:   true,
+	entities.GrantFederation: true,
+}
+
+// checkPolicy rejects negative TTLs, TTLs which aren't whole seconds
+// as they are stored in seconds, and unknown grant types. Zero TTLs
+// fall back to global ones.
+func checkPolicy(authTTL time.Duration, refreshTTL time.Duration, types []string) error {
+	var fvs []cerrors.FieldViolation
+
+	for _, ttl := range []struct {
+		field string
+		value time.Duration
+	}{
+		{"auth_token_ttl", authTTL},
+		{"refresh_token_ttl", refreshTTL},
+	} {
+		if ttl.value < 0 || ttl.value%time.Second != 0 {
+			fvs = append(fvs, cerrors.FieldViolation{
+				Field:       ttl.field,
+				Description: "must be a whole number of seconds, 0 for global TTL",
+			})
+		}
+	}
+
+	for i, t := range types {
+		if !knownGrantTypes[entities.GrantType(t)] {
+			fvs = append(fvs, cerrors.FieldViolation{
+				Field:       fmt.Sprintf("grant_types[%d]", i),
+				Description: "must be one of password refresh magic_link webauthn federation",
+			})
+		}
+	}
+
+	if len(fvs) > 0 {
+		return cerrors.NewValidationError("Bad format", fvs...)
+	}
+	return nil
+}
+
+func grantTypes(types []string) []entities.GrantType {
+	var res []entities.GrantType
+	for _, t := range types {
+		res = append(res, entities.GrantType(t))
+	}
+	return res
+}
+
+// Grants returns grants of access to app.
+func (a *AppService) Grants(
+	ctx context.Context,
+	uid int64,
+	appID int64,
+) (_ []entities.AppGrant, err error) {
+	const op = "appservice.Grants"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", uid), slog.Int64("app_id", appID))
+
+	if err := a.requireAdmin(ctx, log, uid); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := a.getApp(ctx, log, appID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	grants, err := a.grantStorage.GetAppGrants(ctx, appID)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get grants", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return grants, nil
+}
+
+// GrantAccess grants access to app either to user with given email
+// or to every member of organization.
+func (a *AppService) GrantAccess(
+	ctx context.Context,
+	dto dtos.GrantAppAccessDto,
+) (_ *entities.AppGrant, err error) {
+	const op = "appservice.GrantAccess"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", dto.UID), slog.Int64("app_id", dto.AppID))
+
+	if err := a.requireAdmin(ctx, log, dto.UID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := a.getApp(ctx, log, dto.AppID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	grant := &entities.AppGrant{
+		AppID:     dto.AppID,
+		CreatedAt: time.Now(),
+	}
+
+	if dto.OrgID != 0 {
+		org, err := a.orgProvider.GetOrganization(ctx, dto.OrgID)
+		if err != nil {
+			var nfErr cerrors.NotFoundError
+			if errors.As(err, &nfErr) {
+				log.WarnContext(ctx, "organization not found", sl.Err(err))
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+			log.ErrorContext(ctx, "failed to get organization from storage", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		grant.OrgID = org.ID
+	} else {
+		email, err := emailaddr.Canonical(dto.Email)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, cerrors.NewNotFoundError(fmt.Sprintf("user %s", dto.Email)))
+		}
+
+		usr, err := a.userProvider.GetUserByEmail(ctx, email)
+		if err != nil {
+			var nfErr cerrors.NotFoundError
+			if errors.As(err, &nfErr) {
+				log.WarnContext(ctx, "user not found", sl.Err(err))
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+			log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		grant.UserID = int64(usr.UID)
+	}
+
+	grant.ID, err = a.grantStorage.SaveAppGrant(ctx, grant)
+	if err != nil {
+		var aeErr cerrors.AlreadyExistsError
+		if errors.As(err, &aeErr) {
+			log.WarnContext(ctx, "access already granted", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to save grant", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(
+		ctx,
+		"access granted",
+		slog.Int64("grant_id", grant.ID),
+		slog.Int64("user_id", grant.UserID),
+		slog.Int64("org_id", grant.OrgID),
+	)
+
+	return grant, nil
+}
+
+// RevokeAccess revokes grant of access to app. Tokens already issued
+// stay valid until expiration, but can't be refreshed.
+func (a *AppService) RevokeAccess(
+	ctx context.Context,
+	dto dtos.RevokeAppAccessDto,
+) (err error) {
+	const op = "appservice.RevokeAccess"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", dto.UID),
+		slog.Int64("app_id", dto.AppID),
+		slog.Int64("grant_id", dto.GrantID),
+	)
+
+	if err := a.requireAdmin(ctx, log, dto.UID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.grantStorage.DeleteAppGrant(ctx, dto.AppID, dto.GrantID); err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "grant not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to delete grant", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "access revoked")
+
+	return nil
+}
+
+func (a *AppService) requireAdmin(ctx context.Context, log *slog.Logger, uid int64) error {
+	usr, err := a.userProvider.GetUserById(ctx, uid)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "user not found", sl.Err(err))
+			return cerrors.NewPermissionDeniedError("admin rights required")
+		}
+		log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		return err
+	}
+
+	if !usr.IsAdmin {
+		log.WarnContext(ctx, "user is not admin")
+		return cerrors.NewPermissionDeniedError("admin rights required")
+	}
+
+	return nil
+}
+
+func (a *AppService) getApp(ctx context.Context, log *slog.Logger, id int64) (*entities.App, error) {
+	app, err := a.appProvider.GetApp(ctx, id)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "app not found", sl.Err(err))
+			return nil, err
+		}
+		log.ErrorContext(ctx, "failed to get app from storage", sl.Err(err))
+		return nil, err
+	}
+
+	return app, nil
+}
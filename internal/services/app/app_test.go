@@ -0,0 +1,283 @@
+package appservice_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	appservice "github.com/Woland-prj/microtasks_sso/internal/services/app"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStorage keeps users, organizations, apps and grants in memory.
+type fakeStorage struct {
+	users  map[int64]*entities.User
+	orgs   map[int64]*entities.Organization
+	apps   map[int64]*entities.App
+	grants map[int64]entities.AppGrant
+}
+
+func (f *fakeStorage) SaveApp(_ context.Context, app *entities.App) (int64, error) {
+	id := int64(len(f.apps) + 1)
+	f.apps[id] = app
+	return id, nil
+}
+
+func (f *fakeStorage) SetAppRestricted(_ context.Context, appID int64, restricted bool) error {
+	app, ok := f.apps[appID]
+	if !ok {
+		return cerrors.NewNotFoundError(fmt.Sprintf("app %d", appID))
+	}
+	app.Restricted = restricted
+	return nil
+}
+
+func (f *fakeStorage) UpdateAppPolicy(_ context.Context, app *entities.App) error {
+	if _, ok := f.apps[app.ID]; !ok {
+		return cerrors.NewNotFoundError(fmt.Sprintf("app %d", app.ID))
+	}
+	f.apps[app.ID] = app
+	return nil
+}
+
+func (f *fakeStorage) GetApp(_ context.Context, id int64) (*entities.App, error) {
+	if app, ok := f.apps[id]; ok {
+		a := *app
+		return &a, nil
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("app %d", id))
+}
+
+func (f *fakeStorage) GetAppByName(_ context.Context, name string) (*entities.App, error) {
+	for _, app := range f.apps {
+		if app.Name == name {
+			a := *app
+			return &a, nil
+		}
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("app %s", name))
+}
+
+func (f *fakeStorage) GetUserById(_ context.Context, uid int64) (*entities.User, error) {
+	if usr, ok := f.users[uid]; ok {
+		return usr, nil
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("user %d", uid))
+}
+
+func (f *fakeStorage) GetUserByEmail(_ context.Context, email string) (*entities.User, error) {
+	for _, usr := range f.users {
+		if usr.Email == email {
+			return usr, nil
+		}
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("user %s", email))
+}
+
+func (f *fakeStorage) GetOrganization(_ context.Context, id int64) (*entities.Organization, error) {
+	if org, ok := f.orgs[id]; ok {
+		return org, nil
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("organization %d", id))
+}
+
+func (f *fakeStorage) SaveAppGrant(_ context.Context, grant *entities.AppGrant) (int64, error) {
+	id := int64(len(f.grants) + 1)
+	f.grants[id] = *grant
+	return id, nil
+}
+
+func (f *fakeStorage) GetAppGrants(_ context.Context, appID int64) ([]entities.AppGrant, error) {
+	var grants []entities.AppGrant
+	for id, g := range f.grants {
+		if g.AppID == appID {
+			g.ID = id
+			grants = append(grants, g)
+		}
+	}
+	return grants, nil
+}
+
+func (f *fakeStorage) DeleteAppGrant(_ context.Context, appID int64, id int64) error {
+	if g, ok := f.grants[id]; !ok || g.AppID != appID {
+		return cerrors.NewNotFoundError(fmt.Sprintf("grant %d", id))
+	}
+	delete(f.grants, id)
+	return nil
+}
+
+// newService returns service with admin 1 ann, user 2 bob,
+// organization 1 and app 1.
+func newService() (*appservice.AppService, *fakeStorage) {
+	storage := &fakeStorage{
+		users: map[int64]*entities.User{
+			1: {UID: 1, Email: "ann@example.com", IsAdmin: true},
+			2: {UID: 2, Email: "bob@example.com"},
+		},
+		orgs: map[int64]*entities.Organization{
+			1: {ID: 1, Name: "acme"},
+		},
+		apps: map[int64]*entities.App{
+			1: {ID: 1, Name: "app"},
+		},
+		grants: make(map[int64]entities.AppGrant),
+	}
+
+	service := appservice.New(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		storage,
+		storage,
+		storage,
+		storage,
+		storage,
+	)
+
+	return service, storage
+}
+
+func TestAppAccess_AdminOnly(t *testing.T) {
+	ctx := context.Background()
+
+	// Bob isn't admin, 3 doesn't exist
+	for _, uid := range []int64{2, 3} {
+		t.Run(fmt.Sprintf("uid %d", uid), func(t *testing.T) {
+			service, storage := newService()
+			storage.grants[1] = entities.AppGrant{AppID: 1, UserID: 2}
+
+			var pdErr cerrors.PermissionDeniedError
+
+			err := service.SetRestricted(ctx, dtos.SetAppRestrictedDto{UID: uid, AppID: 1, Restricted: true})
+			assert.ErrorAs(t, err, &pdErr)
+
+			_, err = service.Grants(ctx, uid, 1)
+			assert.ErrorAs(t, err, &pdErr)
+
+			_, err = service.GrantAccess(ctx, dtos.GrantAppAccessDto{UID: uid, AppID: 1, Email: "bob@example.com"})
+			assert.ErrorAs(t, err, &pdErr)
+
+			err = service.RevokeAccess(ctx, dtos.RevokeAppAccessDto{UID: uid, AppID: 1, GrantID: 1})
+			assert.ErrorAs(t, err, &pdErr)
+
+			_, err = service.SetPolicy(ctx, dtos.SetAppPolicyDto{UID: uid, AppID: 1, RequireMFA: true})
+			assert.ErrorAs(t, err, &pdErr)
+
+			assert.False(t, storage.apps[1].Restricted)
+			assert.False(t, storage.apps[1].RequireMFA)
+			assert.Len(t, storage.grants, 1)
+		})
+	}
+}
+
+func TestAppAccess_Admin(t *testing.T) {
+	service, storage := newService()
+	ctx := context.Background()
+
+	err := service.SetRestricted(ctx, dtos.SetAppRestrictedDto{UID: 1, AppID: 1, Restricted: true})
+	require.NoError(t, err)
+	assert.True(t, storage.apps[1].Restricted)
+
+	userGrant, err := service.GrantAccess(ctx, dtos.GrantAppAccessDto{UID: 1, AppID: 1, Email: "bob@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), userGrant.UserID)
+
+	orgGrant, err := service.GrantAccess(ctx, dtos.GrantAppAccessDto{UID: 1, AppID: 1, OrgID: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), orgGrant.OrgID)
+
+	var nfErr cerrors.NotFoundError
+	_, err = service.GrantAccess(ctx, dtos.GrantAppAccessDto{UID: 1, AppID: 1, OrgID: 2})
+	assert.ErrorAs(t, err, &nfErr)
+	_, err = service.GrantAccess(ctx, dtos.GrantAppAccessDto{UID: 1, AppID: 2, Email: "bob@example.com"})
+	assert.ErrorAs(t, err, &nfErr)
+
+	err = service.RevokeAccess(ctx, dtos.RevokeAppAccessDto{UID: 1, AppID: 1, GrantID: userGrant.ID})
+	require.NoError(t, err)
+
+	grants, err := service.Grants(ctx, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, grants, 1)
+	assert.Equal(t, orgGrant.ID, grants[0].ID)
+}
+
+func TestSetPolicy(t *testing.T) {
+	service, storage := newService()
+	ctx := context.Background()
+
+	app, err := service.SetPolicy(ctx, dtos.SetAppPolicyDto{
+		UID:          1,
+		AppID:        1,
+		AuthTokenTTL: 5 * time.Minute,
+		RequireMFA:   true,
+		GrantTypes:   []string{"webauthn", "refresh"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, app, storage.apps[1])
+	assert.Equal(t, 5*time.Minute, app.AuthTokenTTL)
+	assert.Zero(t, app.RefreshTokenTTL)
+	assert.True(t, app.RequireMFA)
+	assert.Equal(t, []entities.GrantType{entities.GrantWebAuthn, entities.GrantRefresh}, app.GrantTypes)
+
+	var nfErr cerrors.NotFoundError
+	_, err = service.SetPolicy(ctx, dtos.SetAppPolicyDto{UID: 1, AppID: 2})
+	assert.ErrorAs(t, err, &nfErr)
+}
+
+func TestSetPolicy_Invalid(t *testing.T) {
+	cases := []struct {
+		name  string
+		dto   dtos.SetAppPolicyDto
+		field string
+	}{
+		{
+			name:  "negative TTL",
+			dto:   dtos.SetAppPolicyDto{AuthTokenTTL: -time.Minute},
+			field: "auth_token_ttl",
+		},
+		{
+			name:  "sub-second TTL",
+			dto:   dtos.SetAppPolicyDto{RefreshTokenTTL: 500 * time.Millisecond},
+			field: "refresh_token_ttl",
+		},
+		{
+			name:  "fractional seconds TTL",
+			dto:   dtos.SetAppPolicyDto{AuthTokenTTL: 1500 * time.Millisecond},
+			field: "auth_token_ttl",
+		},
+		{
+			name:  "unknown grant type",
+			dto:   dtos.SetAppPolicyDto{GrantTypes: []string{"password", "implicit"}},
+			field: "grant_types[1]",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			service, storage := newService()
+			tc.dto.UID = 1
+			tc.dto.AppID = 1
+
+			_, err := service.SetPolicy(context.Background(), tc.dto)
+			var vErr cerrors.ValidationError
+			require.ErrorAs(t, err, &vErr)
+			require.Len(t, vErr.Violations, 1)
+			assert.Equal(t, tc.field, vErr.Violations[0].Field)
+			assert.Equal(t, &entities.App{ID: 1, Name: "app"}, storage.apps[1])
+
+			_, _, err = service.EnsureApp(context.Background(), dtos.CreateAppDto{
+				Name:            "other",
+				AuthTokenTTL:    tc.dto.AuthTokenTTL,
+				RefreshTokenTTL: tc.dto.RefreshTokenTTL,
+				GrantTypes:      tc.dto.GrantTypes,
+			})
+			assert.ErrorAs(t, err, &vErr)
+			assert.Len(t, storage.apps, 1)
+		})
+	}
+}
@@ -5,14 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
 	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
 	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/emailaddr"
 	"github.com/Woland-prj/microtasks_sso/internal/lib/jwt"
 	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/sl"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/metrics"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/tracing"
 )
 
 type UserSaver interface {
@@ -20,6 +24,11 @@ type UserSaver interface {
 		ctx context.Context,
 		user *entities.User,
 	) (int64, error)
+	UpdatePassHash(
+		ctx context.Context,
+		uid int64,
+		passHash string,
+	) error
 }
 
 type UserProvider interface {
@@ -27,6 +36,10 @@ type UserProvider interface {
 		ctx context.Context,
 		email string,
 	) (*entities.User, error)
+	GetUserByUsername(
+		ctx context.Context,
+		username string,
+	) (*entities.User, error)
 	GetUserById(
 		ctx context.Context,
 		uid int64,
@@ -40,32 +53,128 @@ type AppProvider interface {
 	) (*entities.App, error)
 }
 
+type MembershipProvider interface {
+	GetMembership(
+		ctx context.Context,
+		orgID int64,
+		uid int64,
+	) (*entities.Membership, error)
+	GetMemberships(
+		ctx context.Context,
+		uid int64,
+	) ([]entities.Membership, error)
+}
+
+type AccessChecker interface {
+	HasAppAccess(
+		ctx context.Context,
+		appID int64,
+		uid int64,
+	) (bool, error)
+}
+
+type PasswordPolicy interface {
+	Check(password string, email string) error
+	Generate() (string, error)
+}
+
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash string, password string) (bool, error)
+	NeedsRehash(hash string) bool
+}
+
+type HashLimiter interface {
+	Acquire(ctx context.Context) error
+	Release()
+}
+
+type BreachChecker interface {
+	IsBreached(ctx context.Context, password string) (bool, error)
+}
+
+// Authenticator checks login and password and returns user they belong to.
+// Returns InvalidCredentialsError if it doesn't accept them.
+type Authenticator interface {
+	Authenticate(
+		ctx context.Context,
+		login string,
+		password string,
+	) (*entities.User, error)
+}
+
 type AuthService struct {
 	log             *slog.Logger
 	userSaver       UserSaver
 	userProvider    UserProvider
 	appProvider     AppProvider
+	memberships     MembershipProvider
+	accessChecker   AccessChecker
+	passwordPolicy  PasswordPolicy
+	passwordHasher  PasswordHasher
+	hashLimiter     HashLimiter
+	breachChecker   BreachChecker
+	authenticators  []Authenticator
+	dummyHash       func() (string, error)
 	authTokenTTL    time.Duration
 	refreshTokenTTL time.Duration
+	reauthMaxAge    time.Duration
+	tokenOptions    []jwt.Option
 }
 
-// New returns new AuthService instance
+// New returns new AuthService instance.
+// profileClaims enables profile claims in auth tokens.
+// Logins not older than reauthMaxAge confirm sensitive operations
+// without password, see Reauthenticate.
+// Nil breachChecker disables check of breached passwords.
+// Login checks passwords of local users first and then asks
+// authenticators in given order.
 func New(
 	log *slog.Logger,
 	authTokenTTL time.Duration,
 	refreshTokenTTL time.Duration,
+	reauthMaxAge time.Duration,
+	profileClaims bool,
 	userSaver UserSaver,
 	userProvider UserProvider,
 	appProvider AppProvider,
+	memberships MembershipProvider,
+	accessChecker AccessChecker,
+	passwordPolicy PasswordPolicy,
+	passwordHasher PasswordHasher,
+	hashLimiter HashLimiter,
+	breachChecker BreachChecker,
+	authenticators []Authenticator,
 ) *AuthService {
-	return &AuthService{
+	var tokenOptions []jwt.Option
+	if profileClaims {
+		tokenOptions = append(tokenOptions, jwt.WithProfile())
+	}
+
+	a := &AuthService{
 		log:             log,
 		userSaver:       userSaver,
 		userProvider:    userProvider,
 		appProvider:     appProvider,
+		memberships:     memberships,
+		accessChecker:   accessChecker,
+		passwordPolicy:  passwordPolicy,
+		passwordHasher:  passwordHasher,
+		hashLimiter:     hashLimiter,
+		breachChecker:   breachChecker,
 		authTokenTTL:    authTokenTTL,
 		refreshTokenTTL: refreshTokenTTL,
+		reauthMaxAge:    reauthMaxAge,
+		tokenOptions:    tokenOptions,
+		// Made on first use with current hasher parameters, so comparing
+		// with it costs the same as comparing with a real hash
+		dummyHash: sync.OnceValues(func() (string, error) {
+			return passwordHasher.Hash("dummy password")
+		}),
 	}
+	a.authenticators = append([]Authenticator{localAuthenticator{a}}, authenticators...)
+
+	return a
 }
 
 // Register checks if user exists and if not exists, registers new user.
@@ -75,176 +184,920 @@ func New(
 func (a *AuthService) Register(
 	ctx context.Context,
 	dto dtos.RegisterDto,
-) (int64, error) {
+) (_ int64, err error) {
 	const op = "authservice.Register"
 
-	a.log.With(slog.String("op", op))
-	a.log.Debug("registering new user")
+	defer metrics.ObserveAuth("register", 0, time.Now(), &err)
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op))
+	log.DebugContext(ctx, "registering new user")
+
+	uid, err := a.saveUser(ctx, dto, false)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.DebugContext(ctx, "user registerd", slog.String("uid", fmt.Sprintf("%v", uid)))
+
+	return uid, nil
+}
+
+// EnsureUser registers user if user with given email doesn't exist.
+//
+// Password of an existing user is left untouched.
+// created reports whether user was registered by this call.
+func (a *AuthService) EnsureUser(
+	ctx context.Context,
+	dto dtos.RegisterDto,
+	isAdmin bool,
+) (uid int64, created bool, err error) {
+	const op = "authservice.EnsureUser"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op))
 
-	passHash, err := bcrypt.GenerateFromPassword([]byte(dto.Password), bcrypt.DefaultCost)
+	usr, err := a.userProvider.GetUserByEmail(ctx, dto.Email)
+	if err == nil {
+		log.DebugContext(ctx, "user already exists", slog.Uint64("uid", usr.UID))
+		return int64(usr.UID), false, nil
+	}
+
+	var nfErr cerrors.NotFoundError
+	if !errors.As(err, &nfErr) {
+		log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	uid, err = a.saveUser(ctx, dto, isAdmin)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.DebugContext(ctx, "user registerd", slog.Int64("uid", uid))
+
+	return uid, true, nil
+}
+
+// GeneratePassword returns random password satisfying password policy.
+func (a *AuthService) GeneratePassword() (string, error) {
+	const op = "authservice.GeneratePassword"
+
+	password, err := a.passwordPolicy.Generate()
 	if err != nil {
-		a.log.Error("failed to generate password hash", sl.Err(err))
-		return 0, fmt.Errorf(
-			"%s: %w",
-			op,
-			cerrors.NewCriticalInternalError("bcrypt.GenerateFromPassword", err),
-		)
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return password, nil
+}
+
+// saveUser canonicalizes email, checks password, hashes it
+// and saves new user to storage.
+func (a *AuthService) saveUser(
+	ctx context.Context,
+	dto dtos.RegisterDto,
+	isAdmin bool,
+) (int64, error) {
+	email, err := emailaddr.Canonical(dto.Email)
+	if err != nil {
+		return 0, cerrors.NewValidationError("Bad format", cerrors.FieldViolation{
+			Field:       "email",
+			Description: "must be a valid email address",
+		})
+	}
+	dto.Email = email
+	// Usernames are case-insensitive like emails
+	dto.Username = strings.ToLower(dto.Username)
+
+	if err := a.checkPassword(ctx, dto.Password, dto.Email); err != nil {
+		return 0, err
+	}
+
+	passHash, err := a.hashPassword(ctx, dto.Password)
+	if err != nil {
+		return 0, err
 	}
 
 	usr := &entities.User{
 		Email:    dto.Email,
-		PassHash: string(passHash),
+		Username: dto.Username,
+		PassHash: passHash,
+		IsAdmin:  isAdmin,
+		Profile: entities.Profile{
+			DisplayName: dto.DisplayName,
+			AvatarURL:   dto.AvatarURL,
+			Locale:      dto.Locale,
+			Timezone:    dto.Timezone,
+		},
 	}
 
 	uid, err := a.userSaver.SaveUser(ctx, usr)
 	if err != nil {
-		if errors.Is(err, &cerrors.AlreadyExistsError{}) {
-			a.log.Warn("user exists", sl.Err(err))
+		var aeErr cerrors.AlreadyExistsError
+		if errors.As(err, &aeErr) {
+			a.log.WarnContext(ctx, "user exists", sl.Err(err))
 			return 0, err
 		}
-		a.log.Error("failed to save user", sl.Err(err))
-		return 0, fmt.Errorf("%s, %w", op, err)
+		a.log.ErrorContext(ctx, "failed to save user", sl.Err(err))
+		return 0, err
 	}
 
-	a.log.Debug("user registerd", slog.String("uid", fmt.Sprintf("%v", uid)))
-
 	return uid, nil
 }
 
-// Login checks if user exists and if exists, returns pair of JWT tokens.
-// If user doesn't exist, returns error.
-func (a *AuthService) Login(
+// getUserByLogin returns user by email or username.
+// Usernames can't contain "@", so login is unambiguous.
+func (a *AuthService) getUserByLogin(ctx context.Context, login string) (*entities.User, error) {
+	if strings.Contains(login, "@") {
+		return a.userProvider.GetUserByEmail(ctx, login)
+	}
+
+	return a.userProvider.GetUserByUsername(ctx, strings.ToLower(login))
+}
+
+// checkPassword returns ValidationError if new password violates policy
+// or is known to be breached.
+func (a *AuthService) checkPassword(ctx context.Context, password string, email string) error {
+	if err := a.passwordPolicy.Check(password, email); err != nil {
+		a.log.WarnContext(ctx, "password rejected by policy", sl.Err(err))
+		return err
+	}
+
+	if a.breachChecker == nil {
+		return nil
+	}
+
+	ctx, span := tracing.Start(ctx, "breach.IsBreached")
+	breached, err := a.breachChecker.IsBreached(ctx, password)
+	span.End()
+	if err != nil {
+		a.log.ErrorContext(ctx, "failed to check password for breach", sl.Err(err))
+		return cerrors.NewCriticalInternalError("breach.IsBreached", err)
+	}
+	if breached {
+		a.log.WarnContext(ctx, "password found in breach corpus")
+		return cerrors.NewValidationError("Weak password", cerrors.FieldViolation{
+			Field:       "password",
+			Description: "has appeared in a data breach, choose another one",
+		})
+	}
+
+	return nil
+}
+
+// hashPassword returns hash of password made by preferred algorithm.
+func (a *AuthService) hashPassword(ctx context.Context, password string) (string, error) {
+	if err := a.acquireHashSlot(ctx); err != nil {
+		return "", err
+	}
+	defer a.hashLimiter.Release()
+
+	_, span := tracing.Start(ctx, "hasher.Hash")
+	t1 := time.Now()
+	passHash, err := a.passwordHasher.Hash(password)
+	metrics.ObserveDuration(metrics.PasswordHashDuration.WithLabelValues("generate"), t1)
+	span.End()
+	if err != nil {
+		a.log.ErrorContext(ctx, "failed to generate password hash", sl.Err(err))
+		return "", cerrors.NewCriticalInternalError("hasher.Hash", err)
+	}
+
+	return passHash, nil
+}
+
+// comparePassword returns InvalidCredentialsError
+// if password doesn't match hash.
+func (a *AuthService) comparePassword(ctx context.Context, passHash string, password string) error {
+	// Users provisioned by identity provider have no password
+	if passHash == "" {
+		a.log.WarnContext(ctx, "user has no password")
+		return a.compareDummyPassword(ctx, password)
+	}
+
+	if err := a.acquireHashSlot(ctx); err != nil {
+		return err
+	}
+	defer a.hashLimiter.Release()
+
+	_, span := tracing.Start(ctx, "hasher.Verify")
+	t1 := time.Now()
+	ok, err := a.passwordHasher.Verify(passHash, password)
+	metrics.ObserveDuration(metrics.PasswordHashDuration.WithLabelValues("compare"), t1)
+	span.End()
+	if err != nil {
+		a.log.ErrorContext(ctx, "failed to compare password", sl.Err(err))
+		return cerrors.NewCriticalInternalError("hasher.Verify", err)
+	}
+	if !ok {
+		a.log.WarnContext(ctx, "password mismatch")
+		return cerrors.NewInvalidCredentialsError()
+	}
+
+	return nil
+}
+
+// compareDummyPassword spends the same time as comparePassword,
+// so response time doesn't reveal whether user exists.
+// Result is always InvalidCredentialsError unless hashing failed.
+func (a *AuthService) compareDummyPassword(ctx context.Context, password string) error {
+	dummyHash, err := a.dummyHash()
+	if err != nil {
+		a.log.ErrorContext(ctx, "failed to generate dummy hash", sl.Err(err))
+		return cerrors.NewCriticalInternalError("hasher.Hash", err)
+	}
+
+	if err := a.comparePassword(ctx, dummyHash, password); err != nil {
+		var icErr cerrors.InvalidCredentialsError
+		if !errors.As(err, &icErr) {
+			return err
+		}
+	}
+
+	return cerrors.NewInvalidCredentialsError()
+}
+
+// acquireHashSlot waits for hash limiter. Client gone or queue
+// overflowed, request is rejected as unavailable.
+func (a *AuthService) acquireHashSlot(ctx context.Context) error {
+	if err := a.hashLimiter.Acquire(ctx); err != nil {
+		a.log.WarnContext(ctx, "password hashing slot not acquired", sl.Err(err))
+		return cerrors.NewUnavailableError(err.Error())
+	}
+
+	return nil
+}
+
+// rehashPassword replaces stored hash made with outdated algorithm
+// or parameters. Password is already verified, so failures are only
+// logged and don't affect caller.
+func (a *AuthService) rehashPassword(ctx context.Context, usr *entities.User, password string) {
+	if !a.passwordHasher.NeedsRehash(usr.PassHash) {
+		return
+	}
+
+	passHash, err := a.hashPassword(ctx, password)
+	if err != nil {
+		return
+	}
+
+	if err := a.userSaver.UpdatePassHash(ctx, int64(usr.UID), passHash); err != nil {
+		a.log.ErrorContext(ctx, "failed to update password hash", sl.Err(err))
+		return
+	}
+
+	usr.PassHash = passHash
+	a.log.DebugContext(ctx, "password rehashed", slog.Uint64("uid", usr.UID))
+}
+
+// ChangePassword replaces password of user after checking current one.
+//
+// New password must satisfy password policy.
+func (a *AuthService) ChangePassword(
 	ctx context.Context,
-	dto dtos.LoginDto,
-) (*entities.JwtTokenPair, error) {
-	const op = "authservice.Login"
+	dto dtos.ChangePasswordDto,
+) (err error) {
+	const op = "authservice.ChangePassword"
 
-	a.log.With(slog.String("op", op))
-	a.log.Debug("login user")
+	defer metrics.ObserveAuth("change_password", 0, time.Now(), &err)
 
-	usr, err := a.userProvider.GetUserByEmail(ctx, dto.Email)
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", dto.UID))
+	log.DebugContext(ctx, "changing password")
+
+	usr, err := a.VerifyPassword(ctx, dto.UID, dto.OldPassword)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.setPassword(ctx, log, usr, dto.NewPassword); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.DebugContext(ctx, "password changed")
+
+	return nil
+}
+
+// SetPassword replaces password of user without checking current one,
+// caller must have verified user some other way, e.g. by reset link.
+//
+// New password must satisfy password policy.
+func (a *AuthService) SetPassword(
+	ctx context.Context,
+	uid int64,
+	password string,
+) (err error) {
+	const op = "authservice.SetPassword"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", uid))
+	log.DebugContext(ctx, "setting password")
+
+	usr, err := a.userProvider.GetUserById(ctx, uid)
 	if err != nil {
-		if errors.Is(err, &cerrors.NotFoundError{}) {
-			a.log.Warn("user not found", sl.Err(err))
+		var nfErr cerrors.NotFoundError
+		if !errors.As(err, &nfErr) {
+			log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.setPassword(ctx, log, usr, password); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.DebugContext(ctx, "password set")
+
+	return nil
+}
+
+// setPassword checks new password of user against policy
+// and saves its hash.
+func (a *AuthService) setPassword(
+	ctx context.Context,
+	log *slog.Logger,
+	usr *entities.User,
+	password string,
+) error {
+	if err := a.checkPassword(ctx, password, usr.Email); err != nil {
+		return err
+	}
+
+	passHash, err := a.hashPassword(ctx, password)
+	if err != nil {
+		return err
+	}
+
+	if err := a.userSaver.UpdatePassHash(ctx, int64(usr.UID), passHash); err != nil {
+		log.ErrorContext(ctx, "failed to update password hash", sl.Err(err))
+		return err
+	}
+
+	return nil
+}
+
+// VerifyPassword returns user with given uid if password is correct.
+// Confirms sensitive operations of already authenticated user.
+func (a *AuthService) VerifyPassword(
+	ctx context.Context,
+	uid int64,
+	password string,
+) (_ *entities.User, err error) {
+	const op = "authservice.VerifyPassword"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", uid))
+
+	usr, err := a.userProvider.GetUserById(ctx, uid)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "user not found", sl.Err(err))
 			return nil, cerrors.NewInvalidCredentialsError()
 		}
-		a.log.Error("failed to get user from storage", sl.Err(err))
+		log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.comparePassword(ctx, usr.PassHash, password); err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(usr.PassHash), []byte(dto.Password)); err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			a.log.Warn("password mismatch", sl.Err(err))
+	return usr, nil
+}
+
+// Reauthenticate returns user with given uid if password is correct,
+// or without password if user logged in at authTime not longer than
+// reauth max age ago. Confirms sensitive operations like VerifyPassword,
+// but works for users who have no password, provisioned by identity
+// provider or directory, once they log in again.
+// Returns ValidationError if password is empty and login is not recent.
+func (a *AuthService) Reauthenticate(
+	ctx context.Context,
+	uid int64,
+	authTime time.Time,
+	password string,
+) (_ *entities.User, err error) {
+	const op = "authservice.Reauthenticate"
+
+	if password != "" {
+		usr, err := a.VerifyPassword(ctx, uid, password)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		return usr, nil
+	}
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", uid))
+
+	if authTime.IsZero() || time.Since(authTime) > a.reauthMaxAge {
+		log.WarnContext(ctx, "login is not recent", slog.Time("auth_time", authTime))
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewValidationError(
+			"Reauthentication required",
+			cerrors.FieldViolation{
+				Field:       "password",
+				Description: fmt.Sprintf("is required unless logged in within %s", a.reauthMaxAge),
+			},
+		))
+	}
+
+	usr, err := a.userProvider.GetUserById(ctx, uid)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "user not found", sl.Err(err))
 			return nil, cerrors.NewInvalidCredentialsError()
 		}
-		a.log.Error("failed to compare password", sl.Err(err))
-		return nil, fmt.Errorf(
-			"%s: %w",
-			op,
-			cerrors.NewCriticalInternalError("bcrypt.CompareHashAndPassword", err),
-		)
+		log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return usr, nil
+}
+
+// Authenticate validates auth token of app appID and returns its claims.
+// Endpoints of SSO itself accept tokens of its own console apps only.
+// Secrets of other apps are held by client apps verifying tokens
+// offline, so any of them could forge tokens of arbitrary users.
+// Tokens of other apps are rejected with PermissionDeniedError.
+func (a *AuthService) Authenticate(
+	ctx context.Context,
+	token string,
+	appID int64,
+) (_ *entities.TokenClaims, err error) {
+	const op = "authservice.Authenticate"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op))
+
+	tokenAppID, err := jwt.UnverifiedAppId(token)
+	if err != nil {
+		log.WarnContext(ctx, "token bad format", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if appID == 0 || tokenAppID != appID {
+		log.WarnContext(ctx, "token of another app", slog.Int64("app_id", tokenAppID))
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewPermissionDeniedError("token of this app is not accepted"))
+	}
+
+	app, err := a.appProvider.GetApp(ctx, appID)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "app not found", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat))
+		}
+		log.ErrorContext(ctx, "failed to get app from storage", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	claims, err := jwt.ParseClaims(token, app.AuthSecret)
+	if err != nil {
+		log.WarnContext(ctx, "invalid token", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Tokens of deleted users stay valid until expiration,
+	// so check user still exists
+	if _, err := a.userProvider.GetUserById(ctx, claims.UID); err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "user of token not found", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat))
+		}
+		log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return claims, nil
+}
+
+// Login checks if user exists and if exists, returns pair of JWT tokens.
+// If user doesn't exist, returns error.
+func (a *AuthService) Login(
+	ctx context.Context,
+	dto dtos.LoginDto,
+) (_ *entities.JwtTokenPair, err error) {
+	const op = "authservice.Login"
+
+	var appID int64
+	defer func(start time.Time) {
+		metrics.ObserveAuth("login", appID, start, &err)
+	}(time.Now())
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op))
+	log.DebugContext(ctx, "login user")
+
+	usr, err := a.authenticate(ctx, dto.Login, dto.Password)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
 	app, err := a.appProvider.GetApp(ctx, dto.AppId)
 	if err != nil {
-		if errors.Is(err, &cerrors.NotFoundError{}) {
-			a.log.Warn("app not found", sl.Err(err))
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "app not found", sl.Err(err))
 			return nil, fmt.Errorf("%s: %w", op, err)
 		}
-		a.log.Error("failed to get app from storage", sl.Err(err))
+		log.ErrorContext(ctx, "failed to get app from storage", sl.Err(err))
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	appID = app.ID
 
-	a.log.Debug("user logged in successfully", slog.String("uid", fmt.Sprintf("%v", usr.UID)))
+	log.DebugContext(ctx, "user logged in successfully", slog.String("uid", fmt.Sprintf("%v", usr.UID)))
 
-	a.log.Debug("generating tokens")
+	tokens, err := a.newTokenPair(ctx, log, usr, app, 0, entities.Grant{
+		Type:     entities.GrantPassword,
+		AuthTime: time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
 
-	tokens, err := jwt.NewTokenPair(usr, app, a.authTokenTTL, a.refreshTokenTTL)
+// authenticate asks authenticators in order until one accepts
+// login and password. InvalidCredentialsError passes them to the next
+// one, any other error stops login.
+func (a *AuthService) authenticate(ctx context.Context, login string, password string) (*entities.User, error) {
+	var icErr cerrors.InvalidCredentialsError
+	for _, authenticator := range a.authenticators {
+		usr, err := authenticator.Authenticate(ctx, login, password)
+		if err == nil {
+			return usr, nil
+		}
+		if !errors.As(err, &icErr) {
+			return nil, err
+		}
+	}
+
+	return nil, cerrors.NewInvalidCredentialsError()
+}
+
+// localAuthenticator checks password against hash stored in users table.
+type localAuthenticator struct {
+	auth *AuthService
+}
+
+func (l localAuthenticator) Authenticate(
+	ctx context.Context,
+	login string,
+	password string,
+) (*entities.User, error) {
+	a := l.auth
+
+	usr, err := a.getUserByLogin(ctx, login)
 	if err != nil {
-		a.log.Error("failed to generate tokens", sl.Err(err))
-		return nil, fmt.Errorf(
-			"%s: %w",
-			op,
-			cerrors.NewCriticalInternalError("jwt.NewTokenPair", err),
-		)
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			a.log.WarnContext(ctx, "user not found", sl.Err(err))
+			return nil, a.compareDummyPassword(ctx, password)
+		}
+		a.log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		return nil, err
 	}
 
-	a.log.Debug(
-		"tokens generated",
-		slog.String("auth", tokens.AuthToken),
-		slog.String("refresh", tokens.RefreshToken),
-	)
+	if err := a.comparePassword(ctx, usr.PassHash, password); err != nil {
+		return nil, err
+	}
 
-	return tokens, nil
+	a.rehashPassword(ctx, usr, password)
+
+	return usr, nil
 }
 
 func (a *AuthService) Refresh(
 	ctx context.Context,
 	dto dtos.RefreshDto,
-) (*entities.JwtTokenPair, error) {
+) (_ *entities.JwtTokenPair, err error) {
 	const op = "authservice.Refresh"
 
-	a.log.With(slog.String("op", op))
-	a.log.Debug("getting app")
+	var appID int64
+	defer func(start time.Time) {
+		metrics.ObserveAuth("refresh", appID, start, &err)
+	}(time.Now())
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op))
+	log.DebugContext(ctx, "getting app")
 
 	app, err := a.appProvider.GetApp(ctx, dto.AppId)
 	if err != nil {
-		if errors.Is(err, &cerrors.NotFoundError{}) {
-			a.log.Warn("app not found", sl.Err(err))
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "app not found", sl.Err(err))
 			return nil, fmt.Errorf("%s: %w", op, err)
 		}
-		a.log.Error("failed to get app from storage", sl.Err(err))
+		log.ErrorContext(ctx, "failed to get app from storage", sl.Err(err))
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
+	appID = app.ID
 
-	a.log.Debug("validating token")
+	log.DebugContext(ctx, "validating token")
 
-	uid, err := jwt.ValidateToken(dto.RefreshToken, app.RefreshSecret)
+	claims, err := jwt.ParseClaims(dto.RefreshToken, app.RefreshSecret)
 	if err != nil {
 		var cErr cerrors.InvalidTokenError
 		if errors.As(err, &cErr) {
-			switch(cErr.Subject()) {
-			  case cerrors.TokenExpired:
-					a.log.Warn("token expired", sl.Err(err))
-					return nil, fmt.Errorf("%s: %w", op, err)
-				case cerrors.TokenBadFormat:
-					a.log.Warn("token bad format", sl.Err(err))
-					return nil, fmt.Errorf("%s: %w", op, err)
-			} 
+			switch cErr.Subject() {
+			case cerrors.TokenExpired:
+				log.WarnContext(ctx, "token expired", sl.Err(err))
+				return nil, fmt.Errorf("%s: %w", op, err)
+			case cerrors.TokenBadFormat:
+				log.WarnContext(ctx, "token bad format", sl.Err(err))
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
 		}
-		a.log.Error("failed to validate token", sl.Err(err))
-		return nil, cerrors.NewCriticalInternalError("jwt.IsTokenValid", err) 
+		log.ErrorContext(ctx, "failed to validate token", sl.Err(err))
+		return nil, cerrors.NewCriticalInternalError("jwt.IsTokenValid", err)
 	}
 
-	a.log.Debug("getting user")
+	log.DebugContext(ctx, "getting user")
 
-	usr, err := a.userProvider.GetUserById(ctx, uid)
+	usr, err := a.userProvider.GetUserById(ctx, claims.UID)
 	if err != nil {
-		if errors.Is(err, &cerrors.NotFoundError{}) {
-			a.log.Warn("user not found", sl.Err(err))
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "user not found", sl.Err(err))
 			return nil, cerrors.NewInvalidCredentialsError()
 		}
-		a.log.Error("failed to get user from storage", sl.Err(err))
+		log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	a.log.Debug("generating tokens")
+	// Refresh keeps active organization. User removed from it since
+	// gets the default one, unless app is scoped to it.
+	orgID := claims.OrgID
+	if orgID != 0 && app.OrgID == 0 {
+		member, err := a.isMember(ctx, orgID, claims.UID)
+		if err != nil {
+			log.ErrorContext(ctx, "failed to get membership from storage", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if !member {
+			log.InfoContext(ctx, "user left active organization", slog.Int64("org_id", orgID))
+			orgID = 0
+		}
+	}
 
-	tokens, err := jwt.NewTokenPair(usr, app, a.authTokenTTL, a.refreshTokenTTL)
+	tokens, err := a.newTokenPair(ctx, log, usr, app, orgID, entities.Grant{
+		Type:     entities.GrantRefresh,
+		MFA:      claims.MFA,
+		AuthTime: claims.AuthTime,
+	})
 	if err != nil {
-		a.log.Error("failed to generate tokens", sl.Err(err))
-		return nil, fmt.Errorf(
-			"%s: %w",
-			op,
-			cerrors.NewCriticalInternalError("jwt.NewTokenPair", err),
-		)
+		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	a.log.Debug(
+	return tokens, nil
+}
+
+// IssueTokens issues tokens of user for app without checking credentials.
+// It is used by login methods verifying user by other means than password,
+// grant tells which one and whether it verified more than one factor.
+func (a *AuthService) IssueTokens(
+	ctx context.Context,
+	uid int64,
+	appID int64,
+	grant entities.Grant,
+) (_ *entities.JwtTokenPair, err error) {
+	const op = "authservice.IssueTokens"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", uid))
+
+	usr, err := a.userProvider.GetUserById(ctx, uid)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "user not found", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := a.appProvider.GetApp(ctx, appID)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "app not found", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to get app from storage", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if grant.AuthTime.IsZero() {
+		grant.AuthTime = time.Now()
+	}
+
+	tokens, err := a.newTokenPair(ctx, log, usr, app, 0, grant)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
+
+// SwitchOrganization reissues tokens of user for app with another
+// active organization. User must be a member of it, and app scoped
+// to organization can't be switched to any other one.
+func (a *AuthService) SwitchOrganization(
+	ctx context.Context,
+	dto dtos.SwitchOrganizationDto,
+) (_ *entities.JwtTokenPair, err error) {
+	const op = "authservice.SwitchOrganization"
+
+	var appID int64
+	defer func(start time.Time) {
+		metrics.ObserveAuth("switch_organization", appID, start, &err)
+	}(time.Now())
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := a.log.With(slog.String("op", op), slog.Int64("uid", dto.UID), slog.Int64("org_id", dto.OrgID))
+
+	usr, err := a.userProvider.GetUserById(ctx, dto.UID)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "user not found", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := a.appProvider.GetApp(ctx, dto.AppId)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "app not found", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to get app from storage", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	appID = app.ID
+
+	// Switching reissues tokens of current session like refresh does
+	tokens, err := a.newTokenPair(ctx, log, usr, app, dto.OrgID, entities.Grant{
+		Type:     entities.GrantRefresh,
+		MFA:      dto.MFA,
+		AuthTime: dto.AuthTime,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
+
+// newTokenPair issues tokens of user for app with orgID as active
+// organization, 0 picks the default one.
+// Every login method ends here, so tokens are the same whatever way
+// user logged in, and policy and restriction of app are checked
+// for all of them.
+func (a *AuthService) newTokenPair(
+	ctx context.Context,
+	log *slog.Logger,
+	usr *entities.User,
+	app *entities.App,
+	orgID int64,
+	grant entities.Grant,
+) (*entities.JwtTokenPair, error) {
+	if !app.AllowsGrant(grant.Type) {
+		log.WarnContext(ctx, "grant type not allowed for app", slog.String("grant_type", string(grant.Type)))
+		return nil, cerrors.NewPermissionDeniedError(fmt.Sprintf("grant type %s not allowed for app", grant.Type))
+	}
+	if app.RequireMFA && !grant.MFA {
+		log.WarnContext(ctx, "app requires multi-factor login", slog.String("grant_type", string(grant.Type)))
+		return nil, cerrors.NewPermissionDeniedError("app requires multi-factor authentication")
+	}
+
+	if app.Restricted {
+		granted, err := a.accessChecker.HasAppAccess(ctx, app.ID, int64(usr.UID))
+		if err != nil {
+			log.ErrorContext(ctx, "failed to check access to app", sl.Err(err))
+			return nil, err
+		}
+		if !granted {
+			log.WarnContext(ctx, "user has no access to app", slog.Int64("app_id", app.ID))
+			return nil, cerrors.NewPermissionDeniedError("no access to app")
+		}
+	}
+
+	orgID, err := a.resolveOrg(ctx, usr, app, orgID)
+	if err != nil {
+		var pdErr cerrors.PermissionDeniedError
+		if errors.As(err, &pdErr) {
+			log.WarnContext(ctx, "organization not allowed", sl.Err(err))
+			return nil, err
+		}
+		log.ErrorContext(ctx, "failed to get memberships from storage", sl.Err(err))
+		return nil, err
+	}
+
+	log.DebugContext(ctx, "generating tokens")
+
+	opts := append([]jwt.Option{jwt.WithOrg(orgID), jwt.WithAuthTime(grant.AuthTime)}, a.tokenOptions...)
+	if grant.MFA {
+		opts = append(opts, jwt.WithMFA())
+	}
+	tokens, err := jwt.NewTokenPair(usr, app, a.authTokenTTL, a.refreshTokenTTL, opts...)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to generate tokens", sl.Err(err))
+		return nil, cerrors.NewCriticalInternalError("jwt.NewTokenPair", err)
+	}
+
+	log.DebugContext(
+		ctx,
 		"tokens generated",
-		slog.String("auth", tokens.AuthToken),
-		slog.String("refresh", tokens.RefreshToken),
+		slog.Uint64("uid", usr.UID),
+		slog.Int64("app_id", app.ID),
+		slog.String("grant_type", string(grant.Type)),
 	)
 
 	return tokens, nil
-}
\ No newline at end of file
+}
+
+// resolveOrg returns active organization of user in app. App scoped
+// to organization is only for its members and always has it active.
+// Otherwise requested organization is used if user is a member of it,
+// 0 picks the one user joined first, if any.
+func (a *AuthService) resolveOrg(
+	ctx context.Context,
+	usr *entities.User,
+	app *entities.App,
+	requested int64,
+) (int64, error) {
+	uid := int64(usr.UID)
+
+	if app.OrgID != 0 {
+		if requested != 0 && requested != app.OrgID {
+			return 0, cerrors.NewPermissionDeniedError("app belongs to another organization")
+		}
+		requested = app.OrgID
+	}
+
+	if requested != 0 {
+		member, err := a.isMember(ctx, requested, uid)
+		if err != nil {
+			return 0, err
+		}
+		if !member {
+			return 0, cerrors.NewPermissionDeniedError("not a member of organization")
+		}
+		return requested, nil
+	}
+
+	memberships, err := a.memberships.GetMemberships(ctx, uid)
+	if err != nil {
+		return 0, err
+	}
+	if len(memberships) == 0 {
+		return 0, nil
+	}
+
+	return memberships[0].OrgID, nil
+}
+
+func (a *AuthService) isMember(ctx context.Context, orgID int64, uid int64) (bool, error) {
+	_, err := a.memberships.GetMembership(ctx, orgID, uid)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
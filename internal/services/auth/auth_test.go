@@ -0,0 +1,539 @@
+package authservice_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/hasher"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/jwt"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/passpolicy"
+	authservice "github.com/Woland-prj/microtasks_sso/internal/services/auth"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const password = "Correct1Horse"
+
+// Cheap parameters keep tests fast
+var argon2idParams = hasher.Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+type membershipKey struct {
+	orgID int64
+	uid   int64
+}
+
+// fakeStorage keeps users, apps, memberships and app grants in memory.
+type fakeStorage struct {
+	users       map[int64]*entities.User
+	apps        map[int64]*entities.App
+	memberships map[membershipKey]bool
+	grants      []entities.AppGrant
+	// rehashed are uids whose hash was updated
+	rehashed []int64
+}
+
+func (f *fakeStorage) SaveUser(_ context.Context, usr *entities.User) (int64, error) {
+	uid := int64(len(f.users) + 1)
+	usr.UID = uint64(uid)
+	f.users[uid] = usr
+	return uid, nil
+}
+
+func (f *fakeStorage) UpdatePassHash(_ context.Context, uid int64, passHash string) error {
+	f.users[uid].PassHash = passHash
+	f.rehashed = append(f.rehashed, uid)
+	return nil
+}
+
+func (f *fakeStorage) GetUserByEmail(_ context.Context, email string) (*entities.User, error) {
+	for _, usr := range f.users {
+		if usr.Email == email {
+			u := *usr
+			return &u, nil
+		}
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("user %s", email))
+}
+
+func (f *fakeStorage) GetUserByUsername(_ context.Context, username string) (*entities.User, error) {
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("user %s", username))
+}
+
+func (f *fakeStorage) GetUserById(_ context.Context, uid int64) (*entities.User, error) {
+	if usr, ok := f.users[uid]; ok {
+		u := *usr
+		return &u, nil
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("user %d", uid))
+}
+
+func (f *fakeStorage) GetApp(_ context.Context, id int64) (*entities.App, error) {
+	if app, ok := f.apps[id]; ok {
+		a := *app
+		return &a, nil
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("app %d", id))
+}
+
+func (f *fakeStorage) GetMembership(_ context.Context, orgID int64, uid int64) (*entities.Membership, error) {
+	if !f.memberships[membershipKey{orgID, uid}] {
+		return nil, cerrors.NewNotFoundError(fmt.Sprintf("membership %d", orgID))
+	}
+	return &entities.Membership{OrgID: orgID, UserID: uid, Role: entities.RoleMember}, nil
+}
+
+func (f *fakeStorage) GetMemberships(_ context.Context, uid int64) ([]entities.Membership, error) {
+	// Ordered by organization id, as if joined in that order
+	var memberships []entities.Membership
+	for orgID := int64(1); orgID <= 10; orgID++ {
+		if f.memberships[membershipKey{orgID, uid}] {
+			memberships = append(memberships, entities.Membership{OrgID: orgID, UserID: uid})
+		}
+	}
+	return memberships, nil
+}
+
+func (f *fakeStorage) HasAppAccess(_ context.Context, appID int64, uid int64) (bool, error) {
+	for _, g := range f.grants {
+		if g.AppID != appID {
+			continue
+		}
+		if g.UserID == uid || f.memberships[membershipKey{g.OrgID, uid}] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// newService returns service hashing with argon2id and storage
+// with user 1 ann and app 1.
+func newService(t *testing.T) (*authservice.AuthService, *fakeStorage) {
+	passwordHasher, err := hasher.New(hasher.Options{
+		Algorithm: hasher.AlgorithmArgon2id,
+		Argon2id:  argon2idParams,
+	})
+	require.NoError(t, err)
+
+	passHash, err := passwordHasher.Hash(password)
+	require.NoError(t, err)
+
+	storage := &fakeStorage{
+		users: map[int64]*entities.User{
+			1: {UID: 1, Email: "ann@example.com", PassHash: passHash},
+		},
+		apps: map[int64]*entities.App{
+			1: {ID: 1, AuthSecret: "a", RefreshSecret: "r"},
+		},
+		memberships: make(map[membershipKey]bool),
+	}
+
+	service := authservice.New(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		time.Hour,
+		24*time.Hour,
+		5*time.Minute,
+		false,
+		storage,
+		storage,
+		storage,
+		storage,
+		storage,
+		passpolicy.Policy{},
+		passwordHasher,
+		hasher.NewLimiter(1, 0),
+		nil,
+		nil,
+	)
+
+	return service, storage
+}
+
+func login(service *authservice.AuthService, appID int64) (*entities.TokenClaims, error) {
+	tokens, err := service.Login(context.Background(), dtos.LoginDto{
+		Login:    "ann@example.com",
+		Password: password,
+		AppId:    appID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jwt.ParseClaims(tokens.AuthToken, "a")
+}
+
+// useBcryptHash replaces password hash of ann with one made
+// by previous algorithm.
+func useBcryptHash(t *testing.T, storage *fakeStorage) {
+	bcrypt, err := hasher.New(hasher.Options{Algorithm: hasher.AlgorithmBcrypt, BcryptCost: 4})
+	require.NoError(t, err)
+
+	storage.users[1].PassHash, err = bcrypt.Hash(password)
+	require.NoError(t, err)
+}
+
+func TestLogin_RehashesOutdatedHash(t *testing.T) {
+	service, storage := newService(t)
+
+	useBcryptHash(t, storage)
+
+	_, err := login(service, 1)
+	require.NoError(t, err)
+
+	require.Equal(t, []int64{1}, storage.rehashed)
+	assert.Contains(t, storage.users[1].PassHash, "$argon2id$")
+
+	// Up to date hash is left as is
+	_, err = login(service, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1}, storage.rehashed)
+}
+
+func TestLogin_WrongPasswordNotRehashed(t *testing.T) {
+	service, storage := newService(t)
+
+	useBcryptHash(t, storage)
+
+	_, err := service.Login(context.Background(), dtos.LoginDto{
+		Login:    "ann@example.com",
+		Password: "Wrong1Horse",
+		AppId:    1,
+	})
+	var icErr cerrors.InvalidCredentialsError
+	assert.ErrorAs(t, err, &icErr)
+	assert.Empty(t, storage.rehashed)
+}
+
+func refresh(service *authservice.AuthService, tokens *entities.JwtTokenPair) (*entities.JwtTokenPair, *entities.TokenClaims, error) {
+	tokens, err := service.Refresh(context.Background(), dtos.RefreshDto{
+		RefreshToken: tokens.RefreshToken,
+		AppId:        1,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	claims, err := jwt.ParseClaims(tokens.AuthToken, "a")
+	return tokens, claims, err
+}
+
+func TestLogin_OrgScopedApp(t *testing.T) {
+	service, storage := newService(t)
+	storage.apps[2] = &entities.App{ID: 2, AuthSecret: "a", RefreshSecret: "r", OrgID: 5}
+
+	_, err := login(service, 2)
+	var pdErr cerrors.PermissionDeniedError
+	require.ErrorAs(t, err, &pdErr)
+
+	// Organization of app is active even if user joined another one first
+	storage.memberships[membershipKey{1, 1}] = true
+	storage.memberships[membershipKey{5, 1}] = true
+
+	claims, err := login(service, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), claims.OrgID)
+
+	_, err = service.SwitchOrganization(context.Background(), dtos.SwitchOrganizationDto{UID: 1, AppId: 2, OrgID: 1})
+	assert.ErrorAs(t, err, &pdErr)
+}
+
+func TestSwitchOrganization(t *testing.T) {
+	service, storage := newService(t)
+	ctx := context.Background()
+
+	claims, err := login(service, 1)
+	require.NoError(t, err)
+	assert.Zero(t, claims.OrgID)
+
+	storage.memberships[membershipKey{1, 1}] = true
+	storage.memberships[membershipKey{2, 1}] = true
+
+	// The earliest organization is active by default
+	claims, err = login(service, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), claims.OrgID)
+
+	tokens, err := service.SwitchOrganization(ctx, dtos.SwitchOrganizationDto{UID: 1, AppId: 1, OrgID: 2})
+	require.NoError(t, err)
+	claims, err = jwt.ParseClaims(tokens.AuthToken, "a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), claims.OrgID)
+
+	_, err = service.SwitchOrganization(ctx, dtos.SwitchOrganizationDto{UID: 1, AppId: 1, OrgID: 3})
+	var pdErr cerrors.PermissionDeniedError
+	assert.ErrorAs(t, err, &pdErr)
+}
+
+func TestRefresh_KeepsOrgAndMFA(t *testing.T) {
+	service, storage := newService(t)
+	storage.memberships[membershipKey{1, 1}] = true
+	storage.memberships[membershipKey{2, 1}] = true
+
+	tokens, err := service.SwitchOrganization(context.Background(), dtos.SwitchOrganizationDto{
+		UID:   1,
+		AppId: 1,
+		OrgID: 2,
+		MFA:   true,
+	})
+	require.NoError(t, err)
+
+	tokens, claims, err := refresh(service, tokens)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), claims.OrgID)
+	assert.True(t, claims.MFA)
+
+	// User removed from active organization gets the default one
+	delete(storage.memberships, membershipKey{2, 1})
+
+	_, claims, err = refresh(service, tokens)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), claims.OrgID)
+	assert.True(t, claims.MFA)
+}
+
+func TestRestrictedApp(t *testing.T) {
+	cases := []struct {
+		name    string
+		grant   entities.AppGrant
+		granted bool
+	}{
+		{
+			name:    "not granted",
+			grant:   entities.AppGrant{AppID: 1, UserID: 2},
+			granted: false,
+		},
+		{
+			name:    "granted to another app",
+			grant:   entities.AppGrant{AppID: 2, UserID: 1},
+			granted: false,
+		},
+		{
+			name:    "granted to user",
+			grant:   entities.AppGrant{AppID: 1, UserID: 1},
+			granted: true,
+		},
+		{
+			name:    "granted to organization of user",
+			grant:   entities.AppGrant{AppID: 1, OrgID: 3},
+			granted: true,
+		},
+		{
+			name:    "granted to another organization",
+			grant:   entities.AppGrant{AppID: 1, OrgID: 4},
+			granted: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			service, storage := newService(t)
+			storage.apps[1].Restricted = true
+			storage.memberships[membershipKey{3, 1}] = true
+			storage.grants = []entities.AppGrant{tc.grant}
+
+			_, err := login(service, 1)
+			_, issueErr := service.IssueTokens(context.Background(), 1, 1, entities.Grant{Type: entities.GrantMagicLink})
+
+			if tc.granted {
+				assert.NoError(t, err)
+				assert.NoError(t, issueErr)
+				return
+			}
+			var pdErr cerrors.PermissionDeniedError
+			assert.ErrorAs(t, err, &pdErr)
+			assert.ErrorAs(t, issueErr, &pdErr)
+		})
+	}
+}
+
+func TestRestrictedApp_RevokedGrantNotRefreshed(t *testing.T) {
+	service, storage := newService(t)
+	storage.apps[1].Restricted = true
+	storage.grants = []entities.AppGrant{{AppID: 1, UserID: 1}}
+
+	tokens, err := service.IssueTokens(context.Background(), 1, 1, entities.Grant{Type: entities.GrantMagicLink})
+	require.NoError(t, err)
+
+	storage.grants = nil
+
+	_, _, err = refresh(service, tokens)
+	var pdErr cerrors.PermissionDeniedError
+	assert.ErrorAs(t, err, &pdErr)
+}
+
+func TestAppPolicy_GrantTypes(t *testing.T) {
+	service, storage := newService(t)
+	storage.apps[1].GrantTypes = []entities.GrantType{entities.GrantWebAuthn, entities.GrantRefresh}
+
+	var pdErr cerrors.PermissionDeniedError
+
+	_, err := login(service, 1)
+	assert.ErrorAs(t, err, &pdErr)
+
+	_, err = service.IssueTokens(context.Background(), 1, 1, entities.Grant{Type: entities.GrantMagicLink})
+	assert.ErrorAs(t, err, &pdErr)
+
+	tokens, err := service.IssueTokens(context.Background(), 1, 1, entities.Grant{Type: entities.GrantWebAuthn})
+	require.NoError(t, err)
+
+	_, _, err = refresh(service, tokens)
+	assert.NoError(t, err)
+
+	// Refresh disallowed after login keeps tokens from being renewed
+	storage.apps[1].GrantTypes = []entities.GrantType{entities.GrantWebAuthn}
+	_, _, err = refresh(service, tokens)
+	assert.ErrorAs(t, err, &pdErr)
+}
+
+func TestAppPolicy_RequireMFA(t *testing.T) {
+	service, storage := newService(t)
+	storage.apps[1].RequireMFA = true
+
+	var pdErr cerrors.PermissionDeniedError
+
+	_, err := login(service, 1)
+	assert.ErrorAs(t, err, &pdErr)
+
+	_, err = service.IssueTokens(context.Background(), 1, 1, entities.Grant{Type: entities.GrantWebAuthn})
+	assert.ErrorAs(t, err, &pdErr)
+
+	tokens, err := service.IssueTokens(context.Background(), 1, 1, entities.Grant{Type: entities.GrantWebAuthn, MFA: true})
+	require.NoError(t, err)
+
+	_, claims, err := refresh(service, tokens)
+	require.NoError(t, err)
+	assert.True(t, claims.MFA)
+}
+
+// expiresIn returns time left until expiration of token.
+func expiresIn(t *testing.T, token string) time.Duration {
+	t.Helper()
+
+	claims := gojwt.MapClaims{}
+	_, _, err := gojwt.NewParser().ParseUnverified(token, claims)
+	require.NoError(t, err)
+
+	exp, err := claims.GetExpirationTime()
+	require.NoError(t, err)
+
+	return time.Until(exp.Time).Round(time.Minute)
+}
+
+func TestAppPolicy_TokenTTL(t *testing.T) {
+	service, storage := newService(t)
+
+	tokens, err := service.IssueTokens(context.Background(), 1, 1, entities.Grant{Type: entities.GrantMagicLink})
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, expiresIn(t, tokens.AuthToken))
+	assert.Equal(t, 24*time.Hour, expiresIn(t, tokens.RefreshToken))
+
+	storage.apps[1].AuthTokenTTL = 5 * time.Minute
+	storage.apps[1].RefreshTokenTTL = 30 * 24 * time.Hour
+
+	tokens, err = service.IssueTokens(context.Background(), 1, 1, entities.Grant{Type: entities.GrantMagicLink})
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, expiresIn(t, tokens.AuthToken))
+	assert.Equal(t, 30*24*time.Hour, expiresIn(t, tokens.RefreshToken))
+
+	// Refresh picks up TTLs of app too
+	tokens, _, err = refresh(service, tokens)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, expiresIn(t, tokens.AuthToken))
+}
+
+func TestAuthenticate_ConsoleAppOnly(t *testing.T) {
+	service, storage := newService(t)
+	storage.apps[2] = &entities.App{ID: 2, AuthSecret: "b", RefreshSecret: "r2"}
+	ctx := context.Background()
+
+	tokens, err := service.IssueTokens(ctx, 1, 1, entities.Grant{Type: entities.GrantMagicLink})
+	require.NoError(t, err)
+	claims, err := service.Authenticate(ctx, tokens.AuthToken, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), claims.UID)
+
+	var pdErr cerrors.PermissionDeniedError
+	var itErr cerrors.InvalidTokenError
+
+	// Genuine token of ordinary app
+	tokens, err = service.IssueTokens(ctx, 1, 2, entities.Grant{Type: entities.GrantMagicLink})
+	require.NoError(t, err)
+	_, err = service.Authenticate(ctx, tokens.AuthToken, 1)
+	assert.ErrorAs(t, err, &pdErr)
+
+	// Ordinary app holding its secret forges token of console app
+	forged, err := jwt.NewTokenPair(
+		&entities.User{UID: 1, Email: "ann@example.com"},
+		&entities.App{ID: 1, AuthSecret: "b", RefreshSecret: "r2"},
+		time.Hour,
+		time.Hour,
+	)
+	require.NoError(t, err)
+	_, err = service.Authenticate(ctx, forged.AuthToken, 1)
+	assert.ErrorAs(t, err, &itErr)
+
+	// No console app configured
+	_, err = service.Authenticate(ctx, tokens.AuthToken, 0)
+	assert.ErrorAs(t, err, &pdErr)
+}
+
+func TestRefresh_KeepsAuthTime(t *testing.T) {
+	service, _ := newService(t)
+	authTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	tokens, err := service.IssueTokens(context.Background(), 1, 1, entities.Grant{
+		Type:     entities.GrantMagicLink,
+		AuthTime: authTime,
+	})
+	require.NoError(t, err)
+
+	_, claims, err := refresh(service, tokens)
+	require.NoError(t, err)
+	assert.Equal(t, authTime, claims.AuthTime)
+
+	// Login sets it to now
+	claims, err = login(service, 1)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), claims.AuthTime, time.Second)
+}
+
+func TestReauthenticate(t *testing.T) {
+	service, storage := newService(t)
+	storage.users[2] = &entities.User{UID: 2, Email: "bob@example.com"}
+	ctx := context.Background()
+
+	var vErr cerrors.ValidationError
+	var icErr cerrors.InvalidCredentialsError
+
+	usr, err := service.Reauthenticate(ctx, 1, time.Time{}, password)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), usr.UID)
+
+	_, err = service.Reauthenticate(ctx, 1, time.Now(), "wrong")
+	assert.ErrorAs(t, err, &icErr)
+
+	_, err = service.Reauthenticate(ctx, 1, time.Now().Add(-time.Hour), "")
+	assert.ErrorAs(t, err, &vErr)
+
+	_, err = service.Reauthenticate(ctx, 1, time.Time{}, "")
+	assert.ErrorAs(t, err, &vErr)
+
+	// User without password confirms by logging in again
+	_, err = service.Reauthenticate(ctx, 2, time.Now().Add(-time.Hour), "")
+	assert.ErrorAs(t, err, &vErr)
+
+	usr, err = service.Reauthenticate(ctx, 2, time.Now().Add(-time.Minute), "")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), usr.UID)
+}
@@ -0,0 +1,413 @@
+package federationservice
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/emailaddr"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/sl"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/metrics"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/onetime"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/tracing"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+type UserProvider interface {
+	GetUserByEmail(
+		ctx context.Context,
+		email string,
+	) (*entities.User, error)
+}
+
+type UserSaver interface {
+	SaveUser(
+		ctx context.Context,
+		user *entities.User,
+	) (int64, error)
+}
+
+type AppProvider interface {
+	GetApp(
+		ctx context.Context,
+		id int64,
+	) (*entities.App, error)
+}
+
+type IdentityStorage interface {
+	GetFederatedIdentity(
+		ctx context.Context,
+		issuer string,
+		subject string,
+	) (*entities.FederatedIdentity, error)
+	SaveFederatedIdentity(
+		ctx context.Context,
+		identity *entities.FederatedIdentity,
+	) error
+}
+
+type StateStorage interface {
+	SaveFederationState(
+		ctx context.Context,
+		state *entities.FederationState,
+	) error
+	ConsumeFederationState(
+		ctx context.Context,
+		tokenHash string,
+		now time.Time,
+	) (*entities.FederationState, error)
+}
+
+type TokenIssuer interface {
+	IssueTokens(
+		ctx context.Context,
+		uid int64,
+		appID int64,
+		grant entities.Grant,
+	) (*entities.JwtTokenPair, error)
+}
+
+// Options describe upstream OpenID Connect provider.
+// RedirectURL must be registered at provider as redirect URI of client.
+// AutoProvision creates local users for unknown verified emails,
+// otherwise only existing users can log in.
+// Logins not finished within StateTTL fail.
+type Options struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	AutoProvision bool
+	StateTTL      time.Duration
+}
+
+type FederationService struct {
+	log             *slog.Logger
+	userProvider    UserProvider
+	userSaver       UserSaver
+	appProvider     AppProvider
+	identityStorage IdentityStorage
+	stateStorage    StateStorage
+	tokenIssuer     TokenIssuer
+	opts            Options
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// New returns new FederationService instance.
+// Provider is discovered on first login, so unavailable provider
+// doesn't prevent start.
+func New(
+	log *slog.Logger,
+	userProvider UserProvider,
+	userSaver UserSaver,
+	appProvider AppProvider,
+	identityStorage IdentityStorage,
+	stateStorage StateStorage,
+	tokenIssuer TokenIssuer,
+	opts Options,
+) *FederationService {
+	return &FederationService{
+		log:             log,
+		userProvider:    userProvider,
+		userSaver:       userSaver,
+		appProvider:     appProvider,
+		identityStorage: identityStorage,
+		stateStorage:    stateStorage,
+		tokenIssuer:     tokenIssuer,
+		opts:            opts,
+	}
+}
+
+// idTokenClaims are claims of ID token used to find or create local user.
+type idTokenClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Picture       string `json:"picture"`
+	Locale        string `json:"locale"`
+	Zoneinfo      string `json:"zoneinfo"`
+}
+
+// BeginLogin starts login into app by upstream provider.
+// Returns URL of provider to send user to and binding secret,
+// which browser of user must present to finish login, so state
+// and code of one user can't be slipped to another one.
+func (f *FederationService) BeginLogin(
+	ctx context.Context,
+	appID int64,
+) (authURL string, binding string, err error) {
+	const op = "federationservice.BeginLogin"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := f.log.With(slog.String("op", op))
+
+	if _, err := f.appProvider.GetApp(ctx, appID); err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "app not found", sl.Err(err))
+			return "", "", fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to get app from storage", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	provider, err := f.getProvider(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to discover provider", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	state, stateHash, err := onetime.New()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, cerrors.NewCriticalInternalError("onetime.New", err))
+	}
+
+	// Nonce is compared with claim of ID token, so it is stored as is
+	nonce, _, err := onetime.New()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, cerrors.NewCriticalInternalError("onetime.New", err))
+	}
+
+	binding, bindingHash, err := onetime.New()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, cerrors.NewCriticalInternalError("onetime.New", err))
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	err = f.stateStorage.SaveFederationState(ctx, &entities.FederationState{
+		TokenHash:    stateHash,
+		AppID:        appID,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		BindingHash:  bindingHash,
+		ExpiresAt:    time.Now().Add(f.opts.StateTTL),
+	})
+	if err != nil {
+		log.ErrorContext(ctx, "failed to save state", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	authURL = f.oauth2Config(provider).AuthCodeURL(
+		state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(verifier),
+	)
+
+	return authURL, binding, nil
+}
+
+// FinishLogin exchanges authorization code for ID token, finds or creates
+// local user by it and returns the same tokens as login by password.
+func (f *FederationService) FinishLogin(
+	ctx context.Context,
+	dto dtos.FinishFederatedLoginDto,
+) (_ *entities.JwtTokenPair, err error) {
+	const op = "federationservice.FinishLogin"
+
+	var appID int64
+	defer func(start time.Time) {
+		metrics.ObserveAuth("federated_login", appID, start, &err)
+	}(time.Now())
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := f.log.With(slog.String("op", op))
+
+	state, err := f.stateStorage.ConsumeFederationState(ctx, onetime.Hash(dto.State), time.Now())
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "state not found", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat))
+		}
+		log.ErrorContext(ctx, "failed to consume state", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	appID = state.AppID
+
+	// State is consumed anyway, so leaked one can't be retried
+	if subtle.ConstantTimeCompare([]byte(onetime.Hash(dto.Binding)), []byte(state.BindingHash)) != 1 {
+		log.WarnContext(ctx, "state bound to another browser")
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat))
+	}
+
+	provider, err := f.getProvider(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to discover provider", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, err := f.oauth2Config(provider).Exchange(ctx, dto.Code, oauth2.VerifierOption(state.CodeVerifier))
+	if err != nil {
+		log.WarnContext(ctx, "failed to exchange code", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidCredentialsError())
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		log.WarnContext(ctx, "no id token in token response")
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidCredentialsError())
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: f.opts.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		log.WarnContext(ctx, "invalid id token", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidCredentialsError())
+	}
+	if idToken.Nonce != state.Nonce {
+		log.WarnContext(ctx, "id token nonce mismatch")
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidCredentialsError())
+	}
+
+	var claims idTokenClaims
+	if err := idToken.Claims(&claims); err != nil {
+		log.WarnContext(ctx, "bad id token claims", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidCredentialsError())
+	}
+
+	uid, err := f.resolveUser(ctx, log, idToken, claims)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := f.tokenIssuer.IssueTokens(ctx, uid, state.AppID, entities.Grant{
+		Type: entities.GrantFederation,
+	})
+	if err != nil {
+		// Linked user is deleted
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidCredentialsError())
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.DebugContext(ctx, "user logged in by upstream provider", slog.Int64("uid", uid))
+
+	return tokens, nil
+}
+
+// resolveUser returns uid of user linked to upstream account.
+// Account seen first time is linked to user with the same verified email,
+// created if allowed.
+func (f *FederationService) resolveUser(
+	ctx context.Context,
+	log *slog.Logger,
+	idToken *oidc.IDToken,
+	claims idTokenClaims,
+) (int64, error) {
+	identity, err := f.identityStorage.GetFederatedIdentity(ctx, idToken.Issuer, idToken.Subject)
+	if err == nil {
+		return identity.UserID, nil
+	}
+
+	var nfErr cerrors.NotFoundError
+	if !errors.As(err, &nfErr) {
+		log.ErrorContext(ctx, "failed to get identity from storage", sl.Err(err))
+		return 0, err
+	}
+
+	// Unverified email may belong to someone else
+	if claims.Email == "" || !claims.EmailVerified {
+		log.WarnContext(ctx, "upstream account has no verified email", slog.String("sub", idToken.Subject))
+		return 0, cerrors.NewInvalidCredentialsError()
+	}
+
+	var uid int64
+	usr, err := f.userProvider.GetUserByEmail(ctx, claims.Email)
+	switch {
+	case err == nil:
+		uid = int64(usr.UID)
+	case errors.As(err, &nfErr) && f.opts.AutoProvision:
+		uid, err = f.provisionUser(ctx, claims)
+		if err != nil {
+			log.ErrorContext(ctx, "failed to provision user", sl.Err(err))
+			return 0, err
+		}
+		log.InfoContext(ctx, "user provisioned", slog.Int64("uid", uid))
+	case errors.As(err, &nfErr):
+		log.WarnContext(ctx, "no user with upstream email", sl.Err(err))
+		return 0, cerrors.NewInvalidCredentialsError()
+	default:
+		log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		return 0, err
+	}
+
+	err = f.identityStorage.SaveFederatedIdentity(ctx, &entities.FederatedIdentity{
+		Issuer:    idToken.Issuer,
+		Subject:   idToken.Subject,
+		UserID:    uid,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.ErrorContext(ctx, "failed to save identity", sl.Err(err))
+		return 0, err
+	}
+
+	log.InfoContext(ctx, "upstream account linked", slog.Int64("uid", uid), slog.String("sub", idToken.Subject))
+
+	return uid, nil
+}
+
+// provisionUser creates user without password, profile is taken
+// from ID token.
+func (f *FederationService) provisionUser(ctx context.Context, claims idTokenClaims) (int64, error) {
+	email, err := emailaddr.Canonical(claims.Email)
+	if err != nil {
+		return 0, cerrors.NewInvalidCredentialsError()
+	}
+
+	return f.userSaver.SaveUser(ctx, &entities.User{
+		Email: email,
+		Profile: entities.Profile{
+			DisplayName: claims.Name,
+			AvatarURL:   claims.Picture,
+			Locale:      claims.Locale,
+			Timezone:    claims.Zoneinfo,
+		},
+	})
+}
+
+// getProvider discovers provider once. Failed discovery
+// is retried by next login.
+func (f *FederationService) getProvider(ctx context.Context) (*oidc.Provider, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.provider != nil {
+		return f.provider, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, f.opts.Issuer)
+	if err != nil {
+		return nil, cerrors.NewUnavailableError(fmt.Sprintf("identity provider: %s", err))
+	}
+	f.provider = provider
+
+	return provider, nil
+}
+
+func (f *FederationService) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     f.opts.ClientID,
+		ClientSecret: f.opts.ClientSecret,
+		RedirectURL:  f.opts.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       f.opts.Scopes,
+	}
+}
@@ -0,0 +1,375 @@
+package federationservice_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	federationservice "github.com/Woland-prj/microtasks_sso/internal/services/federation"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const clientID = "sso"
+
+// stubIdP is an OpenID Connect provider issuing ID tokens
+// with claims set by test for every authorization.
+type stubIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+type authorization struct {
+	challenge string
+	claims    map[string]any
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp := &stubIdP{key: key, codes: make(map[string]authorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "1", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", idp.token)
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+// authorize plays user logging in at provider and returns
+// parameters provider redirects back with.
+func (idp *stubIdP) authorize(t *testing.T, authURL string, claims map[string]any) dtos.FinishFederatedLoginDto {
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+	q := u.Query()
+
+	require.Equal(t, idp.server.URL+"/authorize", fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, u.Path))
+	require.Equal(t, clientID, q.Get("client_id"))
+	require.Equal(t, "S256", q.Get("code_challenge_method"))
+
+	full := map[string]any{
+		"iss":   idp.server.URL,
+		"aud":   clientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": q.Get("nonce"),
+	}
+	for k, v := range claims {
+		full[k] = v
+	}
+
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	idp.mu.Lock()
+	idp.codes[code] = authorization{challenge: q.Get("code_challenge"), claims: full}
+	idp.mu.Unlock()
+
+	return dtos.FinishFederatedLoginDto{State: q.Get("state"), Code: code}
+}
+
+func (idp *stubIdP) token(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	auth, ok := idp.codes[r.FormValue("code")]
+	delete(idp.codes, r.FormValue("code"))
+	idp.mu.Unlock()
+
+	verifierHash := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifierHash[:]) != auth.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: idp.key},
+		(&jose.SignerOptions{}).WithHeader("kid", "1"),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	payload, _ := json.Marshal(auth.claims)
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	idToken, _ := jws.CompactSerialize()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+// fakeStorage keeps users, identities and states in memory.
+type fakeStorage struct {
+	users      map[string]*entities.User
+	identities map[string]int64
+	states     map[string]entities.FederationState
+}
+
+func (f *fakeStorage) GetUserByEmail(_ context.Context, email string) (*entities.User, error) {
+	// Storage looks emails up case-insensitively
+	if usr, ok := f.users[strings.ToLower(email)]; ok {
+		return usr, nil
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("user %s", email))
+}
+
+func (f *fakeStorage) SaveUser(_ context.Context, usr *entities.User) (int64, error) {
+	usr.UID = uint64(len(f.users) + 1)
+	f.users[usr.Email] = usr
+	return int64(usr.UID), nil
+}
+
+func (f *fakeStorage) GetApp(_ context.Context, id int64) (*entities.App, error) {
+	if id != 1 {
+		return nil, cerrors.NewNotFoundError(fmt.Sprintf("app %d", id))
+	}
+	return &entities.App{ID: 1, Name: "test"}, nil
+}
+
+func (f *fakeStorage) GetFederatedIdentity(_ context.Context, issuer string, subject string) (*entities.FederatedIdentity, error) {
+	if uid, ok := f.identities[issuer+" "+subject]; ok {
+		return &entities.FederatedIdentity{Issuer: issuer, Subject: subject, UserID: uid}, nil
+	}
+	return nil, cerrors.NewNotFoundError("federated identity")
+}
+
+func (f *fakeStorage) SaveFederatedIdentity(_ context.Context, identity *entities.FederatedIdentity) error {
+	f.identities[identity.Issuer+" "+identity.Subject] = identity.UserID
+	return nil
+}
+
+func (f *fakeStorage) SaveFederationState(_ context.Context, state *entities.FederationState) error {
+	f.states[state.TokenHash] = *state
+	return nil
+}
+
+func (f *fakeStorage) ConsumeFederationState(_ context.Context, tokenHash string, now time.Time) (*entities.FederationState, error) {
+	state, ok := f.states[tokenHash]
+	delete(f.states, tokenHash)
+	if !ok || !state.ExpiresAt.After(now) {
+		return nil, cerrors.NewNotFoundError("federation state")
+	}
+	return &state, nil
+}
+
+type fakeTokenIssuer struct{}
+
+func (fakeTokenIssuer) IssueTokens(_ context.Context, uid int64, appID int64, _ entities.Grant) (*entities.JwtTokenPair, error) {
+	return &entities.JwtTokenPair{AuthToken: fmt.Sprintf("auth %d %d", uid, appID)}, nil
+}
+
+func newService(t *testing.T, idp *stubIdP, autoProvision bool) (*federationservice.FederationService, *fakeStorage) {
+	storage := &fakeStorage{
+		users: map[string]*entities.User{
+			"bob@example.com": {UID: 1, Email: "bob@example.com"},
+		},
+		identities: make(map[string]int64),
+		states:     make(map[string]entities.FederationState),
+	}
+
+	service := federationservice.New(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		storage,
+		storage,
+		storage,
+		storage,
+		storage,
+		fakeTokenIssuer{},
+		federationservice.Options{
+			Issuer:        idp.server.URL,
+			ClientID:      clientID,
+			ClientSecret:  "secret",
+			RedirectURL:   "http://localhost:8080/federation/callback",
+			Scopes:        []string{"openid", "email"},
+			AutoProvision: autoProvision,
+			StateTTL:      time.Minute,
+		},
+	)
+
+	return service, storage
+}
+
+func login(
+	t *testing.T,
+	service *federationservice.FederationService,
+	idp *stubIdP,
+	claims map[string]any,
+) (*entities.JwtTokenPair, error) {
+	authURL, binding, err := service.BeginLogin(context.Background(), 1)
+	require.NoError(t, err)
+
+	dto := idp.authorize(t, authURL, claims)
+	dto.Binding = binding
+
+	return service.FinishLogin(context.Background(), dto)
+}
+
+func TestFederation_LinksUserByVerifiedEmail(t *testing.T) {
+	idp := newStubIdP(t)
+	service, storage := newService(t, idp, false)
+
+	tokens, err := login(t, service, idp, map[string]any{
+		"sub":            "u-1",
+		"email":          "Bob@Example.com",
+		"email_verified": true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "auth 1 1", tokens.AuthToken)
+	assert.Equal(t, int64(1), storage.identities[idp.server.URL+" u-1"])
+
+	// Linked account is found by subject whatever its email is now
+	tokens, err = login(t, service, idp, map[string]any{"sub": "u-1", "email": "new@corp.example"})
+	require.NoError(t, err)
+	assert.Equal(t, "auth 1 1", tokens.AuthToken)
+}
+
+func TestFederation_Provisioning(t *testing.T) {
+	claims := map[string]any{
+		"sub":            "u-2",
+		"email":          "ann@example.com",
+		"email_verified": true,
+		"name":           "Ann",
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		idp := newStubIdP(t)
+		service, storage := newService(t, idp, false)
+
+		_, err := login(t, service, idp, claims)
+		var icErr cerrors.InvalidCredentialsError
+		assert.ErrorAs(t, err, &icErr)
+		assert.Len(t, storage.users, 1)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		idp := newStubIdP(t)
+		service, storage := newService(t, idp, true)
+
+		tokens, err := login(t, service, idp, claims)
+		require.NoError(t, err)
+		assert.Equal(t, "auth 2 1", tokens.AuthToken)
+
+		usr := storage.users["ann@example.com"]
+		require.NotNil(t, usr)
+		assert.Empty(t, usr.PassHash)
+		assert.Equal(t, "Ann", usr.Profile.DisplayName)
+	})
+}
+
+func TestFederation_UnverifiedEmail(t *testing.T) {
+	idp := newStubIdP(t)
+	service, storage := newService(t, idp, true)
+
+	_, err := login(t, service, idp, map[string]any{
+		"sub":            "u-3",
+		"email":          "bob@example.com",
+		"email_verified": false,
+	})
+	var icErr cerrors.InvalidCredentialsError
+	assert.ErrorAs(t, err, &icErr)
+	assert.Empty(t, storage.identities)
+}
+
+func TestFederation_StateIsSingleUse(t *testing.T) {
+	idp := newStubIdP(t)
+	service, _ := newService(t, idp, false)
+	ctx := context.Background()
+
+	authURL, binding, err := service.BeginLogin(ctx, 1)
+	require.NoError(t, err)
+	dto := idp.authorize(t, authURL, map[string]any{
+		"sub":            "u-1",
+		"email":          "bob@example.com",
+		"email_verified": true,
+	})
+	dto.Binding = binding
+
+	_, err = service.FinishLogin(ctx, dto)
+	require.NoError(t, err)
+
+	_, err = service.FinishLogin(ctx, dto)
+	var tErr cerrors.InvalidTokenError
+	assert.ErrorAs(t, err, &tErr)
+}
+
+func TestFederation_StateBoundToBrowser(t *testing.T) {
+	idp := newStubIdP(t)
+	service, _ := newService(t, idp, false)
+	ctx := context.Background()
+
+	// Attacker begins login and slips own state and code to victim
+	authURL, binding, err := service.BeginLogin(ctx, 1)
+	require.NoError(t, err)
+	dto := idp.authorize(t, authURL, map[string]any{
+		"sub":            "u-1",
+		"email":          "bob@example.com",
+		"email_verified": true,
+	})
+
+	_, victimBinding, err := service.BeginLogin(ctx, 1)
+	require.NoError(t, err)
+
+	var tErr cerrors.InvalidTokenError
+	for _, b := range []string{victimBinding, ""} {
+		dto.Binding = b
+		_, err = service.FinishLogin(ctx, dto)
+		assert.ErrorAs(t, err, &tErr)
+	}
+
+	// Rejected state is consumed
+	dto.Binding = binding
+	_, err = service.FinishLogin(ctx, dto)
+	assert.ErrorAs(t, err, &tErr)
+}
+
+func TestFederation_UnknownApp(t *testing.T) {
+	idp := newStubIdP(t)
+	service, _ := newService(t, idp, false)
+
+	_, _, err := service.BeginLogin(context.Background(), 2)
+	var nfErr cerrors.NotFoundError
+	assert.ErrorAs(t, err, &nfErr)
+}
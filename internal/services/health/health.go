@@ -0,0 +1,63 @@
+package healthservice
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/sl"
+)
+
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type HealthService struct {
+	log          *slog.Logger
+	pinger       Pinger
+	shuttingDown atomic.Bool
+}
+
+// New returns new HealthService instance
+func New(
+	log *slog.Logger,
+	pinger Pinger,
+) *HealthService {
+	return &HealthService{
+		log:    log,
+		pinger: pinger,
+	}
+}
+
+// Live reports whether process is alive.
+// It never checks dependencies, so orchestrator doesn't restart
+// the service because of storage outage.
+func (h *HealthService) Live(_ context.Context) error {
+	return nil
+}
+
+// Ready reports whether service is able to serve requests.
+//
+// Returns UnavailableError if service is shutting down or storage is unreachable.
+func (h *HealthService) Ready(ctx context.Context) error {
+	const op = "healthservice.Ready"
+
+	if h.shuttingDown.Load() {
+		return cerrors.NewUnavailableError("shutting down")
+	}
+
+	if err := h.pinger.Ping(ctx); err != nil {
+		h.log.With(slog.String("op", op)).WarnContext(ctx, "storage is unreachable", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, cerrors.NewUnavailableError("storage is unreachable"))
+	}
+
+	return nil
+}
+
+// Shutdown marks service as not ready. It is called before transports
+// are drained, so load balancers stop routing new requests.
+func (h *HealthService) Shutdown() {
+	h.shuttingDown.Store(true)
+}
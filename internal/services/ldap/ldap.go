@@ -0,0 +1,132 @@
+package ldapservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/emailaddr"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/ldapdir"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/sl"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/metrics"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/tracing"
+)
+
+type Directory interface {
+	Authenticate(
+		ctx context.Context,
+		login string,
+		password string,
+	) (*ldapdir.Entry, error)
+}
+
+type UserProvider interface {
+	GetUserByEmail(
+		ctx context.Context,
+		email string,
+	) (*entities.User, error)
+}
+
+type UserSaver interface {
+	SaveUser(
+		ctx context.Context,
+		user *entities.User,
+	) (int64, error)
+}
+
+type LDAPService struct {
+	log          *slog.Logger
+	directory    Directory
+	userProvider UserProvider
+	userSaver    UserSaver
+}
+
+// New returns new LDAPService instance.
+func New(
+	log *slog.Logger,
+	directory Directory,
+	userProvider UserProvider,
+	userSaver UserSaver,
+) *LDAPService {
+	return &LDAPService{
+		log:          log,
+		directory:    directory,
+		userProvider: userProvider,
+		userSaver:    userSaver,
+	}
+}
+
+// Authenticate checks login and password against directory and returns
+// local user with email of directory entry. User is created without
+// password on first login.
+func (l *LDAPService) Authenticate(
+	ctx context.Context,
+	login string,
+	password string,
+) (_ *entities.User, err error) {
+	const op = "ldapservice.Authenticate"
+
+	defer metrics.ObserveAuth("ldap_login", 0, time.Now(), &err)
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := l.log.With(slog.String("op", op))
+
+	entry, err := l.directory.Authenticate(ctx, login, password)
+	if err != nil {
+		if errors.Is(err, ldapdir.ErrInvalidCredentials) {
+			log.WarnContext(ctx, "directory rejected credentials", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidCredentialsError())
+		}
+		log.ErrorContext(ctx, "failed to query directory", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewUnavailableError("directory"))
+	}
+
+	email, err := emailaddr.Canonical(entry.Email)
+	if err != nil {
+		log.ErrorContext(ctx, "directory entry has invalid email", slog.String("dn", entry.DN), sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidCredentialsError())
+	}
+
+	usr, err := l.userProvider.GetUserByEmail(ctx, email)
+	if err == nil {
+		return usr, nil
+	}
+
+	var nfErr cerrors.NotFoundError
+	if !errors.As(err, &nfErr) {
+		log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	usr = &entities.User{
+		Email: email,
+		Profile: entities.Profile{
+			DisplayName: entry.Name,
+		},
+	}
+
+	uid, err := l.userSaver.SaveUser(ctx, usr)
+	if err != nil {
+		// Created by concurrent login
+		var aeErr cerrors.AlreadyExistsError
+		if errors.As(err, &aeErr) {
+			usr, err = l.userProvider.GetUserByEmail(ctx, email)
+			if err == nil {
+				return usr, nil
+			}
+		}
+		log.ErrorContext(ctx, "failed to save user", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	usr.UID = uint64(uid)
+
+	log.InfoContext(ctx, "user provisioned from directory", slog.Int64("uid", uid), slog.String("dn", entry.DN))
+
+	return usr, nil
+}
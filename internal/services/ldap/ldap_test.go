@@ -0,0 +1,107 @@
+package ldapservice_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/ldapdir"
+	ldapservice "github.com/Woland-prj/microtasks_sso/internal/services/ldap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDirectory struct {
+	err error
+}
+
+func (d fakeDirectory) Authenticate(_ context.Context, login string, password string) (*ldapdir.Entry, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if login != "ann" || password != "secret" {
+		return nil, fmt.Errorf("fake: %w", ldapdir.ErrInvalidCredentials)
+	}
+	return &ldapdir.Entry{DN: "uid=ann,dc=example,dc=com", Email: "Ann@Example.com", Name: "Ann"}, nil
+}
+
+type fakeStorage struct {
+	users map[string]*entities.User
+}
+
+func (f *fakeStorage) GetUserByEmail(_ context.Context, email string) (*entities.User, error) {
+	if usr, ok := f.users[strings.ToLower(email)]; ok {
+		return usr, nil
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("user %s", email))
+}
+
+func (f *fakeStorage) SaveUser(_ context.Context, usr *entities.User) (int64, error) {
+	saved := *usr
+	saved.UID = uint64(len(f.users) + 1)
+	f.users[strings.ToLower(usr.Email)] = &saved
+	return int64(saved.UID), nil
+}
+
+func newService(directory fakeDirectory, users ...*entities.User) (*ldapservice.LDAPService, *fakeStorage) {
+	storage := &fakeStorage{users: make(map[string]*entities.User)}
+	for _, usr := range users {
+		storage.users[strings.ToLower(usr.Email)] = usr
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return ldapservice.New(log, directory, storage, storage), storage
+}
+
+func TestLDAP_ProvisionsUserOnFirstLogin(t *testing.T) {
+	service, storage := newService(fakeDirectory{})
+
+	usr, err := service.Authenticate(context.Background(), "ann", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), usr.UID)
+	assert.Equal(t, "Ann@example.com", usr.Email)
+	assert.Equal(t, "Ann", usr.Profile.DisplayName)
+	assert.Empty(t, usr.PassHash)
+	require.Len(t, storage.users, 1)
+
+	again, err := service.Authenticate(context.Background(), "ann", "secret")
+	require.NoError(t, err)
+	assert.Equal(t, usr.UID, again.UID)
+	assert.Len(t, storage.users, 1)
+}
+
+func TestLDAP_ExistingUser(t *testing.T) {
+	existing := &entities.User{UID: 7, Email: "ann@example.com", PassHash: "hash"}
+	service, storage := newService(fakeDirectory{}, existing)
+
+	usr, err := service.Authenticate(context.Background(), "ann", "secret")
+	require.NoError(t, err)
+	assert.Same(t, existing, usr)
+	assert.Len(t, storage.users, 1)
+}
+
+func TestLDAP_Errors(t *testing.T) {
+	t.Run("invalid credentials", func(t *testing.T) {
+		service, storage := newService(fakeDirectory{})
+
+		_, err := service.Authenticate(context.Background(), "ann", "wrong")
+		var icErr cerrors.InvalidCredentialsError
+		assert.ErrorAs(t, err, &icErr)
+		assert.Empty(t, storage.users)
+	})
+
+	t.Run("directory unavailable", func(t *testing.T) {
+		service, _ := newService(fakeDirectory{err: errors.New("connection refused")})
+
+		_, err := service.Authenticate(context.Background(), "ann", "secret")
+		var uErr cerrors.UnavailableError
+		assert.ErrorAs(t, err, &uErr)
+	})
+}
@@ -0,0 +1,250 @@
+package magiclinkservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/sl"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/mailer"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/metrics"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/onetime"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/tracing"
+)
+
+type UserProvider interface {
+	GetUserByEmail(
+		ctx context.Context,
+		email string,
+	) (*entities.User, error)
+}
+
+type AppProvider interface {
+	GetApp(
+		ctx context.Context,
+		id int64,
+	) (*entities.App, error)
+}
+
+type LinkStorage interface {
+	SaveMagicLink(
+		ctx context.Context,
+		link *entities.MagicLink,
+		interval time.Duration,
+	) error
+	ConsumeMagicLink(
+		ctx context.Context,
+		tokenHash string,
+		now time.Time,
+	) (*entities.MagicLink, error)
+}
+
+type TokenIssuer interface {
+	IssueTokens(
+		ctx context.Context,
+		uid int64,
+		appID int64,
+		grant entities.Grant,
+	) (*entities.JwtTokenPair, error)
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg mailer.Message) error
+}
+
+type MagicLinkService struct {
+	log          *slog.Logger
+	userProvider UserProvider
+	appProvider  AppProvider
+	linkStorage  LinkStorage
+	tokenIssuer  TokenIssuer
+	mailer       Mailer
+	linkTTL      time.Duration
+	linkURL      string
+	interval     time.Duration
+}
+
+// New returns new MagicLinkService instance.
+// Links sent by email point to linkURL and expire after linkTTL.
+// User gets at most one link per interval, so address can't be
+// flooded with mail.
+func New(
+	log *slog.Logger,
+	userProvider UserProvider,
+	appProvider AppProvider,
+	linkStorage LinkStorage,
+	tokenIssuer TokenIssuer,
+	mailer Mailer,
+	linkTTL time.Duration,
+	linkURL string,
+	interval time.Duration,
+) *MagicLinkService {
+	return &MagicLinkService{
+		log:          log,
+		userProvider: userProvider,
+		appProvider:  appProvider,
+		linkStorage:  linkStorage,
+		tokenIssuer:  tokenIssuer,
+		mailer:       mailer,
+		linkTTL:      linkTTL,
+		linkURL:      linkURL,
+		interval:     interval,
+	}
+}
+
+// RequestMagicLink sends single-use login link into app to user email.
+//
+// Unknown email and link requested again within interval are not errors,
+// and email is sent in background, so neither response nor its timing
+// reveals whether email is registered.
+func (m *MagicLinkService) RequestMagicLink(
+	ctx context.Context,
+	dto dtos.RequestMagicLinkDto,
+) (err error) {
+	const op = "magiclinkservice.RequestMagicLink"
+
+	var appID int64
+	defer func(start time.Time) {
+		metrics.ObserveAuth("magic_link_request", appID, start, &err)
+	}(time.Now())
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := m.log.With(slog.String("op", op))
+	log.DebugContext(ctx, "requesting magic link")
+
+	app, err := m.appProvider.GetApp(ctx, dto.AppId)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "app not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to get app from storage", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	appID = app.ID
+
+	usr, err := m.userProvider.GetUserByEmail(ctx, dto.Email)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "user not found", sl.Err(err))
+			return nil
+		}
+		log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, tokenHash, err := onetime.New()
+	if err != nil {
+		log.ErrorContext(ctx, "failed to generate token", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, cerrors.NewCriticalInternalError("onetime.New", err))
+	}
+
+	now := time.Now()
+	err = m.linkStorage.SaveMagicLink(ctx, &entities.MagicLink{
+		TokenHash: tokenHash,
+		UserID:    int64(usr.UID),
+		AppID:     app.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(m.linkTTL),
+	}, m.interval)
+	if err != nil {
+		var aeErr cerrors.AlreadyExistsError
+		if errors.As(err, &aeErr) {
+			log.WarnContext(ctx, "magic link requested too often", slog.Uint64("uid", usr.UID))
+			return nil
+		}
+		log.ErrorContext(ctx, "failed to save magic link", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Sent in background, otherwise mail server round trip would make
+	// response for registered email slower than for unknown one
+	go m.sendLink(context.WithoutCancel(ctx), log, usr, app, token)
+
+	return nil
+}
+
+// sendLink mails magic link to user. Link is already saved, so failure
+// is only logged, user can request another link.
+func (m *MagicLinkService) sendLink(
+	ctx context.Context,
+	log *slog.Logger,
+	usr *entities.User,
+	app *entities.App,
+	token string,
+) {
+	err := m.mailer.Send(ctx, mailer.Message{
+		To:      usr.Email,
+		Subject: fmt.Sprintf("Sign in to %s", app.Name),
+		Body: fmt.Sprintf(
+			"Follow the link to sign in to %s:\n\n%s\n\nThe link works once and expires in %s. "+
+				"If you didn't request it, ignore this message.\n",
+			app.Name,
+			onetime.Link(m.linkURL, token),
+			m.linkTTL,
+		),
+	})
+	if err != nil {
+		log.ErrorContext(ctx, "failed to send magic link", sl.Err(err))
+		return
+	}
+
+	log.DebugContext(ctx, "magic link sent", slog.Uint64("uid", usr.UID))
+}
+
+// ConsumeMagicLink logs user in by token from magic link
+// and returns the same tokens as login by password.
+func (m *MagicLinkService) ConsumeMagicLink(
+	ctx context.Context,
+	dto dtos.ConsumeMagicLinkDto,
+) (_ *entities.JwtTokenPair, err error) {
+	const op = "magiclinkservice.ConsumeMagicLink"
+
+	var appID int64
+	defer func(start time.Time) {
+		metrics.ObserveAuth("magic_link_login", appID, start, &err)
+	}(time.Now())
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := m.log.With(slog.String("op", op))
+	log.DebugContext(ctx, "consuming magic link")
+
+	link, err := m.linkStorage.ConsumeMagicLink(ctx, onetime.Hash(dto.Token), time.Now())
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "magic link not found", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat))
+		}
+		log.ErrorContext(ctx, "failed to consume magic link", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	appID = link.AppID
+
+	tokens, err := m.tokenIssuer.IssueTokens(ctx, link.UserID, link.AppID, entities.Grant{
+		Type: entities.GrantMagicLink,
+	})
+	if err != nil {
+		// User or app is deleted since link was sent
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat))
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.DebugContext(ctx, "user logged in by magic link", slog.Int64("uid", link.UserID))
+
+	return tokens, nil
+}
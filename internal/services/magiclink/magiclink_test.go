@@ -0,0 +1,234 @@
+package magiclinkservice_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/mailer"
+	magiclinkservice "github.com/Woland-prj/microtasks_sso/internal/services/magiclink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	linkTTL  = 15 * time.Minute
+	interval = time.Minute
+)
+
+// fakeStorage keeps users, apps and magic links in memory,
+// a link per user like sqlite storage does.
+type fakeStorage struct {
+	users map[string]*entities.User
+	apps  map[int64]*entities.App
+	links map[string]entities.MagicLink
+}
+
+func (f *fakeStorage) GetUserByEmail(_ context.Context, email string) (*entities.User, error) {
+	if usr, ok := f.users[email]; ok {
+		return usr, nil
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("user %s", email))
+}
+
+func (f *fakeStorage) GetApp(_ context.Context, id int64) (*entities.App, error) {
+	if app, ok := f.apps[id]; ok {
+		return app, nil
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("app %d", id))
+}
+
+func (f *fakeStorage) SaveMagicLink(_ context.Context, link *entities.MagicLink, interval time.Duration) error {
+	for hash, l := range f.links {
+		if l.UserID != link.UserID {
+			continue
+		}
+		if l.CreatedAt.After(link.CreatedAt.Add(-interval)) {
+			return cerrors.NewAlreadyExistsError("recent magic link")
+		}
+		delete(f.links, hash)
+	}
+	f.links[link.TokenHash] = *link
+	return nil
+}
+
+func (f *fakeStorage) ConsumeMagicLink(_ context.Context, tokenHash string, now time.Time) (*entities.MagicLink, error) {
+	link, ok := f.links[tokenHash]
+	delete(f.links, tokenHash)
+	if !ok || !link.ExpiresAt.After(now) {
+		return nil, cerrors.NewNotFoundError("magic link")
+	}
+	return &link, nil
+}
+
+type fakeTokenIssuer struct{}
+
+func (fakeTokenIssuer) IssueTokens(_ context.Context, uid int64, appID int64, grant entities.Grant) (*entities.JwtTokenPair, error) {
+	return &entities.JwtTokenPair{
+		AuthToken:    fmt.Sprintf("auth-%d-%d-%s", uid, appID, grant.Type),
+		RefreshToken: "refresh",
+	}, nil
+}
+
+// fakeMailer passes sent messages to channel,
+// links are sent in background.
+type fakeMailer struct {
+	sent chan mailer.Message
+}
+
+func (f *fakeMailer) Send(_ context.Context, msg mailer.Message) error {
+	f.sent <- msg
+	return nil
+}
+
+// newService returns service with user 1 ann and app 1.
+func newService() (*magiclinkservice.MagicLinkService, *fakeStorage, *fakeMailer) {
+	storage := &fakeStorage{
+		users: map[string]*entities.User{
+			"ann@example.com": {UID: 1, Email: "ann@example.com"},
+		},
+		apps: map[int64]*entities.App{
+			1: {ID: 1, Name: "app"},
+		},
+		links: make(map[string]entities.MagicLink),
+	}
+	mail := &fakeMailer{sent: make(chan mailer.Message, 10)}
+
+	service := magiclinkservice.New(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		storage,
+		storage,
+		storage,
+		fakeTokenIssuer{},
+		mail,
+		linkTTL,
+		"http://localhost:3000/magic-link",
+		interval,
+	)
+
+	return service, storage, mail
+}
+
+var linkRegexp = regexp.MustCompile(`http://localhost:3000/magic-link\?\S+`)
+
+// receiveToken waits for mail with magic link and returns its token.
+func receiveToken(t *testing.T, mail *fakeMailer) string {
+	t.Helper()
+
+	select {
+	case msg := <-mail.sent:
+		assert.Equal(t, "ann@example.com", msg.To)
+		link, err := url.Parse(linkRegexp.FindString(msg.Body))
+		require.NoError(t, err)
+		return link.Query().Get("token")
+	case <-time.After(time.Second):
+		t.Fatal("magic link is not sent")
+		return ""
+	}
+}
+
+func request(service *magiclinkservice.MagicLinkService, email string) error {
+	return service.RequestMagicLink(context.Background(), dtos.RequestMagicLinkDto{Email: email, AppId: 1})
+}
+
+func consume(service *magiclinkservice.MagicLinkService, token string) (*entities.JwtTokenPair, error) {
+	return service.ConsumeMagicLink(context.Background(), dtos.ConsumeMagicLinkDto{Token: token})
+}
+
+func TestMagicLink_SingleUse(t *testing.T) {
+	service, storage, mail := newService()
+
+	require.NoError(t, request(service, "ann@example.com"))
+	token := receiveToken(t, mail)
+	require.NotEmpty(t, token)
+
+	// Only hash of token is stored
+	assert.NotContains(t, storage.links, token)
+
+	tokens, err := consume(service, token)
+	require.NoError(t, err)
+	assert.Equal(t, "auth-1-1-magic_link", tokens.AuthToken)
+
+	var itErr cerrors.InvalidTokenError
+	_, err = consume(service, token)
+	assert.ErrorAs(t, err, &itErr)
+}
+
+func TestMagicLink_Expired(t *testing.T) {
+	service, storage, mail := newService()
+
+	require.NoError(t, request(service, "ann@example.com"))
+	token := receiveToken(t, mail)
+
+	for hash, link := range storage.links {
+		link.ExpiresAt = time.Now().Add(-time.Second)
+		storage.links[hash] = link
+	}
+
+	var itErr cerrors.InvalidTokenError
+	_, err := consume(service, token)
+	assert.ErrorAs(t, err, &itErr)
+	assert.Empty(t, storage.links)
+}
+
+func TestMagicLink_UnknownEmail(t *testing.T) {
+	service, storage, mail := newService()
+
+	// Same response as for registered email
+	require.NoError(t, request(service, "bob@example.com"))
+	assert.Empty(t, storage.links)
+
+	select {
+	case <-mail.sent:
+		t.Fatal("mail sent to unknown email")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMagicLink_RequestInterval(t *testing.T) {
+	service, storage, mail := newService()
+
+	require.NoError(t, request(service, "ann@example.com"))
+	first := receiveToken(t, mail)
+
+	// Same response, but no more mail within interval
+	require.NoError(t, request(service, "ann@example.com"))
+	select {
+	case <-mail.sent:
+		t.Fatal("mail sent again within interval")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// After interval new link replaces the first one
+	for hash, link := range storage.links {
+		link.CreatedAt = link.CreatedAt.Add(-interval)
+		storage.links[hash] = link
+	}
+	require.NoError(t, request(service, "ann@example.com"))
+	second := receiveToken(t, mail)
+
+	var itErr cerrors.InvalidTokenError
+	_, err := consume(service, first)
+	assert.ErrorAs(t, err, &itErr)
+	_, err = consume(service, second)
+	assert.NoError(t, err)
+}
+
+func TestMagicLink_UnknownApp(t *testing.T) {
+	service, _, _ := newService()
+
+	err := service.RequestMagicLink(context.Background(), dtos.RequestMagicLinkDto{
+		Email: "ann@example.com",
+		AppId: 2,
+	})
+	var nfErr cerrors.NotFoundError
+	assert.ErrorAs(t, err, &nfErr)
+}
@@ -0,0 +1,425 @@
+package organizationservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/emailaddr"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/sl"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/mailer"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/onetime"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/tracing"
+)
+
+type UserProvider interface {
+	GetUserByEmail(
+		ctx context.Context,
+		email string,
+	) (*entities.User, error)
+}
+
+type OrganizationSaver interface {
+	SaveOrganization(
+		ctx context.Context,
+		org *entities.Organization,
+		ownerID int64,
+	) (int64, error)
+}
+
+type MembershipStorage interface {
+	GetMembership(
+		ctx context.Context,
+		orgID int64,
+		uid int64,
+	) (*entities.Membership, error)
+	GetMemberships(
+		ctx context.Context,
+		uid int64,
+	) ([]entities.Membership, error)
+	GetMembers(
+		ctx context.Context,
+		orgID int64,
+	) ([]entities.Membership, error)
+	DeleteMembership(
+		ctx context.Context,
+		orgID int64,
+		uid int64,
+	) error
+}
+
+type InvitationStorage interface {
+	SaveInvitation(
+		ctx context.Context,
+		inv *entities.Invitation,
+	) error
+	AcceptInvitation(
+		ctx context.Context,
+		tokenHash string,
+		uid int64,
+		now time.Time,
+	) (*entities.Membership, error)
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg mailer.Message) error
+}
+
+type OrganizationService struct {
+	log           *slog.Logger
+	userProvider  UserProvider
+	orgSaver      OrganizationSaver
+	memberships   MembershipStorage
+	invitations   InvitationStorage
+	mailer        Mailer
+	invitationTTL time.Duration
+	invitationURL string
+}
+
+// New returns new OrganizationService instance.
+// Invitation links sent by email point to invitationURL
+// and expire after invitationTTL.
+func New(
+	log *slog.Logger,
+	userProvider UserProvider,
+	orgSaver OrganizationSaver,
+	memberships MembershipStorage,
+	invitations InvitationStorage,
+	mailer Mailer,
+	invitationTTL time.Duration,
+	invitationURL string,
+) *OrganizationService {
+	return &OrganizationService{
+		log:           log,
+		userProvider:  userProvider,
+		orgSaver:      orgSaver,
+		memberships:   memberships,
+		invitations:   invitations,
+		mailer:        mailer,
+		invitationTTL: invitationTTL,
+		invitationURL: invitationURL,
+	}
+}
+
+// CreateOrganization creates organization owned by user who creates it.
+func (o *OrganizationService) CreateOrganization(
+	ctx context.Context,
+	dto dtos.CreateOrganizationDto,
+) (_ *entities.Organization, err error) {
+	const op = "organizationservice.CreateOrganization"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := o.log.With(slog.String("op", op), slog.Int64("uid", dto.UID))
+
+	org := &entities.Organization{
+		Name:      dto.Name,
+		CreatedAt: time.Now(),
+	}
+
+	org.ID, err = o.orgSaver.SaveOrganization(ctx, org, dto.UID)
+	if err != nil {
+		var aeErr cerrors.AlreadyExistsError
+		if errors.As(err, &aeErr) {
+			log.WarnContext(ctx, "organization already exists", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to save organization", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "organization created", slog.Int64("org_id", org.ID))
+
+	return org, nil
+}
+
+// Organizations returns memberships of user, the earliest first.
+func (o *OrganizationService) Organizations(
+	ctx context.Context,
+	uid int64,
+) (_ []entities.Membership, err error) {
+	const op = "organizationservice.Organizations"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	memberships, err := o.memberships.GetMemberships(ctx, uid)
+	if err != nil {
+		o.log.ErrorContext(ctx, "failed to get memberships", slog.String("op", op), sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return memberships, nil
+}
+
+// Members returns members of organization. Only members see them.
+func (o *OrganizationService) Members(
+	ctx context.Context,
+	uid int64,
+	orgID int64,
+) (_ []entities.Membership, err error) {
+	const op = "organizationservice.Members"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := o.log.With(slog.String("op", op), slog.Int64("uid", uid), slog.Int64("org_id", orgID))
+
+	if _, err := o.membership(ctx, log, orgID, uid); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	members, err := o.memberships.GetMembers(ctx, orgID)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get members", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return members, nil
+}
+
+// InviteMember invites user by email to organization. Only owners
+// invite members. Invited user joins by accepting invitation sent
+// by email.
+//
+// Unknown email and user being a member already are not errors,
+// and email is sent in background, so neither response nor its timing
+// reveals whether email is registered.
+func (o *OrganizationService) InviteMember(
+	ctx context.Context,
+	dto dtos.InviteMemberDto,
+) (err error) {
+	const op = "organizationservice.InviteMember"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := o.log.With(slog.String("op", op), slog.Int64("uid", dto.UID), slog.Int64("org_id", dto.OrgID))
+
+	owner, err := o.membership(ctx, log, dto.OrgID, dto.UID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if owner.Role != entities.RoleOwner {
+		log.WarnContext(ctx, "member is not owner")
+		return fmt.Errorf("%s: %w", op, cerrors.NewPermissionDeniedError("only owners invite members"))
+	}
+
+	email, err := emailaddr.Canonical(dto.Email)
+	if err != nil {
+		log.WarnContext(ctx, "invalid email", sl.Err(err))
+		return nil
+	}
+
+	usr, err := o.userProvider.GetUserByEmail(ctx, email)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "user not found", sl.Err(err))
+			return nil
+		}
+		log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Uint64("invitee_id", usr.UID))
+
+	_, err = o.memberships.GetMembership(ctx, dto.OrgID, int64(usr.UID))
+	if err == nil {
+		log.WarnContext(ctx, "user is already a member")
+		return nil
+	}
+	var nfErr cerrors.NotFoundError
+	if !errors.As(err, &nfErr) {
+		log.ErrorContext(ctx, "failed to get membership from storage", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	role := dto.Role
+	if role == "" {
+		role = entities.RoleMember
+	}
+
+	token, tokenHash, err := onetime.New()
+	if err != nil {
+		log.ErrorContext(ctx, "failed to generate token", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, cerrors.NewCriticalInternalError("onetime.New", err))
+	}
+
+	now := time.Now()
+	err = o.invitations.SaveInvitation(ctx, &entities.Invitation{
+		TokenHash: tokenHash,
+		OrgID:     dto.OrgID,
+		UserID:    int64(usr.UID),
+		Role:      role,
+		InvitedBy: dto.UID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(o.invitationTTL),
+	})
+	if err != nil {
+		log.ErrorContext(ctx, "failed to save invitation", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Sent in background, otherwise mail server round trip would make
+	// response for registered email slower than for unknown one
+	go o.sendInvitation(context.WithoutCancel(ctx), log, usr, owner.OrgName, token)
+
+	log.InfoContext(ctx, "member invited")
+
+	return nil
+}
+
+// sendInvitation mails invitation link to user. Invitation is already
+// saved, so failure is only logged, owner can invite user again.
+func (o *OrganizationService) sendInvitation(
+	ctx context.Context,
+	log *slog.Logger,
+	usr *entities.User,
+	orgName string,
+	token string,
+) {
+	err := o.mailer.Send(ctx, mailer.Message{
+		To:      usr.Email,
+		Subject: fmt.Sprintf("You are invited to %s", orgName),
+		Body: fmt.Sprintf(
+			"You are invited to join organization %s. Follow the link to accept the invitation:\n\n%s\n\n"+
+				"The link works once and expires in %s. If you don't want to join, ignore this message.\n",
+			orgName,
+			onetime.Link(o.invitationURL, token),
+			o.invitationTTL,
+		),
+	})
+	if err != nil {
+		log.ErrorContext(ctx, "failed to send invitation", sl.Err(err))
+		return
+	}
+
+	log.DebugContext(ctx, "invitation sent")
+}
+
+// AcceptInvitation makes user member of organization by token from
+// invitation link. Only the invited user accepts the invitation.
+func (o *OrganizationService) AcceptInvitation(
+	ctx context.Context,
+	dto dtos.AcceptInvitationDto,
+) (_ *entities.Membership, err error) {
+	const op = "organizationservice.AcceptInvitation"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := o.log.With(slog.String("op", op), slog.Int64("uid", dto.UID))
+
+	m, err := o.invitations.AcceptInvitation(ctx, onetime.Hash(dto.Token), dto.UID, time.Now())
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "invitation not found", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat))
+		}
+		var aeErr cerrors.AlreadyExistsError
+		if errors.As(err, &aeErr) {
+			log.WarnContext(ctx, "user is already a member", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to accept invitation", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "invitation accepted", slog.Int64("org_id", m.OrgID))
+
+	return m, nil
+}
+
+// RemoveMember removes user from organization. Owners remove anyone,
+// other members only leave themselves. The last owner can't leave.
+func (o *OrganizationService) RemoveMember(
+	ctx context.Context,
+	dto dtos.RemoveMemberDto,
+) (err error) {
+	const op = "organizationservice.RemoveMember"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := o.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", dto.UID),
+		slog.Int64("org_id", dto.OrgID),
+		slog.Int64("member_id", dto.MemberID),
+	)
+
+	actor, err := o.membership(ctx, log, dto.OrgID, dto.UID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if actor.Role != entities.RoleOwner && dto.MemberID != dto.UID {
+		log.WarnContext(ctx, "member is not owner")
+		return fmt.Errorf("%s: %w", op, cerrors.NewPermissionDeniedError("only owners remove other members"))
+	}
+
+	members, err := o.memberships.GetMembers(ctx, dto.OrgID)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get members", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	owners, removedOwner := 0, false
+	for _, m := range members {
+		if m.Role != entities.RoleOwner {
+			continue
+		}
+		owners++
+		if m.UserID == dto.MemberID {
+			removedOwner = true
+		}
+	}
+	if removedOwner && owners == 1 {
+		log.WarnContext(ctx, "last owner can't leave")
+		return fmt.Errorf("%s: %w", op, cerrors.NewPermissionDeniedError("organization must keep an owner"))
+	}
+
+	if err := o.memberships.DeleteMembership(ctx, dto.OrgID, dto.MemberID); err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "member not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to delete membership", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "member removed")
+
+	return nil
+}
+
+// membership returns membership of user acting on organization.
+// Non-members get PermissionDeniedError whether organization
+// exists or not.
+func (o *OrganizationService) membership(
+	ctx context.Context,
+	log *slog.Logger,
+	orgID int64,
+	uid int64,
+) (*entities.Membership, error) {
+	m, err := o.memberships.GetMembership(ctx, orgID, uid)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "user is not a member", sl.Err(err))
+			return nil, cerrors.NewPermissionDeniedError("not a member of organization")
+		}
+		log.ErrorContext(ctx, "failed to get membership from storage", sl.Err(err))
+		return nil, err
+	}
+
+	return m, nil
+}
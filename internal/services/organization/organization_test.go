@@ -0,0 +1,352 @@
+package organizationservice_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/mailer"
+	organizationservice "github.com/Woland-prj/microtasks_sso/internal/services/organization"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type membershipKey struct {
+	orgID int64
+	uid   int64
+}
+
+const invitationTTL = 72 * time.Hour
+
+// fakeStorage keeps users, organizations, memberships
+// and invitations in memory.
+type fakeStorage struct {
+	users       map[string]*entities.User
+	orgs        map[int64]string
+	memberships map[membershipKey]entities.Membership
+	invitations map[string]entities.Invitation
+}
+
+func (f *fakeStorage) GetUserByEmail(_ context.Context, email string) (*entities.User, error) {
+	if usr, ok := f.users[email]; ok {
+		return usr, nil
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("user %s", email))
+}
+
+func (f *fakeStorage) SaveOrganization(_ context.Context, org *entities.Organization, ownerID int64) (int64, error) {
+	for _, name := range f.orgs {
+		if name == org.Name {
+			return 0, cerrors.NewAlreadyExistsError(fmt.Sprintf("organization %s", org.Name))
+		}
+	}
+	id := int64(len(f.orgs) + 1)
+	f.orgs[id] = org.Name
+	f.memberships[membershipKey{id, ownerID}] = entities.Membership{
+		OrgID:  id,
+		UserID: ownerID,
+		Role:   entities.RoleOwner,
+	}
+	return id, nil
+}
+
+func (f *fakeStorage) GetMembership(_ context.Context, orgID int64, uid int64) (*entities.Membership, error) {
+	m, ok := f.memberships[membershipKey{orgID, uid}]
+	if !ok {
+		return nil, cerrors.NewNotFoundError(fmt.Sprintf("membership %d", orgID))
+	}
+	m.OrgName = f.orgs[orgID]
+	return &m, nil
+}
+
+func (f *fakeStorage) GetMemberships(_ context.Context, uid int64) ([]entities.Membership, error) {
+	var memberships []entities.Membership
+	for key, m := range f.memberships {
+		if key.uid == uid {
+			memberships = append(memberships, m)
+		}
+	}
+	return memberships, nil
+}
+
+func (f *fakeStorage) GetMembers(_ context.Context, orgID int64) ([]entities.Membership, error) {
+	var members []entities.Membership
+	for key, m := range f.memberships {
+		if key.orgID == orgID {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+func (f *fakeStorage) SaveInvitation(_ context.Context, inv *entities.Invitation) error {
+	for hash, i := range f.invitations {
+		if i.OrgID == inv.OrgID && i.UserID == inv.UserID {
+			delete(f.invitations, hash)
+		}
+	}
+	f.invitations[inv.TokenHash] = *inv
+	return nil
+}
+
+func (f *fakeStorage) AcceptInvitation(
+	_ context.Context,
+	tokenHash string,
+	uid int64,
+	now time.Time,
+) (*entities.Membership, error) {
+	inv, ok := f.invitations[tokenHash]
+	if !ok || inv.UserID != uid || !inv.ExpiresAt.After(now) {
+		return nil, cerrors.NewNotFoundError("invitation")
+	}
+	delete(f.invitations, tokenHash)
+
+	key := membershipKey{inv.OrgID, uid}
+	if _, ok := f.memberships[key]; ok {
+		return nil, cerrors.NewAlreadyExistsError(fmt.Sprintf("member %d", uid))
+	}
+	m := entities.Membership{OrgID: inv.OrgID, OrgName: f.orgs[inv.OrgID], UserID: uid, Role: inv.Role, CreatedAt: now}
+	f.memberships[key] = m
+	return &m, nil
+}
+
+func (f *fakeStorage) DeleteMembership(_ context.Context, orgID int64, uid int64) error {
+	key := membershipKey{orgID, uid}
+	if _, ok := f.memberships[key]; !ok {
+		return cerrors.NewNotFoundError(fmt.Sprintf("member %d", uid))
+	}
+	delete(f.memberships, key)
+	return nil
+}
+
+// fakeMailer passes sent messages to channel,
+// invitations are sent in background.
+type fakeMailer struct {
+	sent chan mailer.Message
+}
+
+func (f *fakeMailer) Send(_ context.Context, msg mailer.Message) error {
+	f.sent <- msg
+	return nil
+}
+
+// newService returns service with users 1 ann, 2 bob and 3 eve
+// and organization 1 owned by ann.
+func newService(t *testing.T) (*organizationservice.OrganizationService, *fakeStorage, *fakeMailer) {
+	storage := &fakeStorage{
+		users: map[string]*entities.User{
+			"ann@example.com": {UID: 1, Email: "ann@example.com"},
+			"bob@example.com": {UID: 2, Email: "bob@example.com"},
+			"eve@example.com": {UID: 3, Email: "eve@example.com"},
+		},
+		orgs:        make(map[int64]string),
+		memberships: make(map[membershipKey]entities.Membership),
+		invitations: make(map[string]entities.Invitation),
+	}
+	mail := &fakeMailer{sent: make(chan mailer.Message, 10)}
+
+	service := organizationservice.New(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		storage,
+		storage,
+		storage,
+		storage,
+		mail,
+		invitationTTL,
+		"http://localhost:3000/invitations/accept",
+	)
+
+	org, err := service.CreateOrganization(context.Background(), dtos.CreateOrganizationDto{UID: 1, Name: "acme"})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), org.ID)
+
+	return service, storage, mail
+}
+
+var linkRegexp = regexp.MustCompile(`http://localhost:3000/invitations/accept\?\S+`)
+
+// receiveToken waits for invitation sent to email and returns its token.
+func receiveToken(t *testing.T, mail *fakeMailer, email string) string {
+	t.Helper()
+
+	select {
+	case msg := <-mail.sent:
+		assert.Equal(t, email, msg.To)
+		link, err := url.Parse(linkRegexp.FindString(msg.Body))
+		require.NoError(t, err)
+		return link.Query().Get("token")
+	case <-time.After(time.Second):
+		t.Fatal("invitation is not sent")
+		return ""
+	}
+}
+
+func assertNotSent(t *testing.T, mail *fakeMailer) {
+	t.Helper()
+
+	select {
+	case msg := <-mail.sent:
+		t.Fatalf("invitation sent to %s", msg.To)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// invite has ann invite user by email and user accept the invitation.
+func invite(t *testing.T, service *organizationservice.OrganizationService, mail *fakeMailer, uid int64, email string) {
+	t.Helper()
+	ctx := context.Background()
+
+	require.NoError(t, service.InviteMember(ctx, dtos.InviteMemberDto{UID: 1, OrgID: 1, Email: email}))
+	token := receiveToken(t, mail, email)
+	_, err := service.AcceptInvitation(ctx, dtos.AcceptInvitationDto{UID: uid, Token: token})
+	require.NoError(t, err)
+}
+
+func TestOrganization_CreatorIsOwner(t *testing.T) {
+	service, _, _ := newService(t)
+
+	memberships, err := service.Organizations(context.Background(), 1)
+	require.NoError(t, err)
+	require.Len(t, memberships, 1)
+	assert.Equal(t, entities.RoleOwner, memberships[0].Role)
+
+	_, err = service.CreateOrganization(context.Background(), dtos.CreateOrganizationDto{UID: 2, Name: "acme"})
+	var aeErr cerrors.AlreadyExistsError
+	assert.ErrorAs(t, err, &aeErr)
+}
+
+func TestOrganization_InviteMember(t *testing.T) {
+	service, storage, mail := newService(t)
+	ctx := context.Background()
+
+	err := service.InviteMember(ctx, dtos.InviteMemberDto{UID: 1, OrgID: 1, Email: "bob@example.com"})
+	require.NoError(t, err)
+	token := receiveToken(t, mail, "bob@example.com")
+
+	// Invited user isn't a member until accepting
+	var pdErr cerrors.PermissionDeniedError
+	_, err = service.Members(ctx, 2, 1)
+	assert.ErrorAs(t, err, &pdErr)
+
+	// Only hash of token is stored
+	assert.NotContains(t, storage.invitations, token)
+
+	member, err := service.AcceptInvitation(ctx, dtos.AcceptInvitationDto{UID: 2, Token: token})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), member.UserID)
+	assert.Equal(t, entities.RoleMember, member.Role)
+	assert.Equal(t, "acme", member.OrgName)
+
+	members, err := service.Members(ctx, 2, 1)
+	require.NoError(t, err)
+	assert.Len(t, members, 2)
+
+	// Plain members don't invite members
+	err = service.InviteMember(ctx, dtos.InviteMemberDto{UID: 2, OrgID: 1, Email: "eve@example.com"})
+	assert.ErrorAs(t, err, &pdErr)
+
+	// Outsiders neither invite nor see members
+	err = service.InviteMember(ctx, dtos.InviteMemberDto{UID: 3, OrgID: 1, Email: "eve@example.com"})
+	assert.ErrorAs(t, err, &pdErr)
+	_, err = service.Members(ctx, 3, 1)
+	assert.ErrorAs(t, err, &pdErr)
+	assert.Len(t, storage.memberships, 2)
+	assertNotSent(t, mail)
+}
+
+func TestOrganization_InviteMember_SameResponse(t *testing.T) {
+	service, storage, mail := newService(t)
+	ctx := context.Background()
+	invite(t, service, mail, 2, "bob@example.com")
+
+	// Members and unknown emails get the same response as others,
+	// but no invitation
+	for _, email := range []string{"bob@example.com", "nobody@example.com"} {
+		err := service.InviteMember(ctx, dtos.InviteMemberDto{UID: 1, OrgID: 1, Email: email})
+		assert.NoError(t, err, email)
+	}
+	assertNotSent(t, mail)
+	assert.Empty(t, storage.invitations)
+}
+
+func TestOrganization_AcceptInvitation(t *testing.T) {
+	service, storage, mail := newService(t)
+	ctx := context.Background()
+
+	err := service.InviteMember(ctx, dtos.InviteMemberDto{
+		UID:   1,
+		OrgID: 1,
+		Email: "bob@example.com",
+		Role:  entities.RoleOwner,
+	})
+	require.NoError(t, err)
+	token := receiveToken(t, mail, "bob@example.com")
+
+	var itErr cerrors.InvalidTokenError
+
+	// Other users can't use the link
+	_, err = service.AcceptInvitation(ctx, dtos.AcceptInvitationDto{UID: 3, Token: token})
+	assert.ErrorAs(t, err, &itErr)
+
+	member, err := service.AcceptInvitation(ctx, dtos.AcceptInvitationDto{UID: 2, Token: token})
+	require.NoError(t, err)
+	assert.Equal(t, entities.RoleOwner, member.Role)
+
+	// Link works once
+	_, err = service.AcceptInvitation(ctx, dtos.AcceptInvitationDto{UID: 2, Token: token})
+	assert.ErrorAs(t, err, &itErr)
+	assert.Len(t, storage.memberships, 2)
+}
+
+func TestOrganization_AcceptInvitation_Expired(t *testing.T) {
+	service, storage, mail := newService(t)
+	ctx := context.Background()
+
+	err := service.InviteMember(ctx, dtos.InviteMemberDto{UID: 1, OrgID: 1, Email: "bob@example.com"})
+	require.NoError(t, err)
+	token := receiveToken(t, mail, "bob@example.com")
+
+	for hash, inv := range storage.invitations {
+		assert.WithinDuration(t, time.Now().Add(invitationTTL), inv.ExpiresAt, time.Minute)
+		inv.ExpiresAt = time.Now().Add(-time.Second)
+		storage.invitations[hash] = inv
+	}
+
+	var itErr cerrors.InvalidTokenError
+	_, err = service.AcceptInvitation(ctx, dtos.AcceptInvitationDto{UID: 2, Token: token})
+	assert.ErrorAs(t, err, &itErr)
+	assert.Len(t, storage.memberships, 1)
+}
+
+func TestOrganization_RemoveMember(t *testing.T) {
+	service, storage, mail := newService(t)
+	ctx := context.Background()
+
+	invite(t, service, mail, 2, "bob@example.com")
+	invite(t, service, mail, 3, "eve@example.com")
+
+	var pdErr cerrors.PermissionDeniedError
+
+	// Members only leave themselves
+	err := service.RemoveMember(ctx, dtos.RemoveMemberDto{UID: 2, OrgID: 1, MemberID: 3})
+	assert.ErrorAs(t, err, &pdErr)
+	err = service.RemoveMember(ctx, dtos.RemoveMemberDto{UID: 2, OrgID: 1, MemberID: 2})
+	require.NoError(t, err)
+
+	// Owners remove anyone
+	err = service.RemoveMember(ctx, dtos.RemoveMemberDto{UID: 1, OrgID: 1, MemberID: 3})
+	require.NoError(t, err)
+
+	// but the last owner stays
+	err = service.RemoveMember(ctx, dtos.RemoveMemberDto{UID: 1, OrgID: 1, MemberID: 1})
+	assert.ErrorAs(t, err, &pdErr)
+	assert.Len(t, storage.memberships, 1)
+}
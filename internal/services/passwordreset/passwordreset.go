@@ -0,0 +1,235 @@
+package passwordresetservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/sl"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/mailer"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/metrics"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/onetime"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/tracing"
+)
+
+type UserProvider interface {
+	GetUserByEmail(
+		ctx context.Context,
+		email string,
+	) (*entities.User, error)
+}
+
+type ResetStorage interface {
+	SavePasswordReset(
+		ctx context.Context,
+		reset *entities.PasswordReset,
+		interval time.Duration,
+	) error
+	GetPasswordReset(
+		ctx context.Context,
+		tokenHash string,
+		now time.Time,
+	) (*entities.PasswordReset, error)
+	DeletePasswordResets(
+		ctx context.Context,
+		uid int64,
+	) error
+}
+
+// PasswordSetter replaces password of user checking it against
+// password policy.
+type PasswordSetter interface {
+	SetPassword(
+		ctx context.Context,
+		uid int64,
+		password string,
+	) error
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg mailer.Message) error
+}
+
+type PasswordResetService struct {
+	log            *slog.Logger
+	userProvider   UserProvider
+	resetStorage   ResetStorage
+	passwordSetter PasswordSetter
+	mailer         Mailer
+	linkTTL        time.Duration
+	linkURL        string
+	interval       time.Duration
+}
+
+// New returns new PasswordResetService instance.
+// Links sent by email point to linkURL and expire after linkTTL.
+// User gets at most one link per interval.
+func New(
+	log *slog.Logger,
+	userProvider UserProvider,
+	resetStorage ResetStorage,
+	passwordSetter PasswordSetter,
+	mailer Mailer,
+	linkTTL time.Duration,
+	linkURL string,
+	interval time.Duration,
+) *PasswordResetService {
+	return &PasswordResetService{
+		log:            log,
+		userProvider:   userProvider,
+		resetStorage:   resetStorage,
+		passwordSetter: passwordSetter,
+		mailer:         mailer,
+		linkTTL:        linkTTL,
+		linkURL:        linkURL,
+		interval:       interval,
+	}
+}
+
+// RequestPasswordReset sends single-use password reset link
+// to user email.
+//
+// Unknown email and link requested again within interval are not errors,
+// and email is sent in background, so neither response nor its timing
+// reveals whether email is registered.
+func (p *PasswordResetService) RequestPasswordReset(
+	ctx context.Context,
+	dto dtos.RequestPasswordResetDto,
+) (err error) {
+	const op = "passwordresetservice.RequestPasswordReset"
+
+	defer metrics.ObserveAuth("password_reset_request", 0, time.Now(), &err)
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := p.log.With(slog.String("op", op))
+	log.DebugContext(ctx, "requesting password reset")
+
+	usr, err := p.userProvider.GetUserByEmail(ctx, dto.Email)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "user not found", sl.Err(err))
+			return nil
+		}
+		log.ErrorContext(ctx, "failed to get user from storage", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, tokenHash, err := onetime.New()
+	if err != nil {
+		log.ErrorContext(ctx, "failed to generate token", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, cerrors.NewCriticalInternalError("onetime.New", err))
+	}
+
+	now := time.Now()
+	err = p.resetStorage.SavePasswordReset(ctx, &entities.PasswordReset{
+		TokenHash: tokenHash,
+		UserID:    int64(usr.UID),
+		CreatedAt: now,
+		ExpiresAt: now.Add(p.linkTTL),
+	}, p.interval)
+	if err != nil {
+		var aeErr cerrors.AlreadyExistsError
+		if errors.As(err, &aeErr) {
+			log.WarnContext(ctx, "password reset requested too often", slog.Uint64("uid", usr.UID))
+			return nil
+		}
+		log.ErrorContext(ctx, "failed to save password reset", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Sent in background, otherwise mail server round trip would make
+	// response for registered email slower than for unknown one
+	go p.sendLink(context.WithoutCancel(ctx), log, usr, token)
+
+	return nil
+}
+
+// sendLink mails password reset link to user. Reset is already saved,
+// so failure is only logged, user can request another link.
+func (p *PasswordResetService) sendLink(
+	ctx context.Context,
+	log *slog.Logger,
+	usr *entities.User,
+	token string,
+) {
+	err := p.mailer.Send(ctx, mailer.Message{
+		To:      usr.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Follow the link to choose a new password:\n\n%s\n\nThe link works once and expires in %s. "+
+				"If you didn't request it, ignore this message, your password stays the same.\n",
+			onetime.Link(p.linkURL, token),
+			p.linkTTL,
+		),
+	})
+	if err != nil {
+		log.ErrorContext(ctx, "failed to send password reset link", sl.Err(err))
+		return
+	}
+
+	log.DebugContext(ctx, "password reset link sent", slog.Uint64("uid", usr.UID))
+}
+
+// ResetPassword replaces password of user by token from reset link.
+//
+// New password must satisfy password policy. Link stays valid if
+// password is rejected, so user can choose another one.
+func (p *PasswordResetService) ResetPassword(
+	ctx context.Context,
+	dto dtos.ResetPasswordDto,
+) (err error) {
+	const op = "passwordresetservice.ResetPassword"
+
+	defer metrics.ObserveAuth("password_reset", 0, time.Now(), &err)
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := p.log.With(slog.String("op", op))
+	log.DebugContext(ctx, "resetting password")
+
+	reset, err := p.resetStorage.GetPasswordReset(ctx, onetime.Hash(dto.Token), time.Now())
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "password reset not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat))
+		}
+		log.ErrorContext(ctx, "failed to get password reset", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", reset.UserID))
+
+	if err := p.passwordSetter.SetPassword(ctx, reset.UserID, dto.Password); err != nil {
+		// User is deleted since link was sent
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			return fmt.Errorf("%s: %w", op, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat))
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := p.resetStorage.DeletePasswordResets(ctx, reset.UserID); err != nil {
+		// Link was used concurrently, password is set by either request
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "password reset used concurrently")
+			return nil
+		}
+		log.ErrorContext(ctx, "failed to delete password reset", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "password reset")
+
+	return nil
+}
@@ -0,0 +1,245 @@
+package passwordresetservice_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/mailer"
+	passwordresetservice "github.com/Woland-prj/microtasks_sso/internal/services/passwordreset"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	linkTTL  = time.Hour
+	interval = time.Minute
+)
+
+// fakeStorage keeps users and password resets in memory,
+// a reset per user like sqlite storage does.
+type fakeStorage struct {
+	users     map[string]*entities.User
+	resets    map[string]entities.PasswordReset
+	passwords map[int64]string
+}
+
+func (f *fakeStorage) GetUserByEmail(_ context.Context, email string) (*entities.User, error) {
+	if usr, ok := f.users[email]; ok {
+		return usr, nil
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("user %s", email))
+}
+
+func (f *fakeStorage) SavePasswordReset(_ context.Context, reset *entities.PasswordReset, interval time.Duration) error {
+	for hash, r := range f.resets {
+		if r.UserID != reset.UserID {
+			continue
+		}
+		if r.CreatedAt.After(reset.CreatedAt.Add(-interval)) {
+			return cerrors.NewAlreadyExistsError("recent password reset")
+		}
+		delete(f.resets, hash)
+	}
+	f.resets[reset.TokenHash] = *reset
+	return nil
+}
+
+func (f *fakeStorage) GetPasswordReset(_ context.Context, tokenHash string, now time.Time) (*entities.PasswordReset, error) {
+	reset, ok := f.resets[tokenHash]
+	if !ok || !reset.ExpiresAt.After(now) {
+		return nil, cerrors.NewNotFoundError("password reset")
+	}
+	return &reset, nil
+}
+
+func (f *fakeStorage) DeletePasswordResets(_ context.Context, uid int64) error {
+	found := false
+	for hash, r := range f.resets {
+		if r.UserID == uid {
+			delete(f.resets, hash)
+			found = true
+		}
+	}
+	if !found {
+		return cerrors.NewNotFoundError("password reset")
+	}
+	return nil
+}
+
+// SetPassword rejects passwords shorter than 8 characters,
+// standing for password policy of auth service.
+func (f *fakeStorage) SetPassword(_ context.Context, uid int64, password string) error {
+	if len(password) < 8 {
+		return cerrors.NewValidationError("Weak password", cerrors.FieldViolation{
+			Field:       "password",
+			Description: "must be at least 8 characters long",
+		})
+	}
+	f.passwords[uid] = password
+	return nil
+}
+
+// fakeMailer passes sent messages to channel,
+// links are sent in background.
+type fakeMailer struct {
+	sent chan mailer.Message
+}
+
+func (f *fakeMailer) Send(_ context.Context, msg mailer.Message) error {
+	f.sent <- msg
+	return nil
+}
+
+// newService returns service with user 1 ann.
+func newService() (*passwordresetservice.PasswordResetService, *fakeStorage, *fakeMailer) {
+	storage := &fakeStorage{
+		users: map[string]*entities.User{
+			"ann@example.com": {UID: 1, Email: "ann@example.com"},
+		},
+		resets:    make(map[string]entities.PasswordReset),
+		passwords: make(map[int64]string),
+	}
+	mail := &fakeMailer{sent: make(chan mailer.Message, 10)}
+
+	service := passwordresetservice.New(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		storage,
+		storage,
+		storage,
+		mail,
+		linkTTL,
+		"http://localhost:3000/password/reset",
+		interval,
+	)
+
+	return service, storage, mail
+}
+
+var linkRegexp = regexp.MustCompile(`http://localhost:3000/password/reset\?\S+`)
+
+// receiveToken waits for mail with reset link and returns its token.
+func receiveToken(t *testing.T, mail *fakeMailer) string {
+	t.Helper()
+
+	select {
+	case msg := <-mail.sent:
+		assert.Equal(t, "ann@example.com", msg.To)
+		link, err := url.Parse(linkRegexp.FindString(msg.Body))
+		require.NoError(t, err)
+		return link.Query().Get("token")
+	case <-time.After(time.Second):
+		t.Fatal("password reset link is not sent")
+		return ""
+	}
+}
+
+func request(service *passwordresetservice.PasswordResetService, email string) error {
+	return service.RequestPasswordReset(context.Background(), dtos.RequestPasswordResetDto{Email: email})
+}
+
+func reset(service *passwordresetservice.PasswordResetService, token string, password string) error {
+	return service.ResetPassword(context.Background(), dtos.ResetPasswordDto{Token: token, Password: password})
+}
+
+func TestPasswordReset_SingleUse(t *testing.T) {
+	service, storage, mail := newService()
+
+	require.NoError(t, request(service, "ann@example.com"))
+	token := receiveToken(t, mail)
+	require.NotEmpty(t, token)
+
+	// Only hash of token is stored
+	assert.NotContains(t, storage.resets, token)
+
+	require.NoError(t, reset(service, token, "new password"))
+	assert.Equal(t, "new password", storage.passwords[1])
+
+	var itErr cerrors.InvalidTokenError
+	err := reset(service, token, "another password")
+	assert.ErrorAs(t, err, &itErr)
+	assert.Equal(t, "new password", storage.passwords[1])
+}
+
+func TestPasswordReset_PolicyViolation(t *testing.T) {
+	service, storage, mail := newService()
+
+	require.NoError(t, request(service, "ann@example.com"))
+	token := receiveToken(t, mail)
+
+	var vErr cerrors.ValidationError
+	err := reset(service, token, "short")
+	require.ErrorAs(t, err, &vErr)
+	assert.Empty(t, storage.passwords)
+
+	// Link still works with password satisfying policy
+	require.NoError(t, reset(service, token, "long enough"))
+	assert.Equal(t, "long enough", storage.passwords[1])
+}
+
+func TestPasswordReset_Expired(t *testing.T) {
+	service, storage, mail := newService()
+
+	require.NoError(t, request(service, "ann@example.com"))
+	token := receiveToken(t, mail)
+
+	for hash, r := range storage.resets {
+		r.ExpiresAt = time.Now().Add(-time.Second)
+		storage.resets[hash] = r
+	}
+
+	var itErr cerrors.InvalidTokenError
+	err := reset(service, token, "new password")
+	assert.ErrorAs(t, err, &itErr)
+	assert.Empty(t, storage.passwords)
+}
+
+func TestPasswordReset_UnknownEmail(t *testing.T) {
+	service, storage, mail := newService()
+
+	// Same response as for registered email
+	require.NoError(t, request(service, "bob@example.com"))
+	assert.Empty(t, storage.resets)
+
+	select {
+	case <-mail.sent:
+		t.Fatal("mail sent to unknown email")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPasswordReset_RequestInterval(t *testing.T) {
+	service, storage, mail := newService()
+
+	require.NoError(t, request(service, "ann@example.com"))
+	first := receiveToken(t, mail)
+
+	// Same response, but no more mail within interval
+	require.NoError(t, request(service, "ann@example.com"))
+	select {
+	case <-mail.sent:
+		t.Fatal("mail sent again within interval")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// After interval new link replaces the first one
+	for hash, r := range storage.resets {
+		r.CreatedAt = r.CreatedAt.Add(-interval)
+		storage.resets[hash] = r
+	}
+	require.NoError(t, request(service, "ann@example.com"))
+	second := receiveToken(t, mail)
+
+	var itErr cerrors.InvalidTokenError
+	err := reset(service, first, "new password")
+	assert.ErrorAs(t, err, &itErr)
+	assert.NoError(t, reset(service, second, "new password"))
+}
@@ -2,15 +2,40 @@ package services
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"time"
 
+	"github.com/Woland-prj/microtasks_sso/internal/config"
 	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/hasher"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/ldapdir"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/mailer"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/passpolicy"
+	accountservice "github.com/Woland-prj/microtasks_sso/internal/services/account"
+	appservice "github.com/Woland-prj/microtasks_sso/internal/services/app"
 	authservice "github.com/Woland-prj/microtasks_sso/internal/services/auth"
+	federationservice "github.com/Woland-prj/microtasks_sso/internal/services/federation"
+	healthservice "github.com/Woland-prj/microtasks_sso/internal/services/health"
+	ldapservice "github.com/Woland-prj/microtasks_sso/internal/services/ldap"
+	magiclinkservice "github.com/Woland-prj/microtasks_sso/internal/services/magiclink"
+	organizationservice "github.com/Woland-prj/microtasks_sso/internal/services/organization"
+	passwordresetservice "github.com/Woland-prj/microtasks_sso/internal/services/passwordreset"
+	webauthnservice "github.com/Woland-prj/microtasks_sso/internal/services/webauthn"
+	"github.com/go-webauthn/webauthn/webauthn"
 )
 
 type Services struct {
-	Auth *authservice.AuthService
+	Auth          *authservice.AuthService
+	App           *appservice.AppService
+	Account       *accountservice.AccountService
+	MagicLink     *magiclinkservice.MagicLinkService
+	PasswordReset *passwordresetservice.PasswordResetService
+	WebAuthn      *webauthnservice.WebAuthnService
+	Organization  *organizationservice.OrganizationService
+	// Federation is nil unless upstream provider is configured
+	Federation *federationservice.FederationService
+	Health     *healthservice.HealthService
 }
 
 type Storage interface {
@@ -19,11 +44,28 @@ type Storage interface {
 		user *entities.User,
 	) (int64, error)
 
+	UpdatePassHash(
+		ctx context.Context,
+		uid int64,
+		passHash string,
+	) error
+
+	UpdateProfile(
+		ctx context.Context,
+		uid int64,
+		profile entities.Profile,
+	) error
+
 	GetUserByEmail(
 		ctx context.Context,
 		email string,
 	) (*entities.User, error)
 
+	GetUserByUsername(
+		ctx context.Context,
+		username string,
+	) (*entities.User, error)
+
 	GetUserById(
 		ctx context.Context,
 		uid int64,
@@ -33,22 +75,410 @@ type Storage interface {
 		ctx context.Context,
 		id int64,
 	) (*entities.App, error)
+
+	GetAppByName(
+		ctx context.Context,
+		name string,
+	) (*entities.App, error)
+
+	SaveApp(
+		ctx context.Context,
+		app *entities.App,
+	) (int64, error)
+
+	SaveEmailChange(
+		ctx context.Context,
+		change *entities.EmailChange,
+	) error
+
+	ConfirmEmailChange(
+		ctx context.Context,
+		tokenHash string,
+		now time.Time,
+	) (string, *entities.EmailChange, error)
+
+	GetEmailChanges(
+		ctx context.Context,
+		uid int64,
+	) ([]entities.EmailChange, error)
+
+	SoftDeleteUser(
+		ctx context.Context,
+		uid int64,
+		at time.Time,
+	) error
+
+	PurgeDeletedUsers(
+		ctx context.Context,
+		before time.Time,
+	) (int64, error)
+
+	SaveMagicLink(
+		ctx context.Context,
+		link *entities.MagicLink,
+		interval time.Duration,
+	) error
+
+	ConsumeMagicLink(
+		ctx context.Context,
+		tokenHash string,
+		now time.Time,
+	) (*entities.MagicLink, error)
+
+	SavePasswordReset(
+		ctx context.Context,
+		reset *entities.PasswordReset,
+		interval time.Duration,
+	) error
+
+	GetPasswordReset(
+		ctx context.Context,
+		tokenHash string,
+		now time.Time,
+	) (*entities.PasswordReset, error)
+
+	DeletePasswordResets(
+		ctx context.Context,
+		uid int64,
+	) error
+
+	SaveWebAuthnCredential(
+		ctx context.Context,
+		cred *entities.WebAuthnCredential,
+	) error
+
+	GetWebAuthnCredentials(
+		ctx context.Context,
+		uid int64,
+	) ([]entities.WebAuthnCredential, error)
+
+	UpdateWebAuthnCredential(
+		ctx context.Context,
+		id []byte,
+		data []byte,
+		usedAt time.Time,
+	) error
+
+	DeleteWebAuthnCredential(
+		ctx context.Context,
+		uid int64,
+		id []byte,
+	) error
+
+	SaveWebAuthnSession(
+		ctx context.Context,
+		session *entities.WebAuthnSession,
+	) error
+
+	ConsumeWebAuthnSession(
+		ctx context.Context,
+		tokenHash string,
+		now time.Time,
+	) (*entities.WebAuthnSession, error)
+
+	SaveFederationState(
+		ctx context.Context,
+		state *entities.FederationState,
+	) error
+
+	ConsumeFederationState(
+		ctx context.Context,
+		tokenHash string,
+		now time.Time,
+	) (*entities.FederationState, error)
+
+	GetFederatedIdentity(
+		ctx context.Context,
+		issuer string,
+		subject string,
+	) (*entities.FederatedIdentity, error)
+
+	GetFederatedIdentities(
+		ctx context.Context,
+		uid int64,
+	) ([]entities.FederatedIdentity, error)
+
+	SaveFederatedIdentity(
+		ctx context.Context,
+		identity *entities.FederatedIdentity,
+	) error
+
+	SaveOrganization(
+		ctx context.Context,
+		org *entities.Organization,
+		ownerID int64,
+	) (int64, error)
+
+	GetMembership(
+		ctx context.Context,
+		orgID int64,
+		uid int64,
+	) (*entities.Membership, error)
+
+	GetMemberships(
+		ctx context.Context,
+		uid int64,
+	) ([]entities.Membership, error)
+
+	GetMembers(
+		ctx context.Context,
+		orgID int64,
+	) ([]entities.Membership, error)
+
+	DeleteMembership(
+		ctx context.Context,
+		orgID int64,
+		uid int64,
+	) error
+
+	SaveInvitation(
+		ctx context.Context,
+		inv *entities.Invitation,
+	) error
+
+	AcceptInvitation(
+		ctx context.Context,
+		tokenHash string,
+		uid int64,
+		now time.Time,
+	) (*entities.Membership, error)
+
+	GetOrganization(
+		ctx context.Context,
+		id int64,
+	) (*entities.Organization, error)
+
+	SetAppRestricted(
+		ctx context.Context,
+		appID int64,
+		restricted bool,
+	) error
+
+	UpdateAppPolicy(
+		ctx context.Context,
+		app *entities.App,
+	) error
+
+	SaveAppGrant(
+		ctx context.Context,
+		grant *entities.AppGrant,
+	) (int64, error)
+
+	GetAppGrants(
+		ctx context.Context,
+		appID int64,
+	) ([]entities.AppGrant, error)
+
+	GetUserAppGrants(
+		ctx context.Context,
+		uid int64,
+	) ([]entities.AppGrant, error)
+
+	DeleteAppGrant(
+		ctx context.Context,
+		appID int64,
+		id int64,
+	) error
+
+	HasAppAccess(
+		ctx context.Context,
+		appID int64,
+		uid int64,
+	) (bool, error)
+
+	Ping(ctx context.Context) error
 }
 
 func New(
 	log *slog.Logger,
 	storage Storage,
-	authTokenTTL time.Duration,
-	refreshTokenTTL time.Duration,
-) *Services {
+	cfg *config.Config,
+	breachChecker authservice.BreachChecker,
+) (*Services, error) {
+	const op = "services.New"
+
+	passwordHasher, err := hasher.New(hasher.Options{
+		Algorithm:  cfg.PasswordHashing.Algorithm,
+		BcryptCost: cfg.PasswordHashing.BcryptCost,
+		Argon2id: hasher.Argon2idParams{
+			Memory:      cfg.PasswordHashing.Argon2id.Memory,
+			Iterations:  cfg.PasswordHashing.Argon2id.Iterations,
+			Parallelism: cfg.PasswordHashing.Argon2id.Parallelism,
+			SaltLength:  cfg.PasswordHashing.Argon2id.SaltLength,
+			KeyLength:   cfg.PasswordHashing.Argon2id.KeyLength,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	passwordPolicy := passpolicy.Policy{
+		MinLength:     cfg.PasswordPolicy.MinLength,
+		MaxLength:     cfg.PasswordPolicy.MaxLength,
+		MaxBytes:      passwordHasher.MaxPasswordBytes(),
+		RequireUpper:  cfg.PasswordPolicy.RequireUpper,
+		RequireLower:  cfg.PasswordPolicy.RequireLower,
+		RequireDigit:  cfg.PasswordPolicy.RequireDigit,
+		RequireSymbol: cfg.PasswordPolicy.RequireSymbol,
+		RejectEmail:   cfg.PasswordPolicy.RejectEmail,
+	}
+
+	mail, err := mailer.New(log, mailer.Options{
+		Driver:   cfg.Mailer.Driver,
+		From:     cfg.Mailer.From,
+		Host:     cfg.Mailer.SMTP.Host,
+		Port:     cfg.Mailer.SMTP.Port,
+		Username: cfg.Mailer.SMTP.Username,
+		Password: cfg.Mailer.SMTP.Password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	relyingParty, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthn.RPID,
+		RPDisplayName: cfg.WebAuthn.RPDisplayName,
+		RPOrigins:     cfg.WebAuthn.RPOrigins,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var authenticators []authservice.Authenticator
+	if cfg.LDAP.URL != "" {
+		directory := ldapdir.New(ldapdir.Options{
+			URL:            cfg.LDAP.URL,
+			StartTLS:       cfg.LDAP.StartTLS,
+			BindDN:         cfg.LDAP.BindDN,
+			BindPassword:   cfg.LDAP.BindPassword,
+			BaseDN:         cfg.LDAP.BaseDN,
+			UserFilter:     cfg.LDAP.UserFilter,
+			EmailAttribute: cfg.LDAP.EmailAttribute,
+			NameAttribute:  cfg.LDAP.NameAttribute,
+			Timeout:        cfg.LDAP.Timeout,
+		})
+		authenticators = append(authenticators, ldapservice.New(log, directory, storage, storage))
+	}
+
+	auth := authservice.New(
+		log,
+		cfg.TokenTTL.Auth,
+		cfg.TokenTTL.Refresh,
+		cfg.TokenTTL.Reauth,
+		cfg.TokenClaims.Profile,
+		storage,
+		storage,
+		storage,
+		storage,
+		storage,
+		passwordPolicy,
+		passwordHasher,
+		hasher.NewLimiter(
+			cfg.PasswordHashing.MaxConcurrency,
+			cfg.PasswordHashing.QueueTimeout,
+		),
+		breachChecker,
+		authenticators,
+	)
+
+	var federation *federationservice.FederationService
+	if cfg.Federation.Issuer != "" {
+		if cfg.Federation.RedirectURL == "" {
+			return nil, fmt.Errorf("%s: federation redirect_url is required with issuer", op)
+		}
+		federation = federationservice.New(
+			log,
+			storage,
+			storage,
+			storage,
+			storage,
+			storage,
+			auth,
+			federationservice.Options{
+				Issuer:        cfg.Federation.Issuer,
+				ClientID:      cfg.Federation.ClientID,
+				ClientSecret:  cfg.Federation.ClientSecret,
+				RedirectURL:   cfg.Federation.RedirectURL,
+				Scopes:        cfg.Federation.Scopes,
+				AutoProvision: cfg.Federation.AutoProvision,
+				StateTTL:      cfg.Federation.StateTTL,
+			},
+		)
+	}
+
 	return &Services{
-		Auth: authservice.New(
+		Auth: auth,
+		App: appservice.New(
 			log,
-			authTokenTTL,
-			refreshTokenTTL,
+			storage,
+			storage,
 			storage,
 			storage,
 			storage,
 		),
-	}
+		Account: accountservice.New(
+			log,
+			storage,
+			storage,
+			storage,
+			storage,
+			storage,
+			storage,
+			storage,
+			storage,
+			auth,
+			mail,
+			cfg.Account.EmailChangeTTL,
+			cfg.Account.EmailConfirmURL,
+			cfg.Account.DeletionGracePeriod,
+		),
+		MagicLink: magiclinkservice.New(
+			log,
+			storage,
+			storage,
+			storage,
+			auth,
+			mail,
+			cfg.MagicLink.TTL,
+			cfg.MagicLink.URL,
+			cfg.MagicLink.RequestInterval,
+		),
+		PasswordReset: passwordresetservice.New(
+			log,
+			storage,
+			storage,
+			auth,
+			mail,
+			cfg.PasswordReset.TTL,
+			cfg.PasswordReset.URL,
+			cfg.PasswordReset.RequestInterval,
+		),
+		WebAuthn: webauthnservice.New(
+			log,
+			relyingParty,
+			storage,
+			storage,
+			storage,
+			storage,
+			auth,
+			auth,
+			mail,
+			cfg.WebAuthn.SessionTTL,
+		),
+		Organization: organizationservice.New(
+			log,
+			storage,
+			storage,
+			storage,
+			storage,
+			mail,
+			cfg.Organization.InvitationTTL,
+			cfg.Organization.InvitationURL,
+		),
+		Federation: federation,
+		Health: healthservice.New(
+			log,
+			storage,
+		),
+	}, nil
 }
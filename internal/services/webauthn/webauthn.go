@@ -0,0 +1,573 @@
+package webauthnservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/logger/sl"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/mailer"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/metrics"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/onetime"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/tracing"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+type UserProvider interface {
+	GetUserById(
+		ctx context.Context,
+		uid int64,
+	) (*entities.User, error)
+}
+
+type AppProvider interface {
+	GetApp(
+		ctx context.Context,
+		id int64,
+	) (*entities.App, error)
+}
+
+type CredentialStorage interface {
+	SaveWebAuthnCredential(
+		ctx context.Context,
+		cred *entities.WebAuthnCredential,
+	) error
+	GetWebAuthnCredentials(
+		ctx context.Context,
+		uid int64,
+	) ([]entities.WebAuthnCredential, error)
+	UpdateWebAuthnCredential(
+		ctx context.Context,
+		id []byte,
+		data []byte,
+		usedAt time.Time,
+	) error
+	DeleteWebAuthnCredential(
+		ctx context.Context,
+		uid int64,
+		id []byte,
+	) error
+}
+
+type SessionStorage interface {
+	SaveWebAuthnSession(
+		ctx context.Context,
+		session *entities.WebAuthnSession,
+	) error
+	ConsumeWebAuthnSession(
+		ctx context.Context,
+		tokenHash string,
+		now time.Time,
+	) (*entities.WebAuthnSession, error)
+}
+
+type TokenIssuer interface {
+	IssueTokens(
+		ctx context.Context,
+		uid int64,
+		appID int64,
+		grant entities.Grant,
+	) (*entities.JwtTokenPair, error)
+}
+
+// Reauthenticator confirms that user behind token is present,
+// by password or recent login.
+type Reauthenticator interface {
+	Reauthenticate(
+		ctx context.Context,
+		uid int64,
+		authTime time.Time,
+		password string,
+	) (*entities.User, error)
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg mailer.Message) error
+}
+
+type WebAuthnService struct {
+	log               *slog.Logger
+	relyingParty      *webauthn.WebAuthn
+	userProvider      UserProvider
+	appProvider       AppProvider
+	credentialStorage CredentialStorage
+	sessionStorage    SessionStorage
+	tokenIssuer       TokenIssuer
+	reauthenticator   Reauthenticator
+	mailer            Mailer
+	sessionTTL        time.Duration
+}
+
+// New returns new WebAuthnService instance.
+// Ceremonies not finished within sessionTTL fail.
+func New(
+	log *slog.Logger,
+	relyingParty *webauthn.WebAuthn,
+	userProvider UserProvider,
+	appProvider AppProvider,
+	credentialStorage CredentialStorage,
+	sessionStorage SessionStorage,
+	tokenIssuer TokenIssuer,
+	reauthenticator Reauthenticator,
+	mailer Mailer,
+	sessionTTL time.Duration,
+) *WebAuthnService {
+	return &WebAuthnService{
+		log:               log,
+		relyingParty:      relyingParty,
+		userProvider:      userProvider,
+		appProvider:       appProvider,
+		credentialStorage: credentialStorage,
+		sessionStorage:    sessionStorage,
+		tokenIssuer:       tokenIssuer,
+		reauthenticator:   reauthenticator,
+		mailer:            mailer,
+		sessionTTL:        sessionTTL,
+	}
+}
+
+// BeginRegistration starts registration of new passkey of user.
+// Passkey logs user in without password, so stolen token alone must
+// not add one: user confirms by password or recent login.
+// Returns session id to pass to FinishRegistration and options
+// for navigator.credentials.create.
+func (w *WebAuthnService) BeginRegistration(
+	ctx context.Context,
+	dto dtos.BeginWebAuthnRegistrationDto,
+) (_ string, _ *protocol.CredentialCreation, err error) {
+	const op = "webauthnservice.BeginRegistration"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	uid := dto.UID
+	log := w.log.With(slog.String("op", op), slog.Int64("uid", uid))
+
+	if _, err := w.reauthenticator.Reauthenticate(ctx, uid, dto.AuthTime, dto.Password); err != nil {
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	usr, err := w.getUser(ctx, uid)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get user", sl.Err(err))
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(usr.credentials))
+	for _, cred := range usr.credentials {
+		exclusions = append(exclusions, cred.Descriptor())
+	}
+
+	// Login doesn't ask for user, so credential must be discoverable
+	options, session, err := w.relyingParty.BeginRegistration(
+		usr,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(exclusions),
+	)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to begin registration", sl.Err(err))
+		return "", nil, fmt.Errorf("%s: %w", op, cerrors.NewCriticalInternalError("webauthn.BeginRegistration", err))
+	}
+
+	sessionID, err := w.saveSession(ctx, session, uid, 0)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to save session", sl.Err(err))
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessionID, options, nil
+}
+
+// FinishRegistration verifies attestation and saves new passkey of user,
+// then notifies user by email.
+func (w *WebAuthnService) FinishRegistration(
+	ctx context.Context,
+	dto dtos.FinishWebAuthnRegistrationDto,
+) (_ *entities.WebAuthnCredential, err error) {
+	const op = "webauthnservice.FinishRegistration"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := w.log.With(slog.String("op", op), slog.Int64("uid", dto.UID))
+
+	session, err := w.consumeSession(ctx, dto.SessionID)
+	if err != nil {
+		log.WarnContext(ctx, "failed to get session", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	// Session id could leak, yet it is bound to user who started ceremony
+	if session.UserID != dto.UID {
+		log.WarnContext(ctx, "session of another user")
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat))
+	}
+
+	var sessionData webauthn.SessionData
+	if err := json.Unmarshal(session.Data, &sessionData); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewCriticalInternalError("json.Unmarshal", err))
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(dto.Credential))
+	if err != nil {
+		log.WarnContext(ctx, "bad attestation", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, credentialError(err))
+	}
+
+	usr, err := w.getUser(ctx, dto.UID)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to get user", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	credential, err := w.relyingParty.CreateCredential(usr, sessionData, parsed)
+	if err != nil {
+		log.WarnContext(ctx, "attestation rejected", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, credentialError(err))
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewCriticalInternalError("json.Marshal", err))
+	}
+
+	cred := &entities.WebAuthnCredential{
+		ID:        credential.ID,
+		UserID:    dto.UID,
+		Name:      dto.Name,
+		Data:      data,
+		CreatedAt: time.Now(),
+	}
+	if err := w.credentialStorage.SaveWebAuthnCredential(ctx, cred); err != nil {
+		log.ErrorContext(ctx, "failed to save credential", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.InfoContext(ctx, "passkey registered")
+
+	// Passkey is already saved, failed notice must not fail request
+	err = w.mailer.Send(ctx, mailer.Message{
+		To:      usr.Email,
+		Subject: "A passkey was added to your account",
+		Body: fmt.Sprintf(
+			"Passkey %q was added to your account at %s. It can be used to sign in without password.\n\n"+
+				"If you didn't do it, remove the passkey and change your password immediately.\n",
+			cred.Name,
+			cred.CreatedAt.UTC().Format(time.RFC1123),
+		),
+	})
+	if err != nil {
+		log.ErrorContext(ctx, "failed to notify about passkey", sl.Err(err))
+	}
+
+	return cred, nil
+}
+
+// BeginLogin starts login into app by passkey. User is not asked for,
+// authenticator offers passkeys it keeps for relying party.
+// Returns session id to pass to FinishLogin and options
+// for navigator.credentials.get.
+func (w *WebAuthnService) BeginLogin(
+	ctx context.Context,
+	appID int64,
+) (_ string, _ *protocol.CredentialAssertion, err error) {
+	const op = "webauthnservice.BeginLogin"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := w.log.With(slog.String("op", op))
+
+	if _, err := w.appProvider.GetApp(ctx, appID); err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			log.WarnContext(ctx, "app not found", sl.Err(err))
+			return "", nil, fmt.Errorf("%s: %w", op, err)
+		}
+		log.ErrorContext(ctx, "failed to get app from storage", sl.Err(err))
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	options, session, err := w.relyingParty.BeginDiscoverableLogin()
+	if err != nil {
+		log.ErrorContext(ctx, "failed to begin login", sl.Err(err))
+		return "", nil, fmt.Errorf("%s: %w", op, cerrors.NewCriticalInternalError("webauthn.BeginDiscoverableLogin", err))
+	}
+
+	sessionID, err := w.saveSession(ctx, session, 0, appID)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to save session", sl.Err(err))
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessionID, options, nil
+}
+
+// FinishLogin verifies assertion and returns the same tokens
+// as login by password.
+func (w *WebAuthnService) FinishLogin(
+	ctx context.Context,
+	dto dtos.FinishWebAuthnLoginDto,
+) (_ *entities.JwtTokenPair, err error) {
+	const op = "webauthnservice.FinishLogin"
+
+	var appID int64
+	defer func(start time.Time) {
+		metrics.ObserveAuth("webauthn_login", appID, start, &err)
+	}(time.Now())
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	log := w.log.With(slog.String("op", op))
+
+	session, err := w.consumeSession(ctx, dto.SessionID)
+	if err != nil {
+		log.WarnContext(ctx, "failed to get session", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if session.AppID == 0 {
+		log.WarnContext(ctx, "not a login session")
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat))
+	}
+	appID = session.AppID
+
+	var sessionData webauthn.SessionData
+	if err := json.Unmarshal(session.Data, &sessionData); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewCriticalInternalError("json.Unmarshal", err))
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(dto.Credential))
+	if err != nil {
+		log.WarnContext(ctx, "bad assertion", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, credentialError(err))
+	}
+
+	var usr *user
+	var lookupErr error
+	credential, err := w.relyingParty.ValidateDiscoverableLogin(
+		func(_, userHandle []byte) (webauthn.User, error) {
+			usr, lookupErr = w.getUser(ctx, uidFromHandle(userHandle))
+			return usr, lookupErr
+		},
+		sessionData,
+		parsed,
+	)
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if lookupErr != nil && !errors.As(lookupErr, &nfErr) {
+			log.ErrorContext(ctx, "failed to get user", sl.Err(lookupErr))
+			return nil, fmt.Errorf("%s: %w", op, lookupErr)
+		}
+		log.WarnContext(ctx, "assertion rejected", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidCredentialsError())
+	}
+
+	// Counter went backwards, so private key may be cloned
+	if credential.Authenticator.CloneWarning {
+		log.WarnContext(ctx, "possibly cloned authenticator", slog.Uint64("uid", usr.UID))
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewInvalidCredentialsError())
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, cerrors.NewCriticalInternalError("json.Marshal", err))
+	}
+
+	if err := w.credentialStorage.UpdateWebAuthnCredential(ctx, credential.ID, data, time.Now()); err != nil {
+		log.ErrorContext(ctx, "failed to update credential", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Passkey is something user has, verified by PIN or biometrics
+	// it is a second factor as well
+	tokens, err := w.tokenIssuer.IssueTokens(ctx, int64(usr.UID), session.AppID, entities.Grant{
+		Type: entities.GrantWebAuthn,
+		MFA:  parsed.Response.AuthenticatorData.Flags.HasUserVerified(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.DebugContext(ctx, "user logged in by passkey", slog.Uint64("uid", usr.UID))
+
+	return tokens, nil
+}
+
+// Credentials returns passkeys of user.
+func (w *WebAuthnService) Credentials(
+	ctx context.Context,
+	uid int64,
+) (_ []entities.WebAuthnCredential, err error) {
+	const op = "webauthnservice.Credentials"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	creds, err := w.credentialStorage.GetWebAuthnCredentials(ctx, uid)
+	if err != nil {
+		w.log.ErrorContext(ctx, "failed to get credentials", slog.String("op", op), sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return creds, nil
+}
+
+// DeleteCredential deletes passkey of user, e.g. a lost one.
+func (w *WebAuthnService) DeleteCredential(
+	ctx context.Context,
+	uid int64,
+	id []byte,
+) (err error) {
+	const op = "webauthnservice.DeleteCredential"
+
+	ctx, span := tracing.Start(ctx, op)
+	defer tracing.End(span, &err)
+
+	if err := w.credentialStorage.DeleteWebAuthnCredential(ctx, uid, id); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	w.log.InfoContext(ctx, "passkey deleted", slog.String("op", op), slog.Int64("uid", uid))
+
+	return nil
+}
+
+// user adapts entities.User with its passkeys to webauthn.User.
+type user struct {
+	*entities.User
+	credentials []webauthn.Credential
+}
+
+func (u *user) WebAuthnID() []byte {
+	return userHandle(int64(u.UID))
+}
+
+func (u *user) WebAuthnName() string {
+	if u.Username != "" {
+		return u.Username
+	}
+	return u.Email
+}
+
+func (u *user) WebAuthnDisplayName() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	return u.WebAuthnName()
+}
+
+func (u *user) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+func (u *user) WebAuthnIcon() string {
+	return ""
+}
+
+// userHandle identifies user to authenticator. It is uid rather than
+// email, so it reveals no personal data and survives email change.
+func userHandle(uid int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(uid))
+}
+
+// uidFromHandle returns 0 for handles not made by userHandle,
+// no user has such uid.
+func uidFromHandle(handle []byte) int64 {
+	if len(handle) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(handle))
+}
+
+func (w *WebAuthnService) getUser(ctx context.Context, uid int64) (*user, error) {
+	usr, err := w.userProvider.GetUserById(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := w.credentialStorage.GetWebAuthnCredentials(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &user{User: usr, credentials: make([]webauthn.Credential, 0, len(creds))}
+	for _, c := range creds {
+		var credential webauthn.Credential
+		if err := json.Unmarshal(c.Data, &credential); err != nil {
+			return nil, cerrors.NewCriticalInternalError("json.Unmarshal", err)
+		}
+		u.credentials = append(u.credentials, credential)
+	}
+
+	return u, nil
+}
+
+func (w *WebAuthnService) saveSession(
+	ctx context.Context,
+	session *webauthn.SessionData,
+	uid int64,
+	appID int64,
+) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", cerrors.NewCriticalInternalError("json.Marshal", err)
+	}
+
+	sessionID, sessionHash, err := onetime.New()
+	if err != nil {
+		return "", cerrors.NewCriticalInternalError("onetime.New", err)
+	}
+
+	err = w.sessionStorage.SaveWebAuthnSession(ctx, &entities.WebAuthnSession{
+		TokenHash: sessionHash,
+		UserID:    uid,
+		AppID:     appID,
+		Data:      data,
+		ExpiresAt: time.Now().Add(w.sessionTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return sessionID, nil
+}
+
+// consumeSession returns InvalidTokenError for unknown
+// and expired sessions.
+func (w *WebAuthnService) consumeSession(ctx context.Context, sessionID string) (*entities.WebAuthnSession, error) {
+	session, err := w.sessionStorage.ConsumeWebAuthnSession(ctx, onetime.Hash(sessionID), time.Now())
+	if err != nil {
+		var nfErr cerrors.NotFoundError
+		if errors.As(err, &nfErr) {
+			return nil, cerrors.NewInvalidTokenError(cerrors.TokenBadFormat)
+		}
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// credentialError converts error of parsing or verifying credential
+// created by client to ValidationError.
+func credentialError(err error) error {
+	description := "is not a valid credential"
+
+	var pErr *protocol.Error
+	if errors.As(err, &pErr) && pErr.Details != "" {
+		description = fmt.Sprintf("is not a valid credential: %s", pErr.Details)
+	}
+
+	return cerrors.NewValidationError("Bad format", cerrors.FieldViolation{
+		Field:       "credential",
+		Description: description,
+	})
+}
@@ -0,0 +1,452 @@
+package webauthnservice_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/dtos"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/mailer"
+	webauthnservice "github.com/Woland-prj/microtasks_sso/internal/services/webauthn"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	rpID   = "localhost"
+	origin = "http://localhost:8080"
+)
+
+// authenticator is a software authenticator keeping one ES256 passkey.
+type authenticator struct {
+	key        *ecdsa.PrivateKey
+	credID     []byte
+	userHandle []byte
+	counter    uint32
+}
+
+func newAuthenticator(t *testing.T) *authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	credID := make([]byte, 16)
+	_, err = rand.Read(credID)
+	require.NoError(t, err)
+
+	return &authenticator{key: key, credID: credID}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (a *authenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+
+	return append(data, attested...)
+}
+
+func clientData(t *testing.T, typ string, challenge protocol.URLEncodedBase64) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": challenge.String(),
+		"origin":    origin,
+	})
+	require.NoError(t, err)
+
+	return data
+}
+
+// create answers navigator.credentials.create with "none" attestation.
+func (a *authenticator) create(t *testing.T, options *protocol.CredentialCreation) json.RawMessage {
+	a.userHandle = options.Response.User.ID.(protocol.URLEncodedBase64)
+
+	coseKey, err := cbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	require.NoError(t, err)
+
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credID)))
+	attested = append(attested, a.credID...)
+	attested = append(attested, coseKey...)
+
+	// User present, user verified, attested credential data included
+	attestation, err := cbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(0x45, attested),
+	})
+	require.NoError(t, err)
+
+	resp, err := json.Marshal(map[string]any{
+		"id":    b64(a.credID),
+		"rawId": b64(a.credID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData(t, "webauthn.create", options.Response.Challenge)),
+			"attestationObject": b64(attestation),
+		},
+	})
+	require.NoError(t, err)
+
+	return resp
+}
+
+// get answers navigator.credentials.get, signing with the next counter.
+func (a *authenticator) get(t *testing.T, options *protocol.CredentialAssertion) json.RawMessage {
+	a.counter++
+
+	authData := a.authData(0x05, nil)
+	cdj := clientData(t, "webauthn.get", options.Response.Challenge)
+	cdjHash := sha256.Sum256(cdj)
+	digest := sha256.Sum256(append(bytes.Clone(authData), cdjHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	require.NoError(t, err)
+
+	resp, err := json.Marshal(map[string]any{
+		"id":    b64(a.credID),
+		"rawId": b64(a.credID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(cdj),
+			"authenticatorData": b64(authData),
+			"signature":         b64(signature),
+			"userHandle":        b64(a.userHandle),
+		},
+	})
+	require.NoError(t, err)
+
+	return resp
+}
+
+// fakeStorage keeps users, apps, passkeys and sessions in memory.
+type fakeStorage struct {
+	users    map[int64]*entities.User
+	creds    []entities.WebAuthnCredential
+	sessions map[string]entities.WebAuthnSession
+}
+
+func (f *fakeStorage) GetUserById(_ context.Context, uid int64) (*entities.User, error) {
+	if usr, ok := f.users[uid]; ok {
+		return usr, nil
+	}
+	return nil, cerrors.NewNotFoundError(fmt.Sprintf("user %d", uid))
+}
+
+func (f *fakeStorage) GetApp(_ context.Context, id int64) (*entities.App, error) {
+	if id != 1 {
+		return nil, cerrors.NewNotFoundError(fmt.Sprintf("app %d", id))
+	}
+	return &entities.App{ID: 1, Name: "test"}, nil
+}
+
+func (f *fakeStorage) SaveWebAuthnCredential(_ context.Context, cred *entities.WebAuthnCredential) error {
+	f.creds = append(f.creds, *cred)
+	return nil
+}
+
+func (f *fakeStorage) GetWebAuthnCredentials(_ context.Context, uid int64) ([]entities.WebAuthnCredential, error) {
+	var creds []entities.WebAuthnCredential
+	for _, c := range f.creds {
+		if c.UserID == uid {
+			creds = append(creds, c)
+		}
+	}
+	return creds, nil
+}
+
+func (f *fakeStorage) UpdateWebAuthnCredential(_ context.Context, id []byte, data []byte, usedAt time.Time) error {
+	for i := range f.creds {
+		if bytes.Equal(f.creds[i].ID, id) {
+			f.creds[i].Data = data
+			f.creds[i].LastUsedAt = usedAt
+			return nil
+		}
+	}
+	return cerrors.NewNotFoundError("credential")
+}
+
+func (f *fakeStorage) DeleteWebAuthnCredential(context.Context, int64, []byte) error {
+	return nil
+}
+
+func (f *fakeStorage) SaveWebAuthnSession(_ context.Context, session *entities.WebAuthnSession) error {
+	f.sessions[session.TokenHash] = *session
+	return nil
+}
+
+func (f *fakeStorage) ConsumeWebAuthnSession(_ context.Context, tokenHash string, now time.Time) (*entities.WebAuthnSession, error) {
+	session, ok := f.sessions[tokenHash]
+	delete(f.sessions, tokenHash)
+	if !ok || !session.ExpiresAt.After(now) {
+		return nil, cerrors.NewNotFoundError("webauthn session")
+	}
+	return &session, nil
+}
+
+type fakeTokenIssuer struct{}
+
+func (fakeTokenIssuer) IssueTokens(_ context.Context, uid int64, appID int64, grant entities.Grant) (*entities.JwtTokenPair, error) {
+	return &entities.JwtTokenPair{
+		AuthToken: fmt.Sprintf("auth %d %d %s mfa=%t", uid, appID, grant.Type, grant.MFA),
+	}, nil
+}
+
+const password = "Correct1Horse"
+
+// fakeReauthenticator accepts password or login within 5 minutes,
+// like authservice.Reauthenticate does.
+type fakeReauthenticator struct{}
+
+func (fakeReauthenticator) Reauthenticate(_ context.Context, uid int64, authTime time.Time, pass string) (*entities.User, error) {
+	if pass != "" {
+		if pass != password {
+			return nil, cerrors.NewInvalidCredentialsError()
+		}
+		return &entities.User{UID: uint64(uid)}, nil
+	}
+	if time.Since(authTime) > 5*time.Minute {
+		return nil, cerrors.NewValidationError("Reauthentication required", cerrors.FieldViolation{Field: "password"})
+	}
+	return &entities.User{UID: uint64(uid)}, nil
+}
+
+type fakeMailer struct {
+	sent []mailer.Message
+}
+
+func (f *fakeMailer) Send(_ context.Context, msg mailer.Message) error {
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func newService(t *testing.T) (*webauthnservice.WebAuthnService, *fakeStorage) {
+	service, storage, _ := newServiceWithMailer(t)
+	return service, storage
+}
+
+func newServiceWithMailer(t *testing.T) (*webauthnservice.WebAuthnService, *fakeStorage, *fakeMailer) {
+	relyingParty, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: "Test",
+		RPOrigins:     []string{origin},
+	})
+	require.NoError(t, err)
+
+	storage := &fakeStorage{
+		users: map[int64]*entities.User{
+			1: {UID: 1, Email: "bob@example.com"},
+			2: {UID: 2, Email: "ann@example.com"},
+		},
+		sessions: make(map[string]entities.WebAuthnSession),
+	}
+	mail := &fakeMailer{}
+
+	service := webauthnservice.New(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		relyingParty,
+		storage,
+		storage,
+		storage,
+		storage,
+		fakeTokenIssuer{},
+		fakeReauthenticator{},
+		mail,
+		time.Minute,
+	)
+
+	return service, storage, mail
+}
+
+func beginRegistration(service *webauthnservice.WebAuthnService, uid int64) (string, *protocol.CredentialCreation, error) {
+	return service.BeginRegistration(context.Background(), dtos.BeginWebAuthnRegistrationDto{
+		UID:      uid,
+		Password: password,
+	})
+}
+
+func register(t *testing.T, service *webauthnservice.WebAuthnService, a *authenticator, uid int64) {
+	ctx := context.Background()
+
+	sessionID, options, err := beginRegistration(service, uid)
+	require.NoError(t, err)
+
+	_, err = service.FinishRegistration(ctx, dtos.FinishWebAuthnRegistrationDto{
+		UID:        uid,
+		SessionID:  sessionID,
+		Name:       "laptop",
+		Credential: a.create(t, options),
+	})
+	require.NoError(t, err)
+}
+
+func TestWebAuthn_RegisterAndLogin(t *testing.T) {
+	service, storage := newService(t)
+	ctx := context.Background()
+	a := newAuthenticator(t)
+
+	register(t, service, a, 1)
+	require.Len(t, storage.creds, 1)
+	assert.Equal(t, a.credID, storage.creds[0].ID)
+
+	for i := 0; i < 2; i++ {
+		sessionID, options, err := service.BeginLogin(ctx, 1)
+		require.NoError(t, err)
+
+		tokens, err := service.FinishLogin(ctx, dtos.FinishWebAuthnLoginDto{
+			SessionID:  sessionID,
+			Credential: a.get(t, options),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "auth 1 1 webauthn mfa=true", tokens.AuthToken)
+	}
+
+	var cred webauthn.Credential
+	require.NoError(t, json.Unmarshal(storage.creds[0].Data, &cred))
+	assert.Equal(t, uint32(2), cred.Authenticator.SignCount)
+	assert.False(t, storage.creds[0].LastUsedAt.IsZero())
+}
+
+func TestWebAuthn_LoginSessionIsSingleUse(t *testing.T) {
+	service, _ := newService(t)
+	ctx := context.Background()
+	a := newAuthenticator(t)
+
+	register(t, service, a, 1)
+
+	sessionID, options, err := service.BeginLogin(ctx, 1)
+	require.NoError(t, err)
+	assertion := a.get(t, options)
+
+	dto := dtos.FinishWebAuthnLoginDto{SessionID: sessionID, Credential: assertion}
+	_, err = service.FinishLogin(ctx, dto)
+	require.NoError(t, err)
+
+	_, err = service.FinishLogin(ctx, dto)
+	var tErr cerrors.InvalidTokenError
+	assert.ErrorAs(t, err, &tErr)
+}
+
+func TestWebAuthn_ClonedAuthenticator(t *testing.T) {
+	service, _ := newService(t)
+	ctx := context.Background()
+	a := newAuthenticator(t)
+
+	register(t, service, a, 1)
+
+	sessionID, options, err := service.BeginLogin(ctx, 1)
+	require.NoError(t, err)
+	_, err = service.FinishLogin(ctx, dtos.FinishWebAuthnLoginDto{SessionID: sessionID, Credential: a.get(t, options)})
+	require.NoError(t, err)
+
+	// Clone signs with the counter original already used
+	a.counter--
+
+	sessionID, options, err = service.BeginLogin(ctx, 1)
+	require.NoError(t, err)
+	_, err = service.FinishLogin(ctx, dtos.FinishWebAuthnLoginDto{SessionID: sessionID, Credential: a.get(t, options)})
+	var cErr cerrors.InvalidCredentialsError
+	assert.ErrorAs(t, err, &cErr)
+}
+
+func TestWebAuthn_ForeignRegistrationSession(t *testing.T) {
+	service, storage := newService(t)
+	ctx := context.Background()
+	a := newAuthenticator(t)
+
+	sessionID, options, err := beginRegistration(service, 1)
+	require.NoError(t, err)
+
+	_, err = service.FinishRegistration(ctx, dtos.FinishWebAuthnRegistrationDto{
+		UID:        2,
+		SessionID:  sessionID,
+		Credential: a.create(t, options),
+	})
+	var tErr cerrors.InvalidTokenError
+	assert.ErrorAs(t, err, &tErr)
+	assert.Empty(t, storage.creds)
+}
+
+func TestWebAuthn_RegistrationRequiresReauth(t *testing.T) {
+	cases := []struct {
+		name string
+		dto  dtos.BeginWebAuthnRegistrationDto
+		// err points to expected error type, nil if none
+		err any
+	}{
+		{
+			name: "password",
+			dto:  dtos.BeginWebAuthnRegistrationDto{UID: 1, Password: password},
+		},
+		{
+			name: "recent login",
+			dto:  dtos.BeginWebAuthnRegistrationDto{UID: 1, AuthTime: time.Now().Add(-time.Minute)},
+		},
+		{
+			name: "wrong password",
+			dto:  dtos.BeginWebAuthnRegistrationDto{UID: 1, Password: "wrong", AuthTime: time.Now()},
+			err:  &cerrors.InvalidCredentialsError{},
+		},
+		{
+			name: "old login",
+			dto:  dtos.BeginWebAuthnRegistrationDto{UID: 1, AuthTime: time.Now().Add(-time.Hour)},
+			err:  &cerrors.ValidationError{},
+		},
+		{
+			name: "token only",
+			dto:  dtos.BeginWebAuthnRegistrationDto{UID: 1},
+			err:  &cerrors.ValidationError{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			service, storage := newService(t)
+
+			sessionID, _, err := service.BeginRegistration(context.Background(), tc.dto)
+			if tc.err == nil {
+				require.NoError(t, err)
+				assert.NotEmpty(t, sessionID)
+				return
+			}
+
+			assert.ErrorAs(t, err, tc.err)
+			assert.Empty(t, storage.sessions)
+		})
+	}
+}
+
+func TestWebAuthn_RegistrationNotifiesUser(t *testing.T) {
+	service, _, mail := newServiceWithMailer(t)
+
+	register(t, service, newAuthenticator(t), 1)
+
+	require.Len(t, mail.sent, 1)
+	assert.Equal(t, "bob@example.com", mail.sent[0].To)
+	assert.Contains(t, mail.sent[0].Body, `"laptop"`)
+}
@@ -0,0 +1,385 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Woland-prj/microtasks_sso/internal/domain/cerrors"
+	"github.com/Woland-prj/microtasks_sso/internal/domain/entities"
+	"github.com/Woland-prj/microtasks_sso/internal/lib/migrator"
+	"github.com/Woland-prj/microtasks_sso/internal/storage/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStorage returns storage of fresh migrated database
+// and raw connection to it for checking rows.
+func newStorage(t *testing.T) (*sqlite.Storage, *sql.DB) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "sso.db")
+
+	m, err := migrator.New(path, "", migrator.DefaultTable)
+	require.NoError(t, err)
+	require.NoError(t, m.Up())
+	require.NoError(t, m.Close())
+
+	storage, err := sqlite.New(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return storage, db
+}
+
+func count(t *testing.T, db *sql.DB, query string, args ...any) int {
+	t.Helper()
+
+	var n int
+	require.NoError(t, db.QueryRow(query, args...).Scan(&n))
+	return n
+}
+
+func TestMagicLink_RequestInterval(t *testing.T) {
+	storage, _ := newStorage(t)
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	link := func(hash string, createdAt time.Time) *entities.MagicLink {
+		return &entities.MagicLink{
+			TokenHash: hash,
+			UserID:    1,
+			AppID:     1,
+			CreatedAt: createdAt,
+			ExpiresAt: createdAt.Add(15 * time.Minute),
+		}
+	}
+
+	require.NoError(t, storage.SaveMagicLink(ctx, link("first", now), time.Minute))
+
+	var aeErr cerrors.AlreadyExistsError
+	err := storage.SaveMagicLink(ctx, link("early", now.Add(30*time.Second)), time.Minute)
+	require.ErrorAs(t, err, &aeErr)
+
+	require.NoError(t, storage.SaveMagicLink(ctx, link("second", now.Add(time.Minute)), time.Minute))
+
+	// Only the latest link works, and only once
+	var nfErr cerrors.NotFoundError
+	_, err = storage.ConsumeMagicLink(ctx, "first", now.Add(time.Minute))
+	assert.ErrorAs(t, err, &nfErr)
+	_, err = storage.ConsumeMagicLink(ctx, "early", now.Add(time.Minute))
+	assert.ErrorAs(t, err, &nfErr)
+
+	got, err := storage.ConsumeMagicLink(ctx, "second", now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, link("second", now.Add(time.Minute)).ExpiresAt, got.ExpiresAt)
+	assert.Equal(t, now.Add(time.Minute), got.CreatedAt)
+
+	_, err = storage.ConsumeMagicLink(ctx, "second", now.Add(time.Minute))
+	assert.ErrorAs(t, err, &nfErr)
+}
+
+func TestSaveMagicLink_DeletesExpired(t *testing.T) {
+	storage, db := newStorage(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	for uid, expiresAt := range map[int64]time.Time{
+		1: now.Add(-time.Minute),
+		2: now.Add(time.Minute),
+	} {
+		err := storage.SaveMagicLink(ctx, &entities.MagicLink{
+			TokenHash: fmt.Sprintf("link-%d", uid),
+			UserID:    uid,
+			AppID:     1,
+			CreatedAt: now.Add(-time.Hour),
+			ExpiresAt: expiresAt,
+		}, time.Minute)
+		require.NoError(t, err)
+	}
+
+	err := storage.SaveMagicLink(ctx, &entities.MagicLink{
+		TokenHash: "link-3",
+		UserID:    3,
+		AppID:     1,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Minute),
+	}, time.Minute)
+	require.NoError(t, err)
+
+	assert.Zero(t, count(t, db, "SELECT COUNT(*) FROM magic_links WHERE user_id = 1"))
+	assert.Equal(t, 1, count(t, db, "SELECT COUNT(*) FROM magic_links WHERE user_id = 2"))
+	assert.Equal(t, 1, count(t, db, "SELECT COUNT(*) FROM magic_links WHERE user_id = 3"))
+}
+
+func TestConsumeMagicLink_Expired(t *testing.T) {
+	storage, db := newStorage(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	err := storage.SaveMagicLink(ctx, &entities.MagicLink{
+		TokenHash: "a",
+		UserID:    1,
+		AppID:     1,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Minute),
+	}, time.Minute)
+	require.NoError(t, err)
+
+	var nfErr cerrors.NotFoundError
+	_, err = storage.ConsumeMagicLink(ctx, "a", now.Add(time.Minute))
+	assert.ErrorAs(t, err, &nfErr)
+	assert.Zero(t, count(t, db, "SELECT COUNT(*) FROM magic_links"))
+}
+
+func TestPasswordReset(t *testing.T) {
+	storage, db := newStorage(t)
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	reset := func(hash string, uid int64, createdAt time.Time) *entities.PasswordReset {
+		return &entities.PasswordReset{
+			TokenHash: hash,
+			UserID:    uid,
+			CreatedAt: createdAt,
+			ExpiresAt: createdAt.Add(time.Hour),
+		}
+	}
+
+	require.NoError(t, storage.SavePasswordReset(ctx, reset("expired", 2, now.Add(-2*time.Hour)), time.Minute))
+	require.NoError(t, storage.SavePasswordReset(ctx, reset("first", 1, now), time.Minute))
+	assert.Zero(t, count(t, db, "SELECT COUNT(*) FROM password_resets WHERE user_id = 2"))
+
+	var aeErr cerrors.AlreadyExistsError
+	err := storage.SavePasswordReset(ctx, reset("early", 1, now.Add(30*time.Second)), time.Minute)
+	require.ErrorAs(t, err, &aeErr)
+
+	require.NoError(t, storage.SavePasswordReset(ctx, reset("second", 1, now.Add(time.Minute)), time.Minute))
+
+	// Only the latest reset works, until it is deleted or expires
+	var nfErr cerrors.NotFoundError
+	_, err = storage.GetPasswordReset(ctx, "first", now.Add(time.Minute))
+	assert.ErrorAs(t, err, &nfErr)
+
+	got, err := storage.GetPasswordReset(ctx, "second", now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, reset("second", 1, now.Add(time.Minute)), got)
+
+	_, err = storage.GetPasswordReset(ctx, "second", now.Add(time.Hour+time.Minute))
+	assert.ErrorAs(t, err, &nfErr)
+
+	require.NoError(t, storage.DeletePasswordResets(ctx, 1))
+	_, err = storage.GetPasswordReset(ctx, "second", now.Add(time.Minute))
+	assert.ErrorAs(t, err, &nfErr)
+	assert.ErrorAs(t, storage.DeletePasswordResets(ctx, 1), &nfErr)
+}
+
+func TestInvitation(t *testing.T) {
+	storage, db := newStorage(t)
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	ann, err := storage.SaveUser(ctx, &entities.User{Email: "ann@example.com", Username: "ann", PassHash: "hash"})
+	require.NoError(t, err)
+	bob, err := storage.SaveUser(ctx, &entities.User{Email: "bob@example.com", Username: "bob", PassHash: "hash"})
+	require.NoError(t, err)
+	orgID, err := storage.SaveOrganization(ctx, &entities.Organization{Name: "acme", CreatedAt: now}, ann)
+	require.NoError(t, err)
+
+	invitation := func(hash string, uid int64, createdAt time.Time) *entities.Invitation {
+		return &entities.Invitation{
+			TokenHash: hash,
+			OrgID:     orgID,
+			UserID:    uid,
+			Role:      entities.RoleOwner,
+			InvitedBy: ann,
+			CreatedAt: createdAt,
+			ExpiresAt: createdAt.Add(time.Hour),
+		}
+	}
+
+	// New invitation replaces pending one
+	require.NoError(t, storage.SaveInvitation(ctx, invitation("first", bob, now)))
+	require.NoError(t, storage.SaveInvitation(ctx, invitation("second", bob, now)))
+	assert.Equal(t, 1, count(t, db, "SELECT COUNT(*) FROM invitations"))
+
+	var nfErr cerrors.NotFoundError
+	_, err = storage.AcceptInvitation(ctx, "first", bob, now)
+	assert.ErrorAs(t, err, &nfErr)
+
+	// Only invited user accepts, not after expiry
+	_, err = storage.AcceptInvitation(ctx, "second", ann, now)
+	assert.ErrorAs(t, err, &nfErr)
+	_, err = storage.AcceptInvitation(ctx, "second", bob, now.Add(time.Hour))
+	assert.ErrorAs(t, err, &nfErr)
+
+	m, err := storage.AcceptInvitation(ctx, "second", bob, now)
+	require.NoError(t, err)
+	assert.Equal(t, "acme", m.OrgName)
+	assert.Equal(t, entities.RoleOwner, m.Role)
+
+	stored, err := storage.GetMembership(ctx, orgID, bob)
+	require.NoError(t, err)
+	assert.Equal(t, entities.RoleOwner, stored.Role)
+
+	// Invitation works once
+	_, err = storage.AcceptInvitation(ctx, "second", bob, now)
+	assert.ErrorAs(t, err, &nfErr)
+
+	// and is used up by a member too
+	var aeErr cerrors.AlreadyExistsError
+	require.NoError(t, storage.SaveInvitation(ctx, invitation("third", bob, now)))
+	_, err = storage.AcceptInvitation(ctx, "third", bob, now)
+	assert.ErrorAs(t, err, &aeErr)
+	assert.Zero(t, count(t, db, "SELECT COUNT(*) FROM invitations"))
+}
+
+// Tables with rows of user. Pending ones are dropped on soft delete,
+// kept ones stay until purge.
+var (
+	pendingUserTables = []string{"email_changes", "magic_links", "password_resets", "webauthn_sessions", "invitations"}
+	keptUserTables    = []string{"webauthn_credentials", "federated_identities", "memberships", "app_grants"}
+)
+
+// saveUserData saves user with a row in every table referencing users.
+func saveUserData(t *testing.T, storage *sqlite.Storage, email string, now time.Time) int64 {
+	t.Helper()
+	ctx := context.Background()
+
+	uid, err := storage.SaveUser(ctx, &entities.User{Email: email, Username: email[:3], PassHash: "hash"})
+	require.NoError(t, err)
+
+	hash := func(kind string) string {
+		return fmt.Sprintf("%s-%d", kind, uid)
+	}
+
+	require.NoError(t, storage.SaveEmailChange(ctx, &entities.EmailChange{
+		TokenHash: hash("change"),
+		UserID:    uid,
+		NewEmail:  "new-" + email,
+		ExpiresAt: now.Add(time.Hour),
+	}))
+	require.NoError(t, storage.SaveMagicLink(ctx, &entities.MagicLink{
+		TokenHash: hash("link"),
+		UserID:    uid,
+		AppID:     1,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}, time.Minute))
+	require.NoError(t, storage.SavePasswordReset(ctx, &entities.PasswordReset{
+		TokenHash: hash("reset"),
+		UserID:    uid,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}, time.Minute))
+	require.NoError(t, storage.SaveWebAuthnSession(ctx, &entities.WebAuthnSession{
+		TokenHash: hash("session"),
+		UserID:    uid,
+		Data:      []byte("{}"),
+		ExpiresAt: now.Add(time.Hour),
+	}))
+	require.NoError(t, storage.SaveWebAuthnCredential(ctx, &entities.WebAuthnCredential{
+		ID:        []byte(hash("cred")),
+		UserID:    uid,
+		Data:      []byte("{}"),
+		CreatedAt: now,
+	}))
+	require.NoError(t, storage.SaveFederatedIdentity(ctx, &entities.FederatedIdentity{
+		Issuer:    "https://idp.example.com",
+		Subject:   hash("sub"),
+		UserID:    uid,
+		CreatedAt: now,
+	}))
+	orgID, err := storage.SaveOrganization(ctx, &entities.Organization{Name: hash("org"), CreatedAt: now}, uid)
+	require.NoError(t, err)
+	require.NoError(t, storage.SaveInvitation(ctx, &entities.Invitation{
+		TokenHash: hash("invitation"),
+		OrgID:     orgID,
+		UserID:    uid,
+		Role:      entities.RoleMember,
+		InvitedBy: uid,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}))
+	_, err = storage.SaveAppGrant(ctx, &entities.AppGrant{AppID: 1, UserID: uid, CreatedAt: now})
+	require.NoError(t, err)
+
+	return uid
+}
+
+func userRows(t *testing.T, db *sql.DB, tables []string, uid int64) map[string]int {
+	t.Helper()
+
+	rows := make(map[string]int, len(tables))
+	for _, table := range tables {
+		rows[table] = count(t, db, "SELECT COUNT(*) FROM "+table+" WHERE user_id = ?", uid)
+	}
+	return rows
+}
+
+func TestSoftDeleteUser(t *testing.T) {
+	storage, db := newStorage(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	ann := saveUserData(t, storage, "ann@example.com", now)
+	bob := saveUserData(t, storage, "bob@example.com", now)
+
+	require.NoError(t, storage.SoftDeleteUser(ctx, ann, now))
+
+	var nfErr cerrors.NotFoundError
+	_, err := storage.GetUserById(ctx, ann)
+	assert.ErrorAs(t, err, &nfErr)
+	assert.ErrorAs(t, storage.SoftDeleteUser(ctx, ann, now), &nfErr)
+
+	for table, n := range userRows(t, db, pendingUserTables, ann) {
+		assert.Zero(t, n, table)
+	}
+	for table, n := range userRows(t, db, keptUserTables, ann) {
+		assert.Equal(t, 1, n, table)
+	}
+	for table, n := range userRows(t, db, append(pendingUserTables, keptUserTables...), bob) {
+		assert.Equal(t, 1, n, table)
+	}
+
+	// Email and username are free again
+	_, err = storage.SaveUser(ctx, &entities.User{Email: "ann@example.com", Username: "ann", PassHash: "hash"})
+	assert.NoError(t, err)
+}
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	storage, db := newStorage(t)
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	ann := saveUserData(t, storage, "ann@example.com", now)
+	bob := saveUserData(t, storage, "bob@example.com", now)
+	require.NoError(t, storage.SoftDeleteUser(ctx, ann, now))
+
+	// Still within grace period
+	purged, err := storage.PurgeDeletedUsers(ctx, now.Add(-time.Second))
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+	assert.Equal(t, 1, count(t, db, "SELECT COUNT(*) FROM users WHERE id = ?", ann))
+
+	purged, err = storage.PurgeDeletedUsers(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	assert.Zero(t, count(t, db, "SELECT COUNT(*) FROM users WHERE id = ?", ann))
+
+	for table, n := range userRows(t, db, append(pendingUserTables, keptUserTables...), ann) {
+		assert.Zero(t, n, table)
+	}
+	for table, n := range userRows(t, db, append(pendingUserTables, keptUserTables...), bob) {
+		assert.Equal(t, 1, n, table)
+	}
+	_, err = storage.GetUserById(ctx, bob)
+	assert.NoError(t, err)
+}
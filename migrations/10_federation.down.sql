@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS federation_states;
+DROP INDEX IF EXISTS idx_federated_identities_user_id;
+DROP TABLE IF EXISTS federated_identities;
@@ -0,0 +1,19 @@
+-- Accounts of upstream identity providers linked to local users
+CREATE TABLE IF NOT EXISTS federated_identities (
+  issuer     TEXT NOT NULL,
+  subject    TEXT NOT NULL,
+  user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at INTEGER NOT NULL,
+  PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_federated_identities_user_id ON federated_identities(user_id);
+
+-- Pending authorization requests to upstream provider, keyed by state
+CREATE TABLE IF NOT EXISTS federation_states (
+  token_hash    TEXT PRIMARY KEY,
+  app_id        INTEGER NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+  nonce         TEXT NOT NULL,
+  code_verifier TEXT NOT NULL,
+  expires_at    INTEGER NOT NULL
+);
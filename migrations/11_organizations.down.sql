@@ -0,0 +1,4 @@
+ALTER TABLE apps DROP COLUMN org_id;
+DROP INDEX IF EXISTS idx_memberships_user_id;
+DROP TABLE IF EXISTS memberships;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_app_grants_user_id;
+DROP TABLE IF EXISTS app_grants;
+ALTER TABLE apps DROP COLUMN restricted;
@@ -0,0 +1,16 @@
+-- Restricted apps issue tokens only to users granted access
+ALTER TABLE apps ADD COLUMN restricted INTEGER NOT NULL DEFAULT 0;
+
+-- Grant is given either to user or to every member of organization
+CREATE TABLE IF NOT EXISTS app_grants (
+  id         INTEGER PRIMARY KEY AUTOINCREMENT,
+  app_id     INTEGER NOT NULL REFERENCES apps(id) ON DELETE CASCADE,
+  user_id    INTEGER REFERENCES users(id) ON DELETE CASCADE,
+  org_id     INTEGER REFERENCES organizations(id) ON DELETE CASCADE,
+  created_at INTEGER NOT NULL,
+  CHECK ((user_id IS NULL) <> (org_id IS NULL)),
+  UNIQUE (app_id, user_id),
+  UNIQUE (app_id, org_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_app_grants_user_id ON app_grants(user_id);
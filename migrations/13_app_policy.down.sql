@@ -0,0 +1,4 @@
+ALTER TABLE apps DROP COLUMN grant_types;
+ALTER TABLE apps DROP COLUMN require_mfa;
+ALTER TABLE apps DROP COLUMN refresh_token_ttl;
+ALTER TABLE apps DROP COLUMN auth_token_ttl;
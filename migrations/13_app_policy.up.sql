@@ -0,0 +1,6 @@
+-- TTLs in seconds, 0 falls back to global ones
+ALTER TABLE apps ADD COLUMN auth_token_ttl INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE apps ADD COLUMN refresh_token_ttl INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE apps ADD COLUMN require_mfa INTEGER NOT NULL DEFAULT 0;
+-- Comma separated, empty allows all
+ALTER TABLE apps ADD COLUMN grant_types TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,27 @@
+-- Fails if email or username of deleted user was registered again,
+-- purge deleted users before migrating down.
+CREATE TABLE users_old (
+  id               INTEGER PRIMARY KEY AUTOINCREMENT,
+  email            TEXT NOT NULL UNIQUE,
+  pass_hash        BLOB NOT NULL,
+  is_admin         INTEGER NOT NULL DEFAULT 0,
+  display_name     TEXT NOT NULL DEFAULT '',
+  avatar_url       TEXT NOT NULL DEFAULT '',
+  locale           TEXT NOT NULL DEFAULT '',
+  timezone         TEXT NOT NULL DEFAULT '',
+  deleted_at       INTEGER,
+  email_normalized TEXT,
+  username         TEXT
+);
+
+INSERT INTO users_old (id, email, pass_hash, is_admin, display_name, avatar_url, locale, timezone, deleted_at, email_normalized, username)
+SELECT id, email, pass_hash, is_admin, display_name, avatar_url, locale, timezone, deleted_at, email_normalized, username FROM users;
+
+DROP TABLE users;
+
+ALTER TABLE users_old RENAME TO users;
+
+CREATE INDEX IF NOT EXISTS idx_email ON users(email);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_normalized ON users(email_normalized);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users(username);
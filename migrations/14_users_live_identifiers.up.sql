@@ -0,0 +1,30 @@
+-- Soft deleted users keep their rows until purge, but not their email
+-- and username, so both can be registered again right away.
+-- UNIQUE constraint of email is part of table definition, so table
+-- is rebuilt to turn it into partial index.
+CREATE TABLE users_new (
+  id               INTEGER PRIMARY KEY AUTOINCREMENT,
+  email            TEXT NOT NULL,
+  pass_hash        BLOB NOT NULL,
+  is_admin         INTEGER NOT NULL DEFAULT 0,
+  display_name     TEXT NOT NULL DEFAULT '',
+  avatar_url       TEXT NOT NULL DEFAULT '',
+  locale           TEXT NOT NULL DEFAULT '',
+  timezone         TEXT NOT NULL DEFAULT '',
+  deleted_at       INTEGER,
+  email_normalized TEXT,
+  username         TEXT
+);
+
+INSERT INTO users_new (id, email, pass_hash, is_admin, display_name, avatar_url, locale, timezone, deleted_at, email_normalized, username)
+SELECT id, email, pass_hash, is_admin, display_name, avatar_url, locale, timezone, deleted_at, email_normalized, username FROM users;
+
+DROP TABLE users;
+
+ALTER TABLE users_new RENAME TO users;
+
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(email) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_normalized ON users(email_normalized) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users(username) WHERE deleted_at IS NULL;
@@ -0,0 +1 @@
+ALTER TABLE federation_states DROP COLUMN binding_hash;
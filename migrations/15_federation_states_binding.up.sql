@@ -0,0 +1,3 @@
+-- Hash of cookie set in browser which started login, so state and code
+-- can't be finished in another browser. Pending states without it fail.
+ALTER TABLE federation_states ADD COLUMN binding_hash TEXT NOT NULL DEFAULT '';
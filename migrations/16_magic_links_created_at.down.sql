@@ -0,0 +1 @@
+ALTER TABLE magic_links DROP COLUMN created_at;
@@ -0,0 +1,3 @@
+-- When link was sent, user gets next one not earlier than request
+-- interval after it. Pending links count as sent long ago.
+ALTER TABLE magic_links ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN deleted_at INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users(deleted_at);
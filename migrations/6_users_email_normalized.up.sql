@@ -0,0 +1,9 @@
+-- SQLite lower() folds ASCII letters only, addresses with Unicode or IDN
+-- are brought to the application form by "ssoctl normalize-emails".
+-- Creating the index fails when existing users collide, run
+-- "ssoctl email-collisions" to list them before migrating.
+ALTER TABLE users ADD COLUMN email_normalized TEXT;
+
+UPDATE users SET email_normalized = lower(trim(email));
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_normalized ON users(email_normalized);
@@ -0,0 +1,4 @@
+-- NULL usernames don't collide, so username stays optional
+ALTER TABLE users ADD COLUMN username TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users(username);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_magic_links_user_id;
+DROP TABLE IF EXISTS magic_links;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+  id           BLOB PRIMARY KEY,
+  user_id      INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name         TEXT NOT NULL DEFAULT '',
+  data         BLOB NOT NULL,
+  created_at   INTEGER NOT NULL,
+  last_used_at INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+
+-- Pending ceremonies, user_id is NULL for login and app_id for registration
+CREATE TABLE IF NOT EXISTS webauthn_sessions (
+  token_hash TEXT PRIMARY KEY,
+  user_id    INTEGER REFERENCES users(id) ON DELETE CASCADE,
+  app_id     INTEGER REFERENCES apps(id) ON DELETE CASCADE,
+  data       BLOB NOT NULL,
+  expires_at INTEGER NOT NULL
+);